@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// breakerState is exported as the stream_circuit_breaker_state gauge.
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "open"
+}
+
+// circuitBreaker stops calls to a failing dependency for a cooldown period
+// after maxAttempts consecutive failures.
+type circuitBreaker struct {
+	mutex        sync.Mutex
+	failureCount int
+	lastFailure  time.Time
+	open         bool
+	halfOpen     bool // A trial call is allowed after the cooldown
+	maxAttempts  int
+	cooldown     time.Duration
+}
+
+func newCircuitBreaker(maxAttempts int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{maxAttempts: maxAttempts, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go ahead. Once the cooldown has passed an
+// open breaker lets a trial call through; a single further failure reopens it.
+func (cb *circuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.open && time.Since(cb.lastFailure) > cb.cooldown {
+		cb.open = false
+		cb.halfOpen = true
+		cb.failureCount = cb.maxAttempts - 1
+		slog.Info("Circuit breaker half-open, allowing a trial call")
+	}
+	return !cb.open
+}
+
+// Trip records a failed call and opens the breaker when the limit is reached.
+func (cb *circuitBreaker) Trip() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.failureCount++
+	cb.lastFailure = time.Now()
+	if cb.failureCount >= cb.maxAttempts && !cb.open {
+		cb.open = true
+		cb.halfOpen = false
+		slog.Warn("Circuit breaker opened", "failures", cb.failureCount, "cooldown", cb.cooldown)
+	}
+}
+
+// Reset records a successful call and closes the breaker.
+func (cb *circuitBreaker) Reset() {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	if cb.open || cb.halfOpen {
+		slog.Info("Circuit breaker closed")
+	}
+	cb.failureCount = 0
+	cb.open = false
+	cb.halfOpen = false
+}
+
+// State reports whether calls are flowing, blocked, or being trialled.
+func (cb *circuitBreaker) State() breakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch {
+	case cb.open && time.Since(cb.lastFailure) <= cb.cooldown:
+		return breakerOpen
+	case cb.open || cb.halfOpen:
+		return breakerHalfOpen
+	}
+	return breakerClosed
+}
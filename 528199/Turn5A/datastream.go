@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// OverflowPolicy decides what AddData does when the stream buffer is full.
+type OverflowPolicy int
+
+const (
+	Block       OverflowPolicy = iota // Wait until a consumer frees a slot
+	DropOldest                        // Evict the oldest queued batch to make room
+	DropNewest                        // Reject the incoming batch
+	SpillToDisk                       // Append the batch to a temporary file and replay it later
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case SpillToDisk:
+		return "spill-to-disk"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+var (
+	ErrStreamClosed = errors.New("data stream closed")
+	ErrBatchDropped = errors.New("data stream full, batch dropped")
+)
+
+// QueueStats is a point-in-time snapshot of a DataStream's queue.
+type QueueStats struct {
+	Depth         int           `json:"depth"`          // Batches waiting, in memory and on disk
+	Spilled       int           `json:"spilled"`        // Batches currently waiting on disk
+	MaxDepth      int           `json:"max_depth"`      // High-water mark of Depth
+	Capacity      int           `json:"capacity"`       // In-memory buffer size
+	Enqueued      uint64        `json:"enqueued"`       // Batches accepted by AddData
+	Dequeued      uint64        `json:"dequeued"`       // Batches handed to consumers
+	DroppedOldest uint64        `json:"dropped_oldest"` // Batches evicted under DropOldest
+	DroppedNewest uint64        `json:"dropped_newest"` // Batches rejected under DropNewest
+	SpilledTotal  uint64        `json:"spilled_total"`  // Batches ever written to disk
+	BlockedTime   time.Duration `json:"blocked_time"`   // Total time producers spent waiting under Block
+	Rejected      uint64        `json:"rejected"`       // Batches refused because the stream was closed
+	Discarded     uint64        `json:"discarded"`      // Batches still waiting when Discard gave up on them
+}
+
+// Batch is a run of data points from one stream, numbered in arrival order.
+type Batch struct {
+	StreamID  int       `json:"stream_id"`
+	BatchID   int       `json:"batch_id"`
+	Values    []int     `json:"values"`
+	CreatedAt time.Time `json:"created_at"` // When the batch was queued, for latency tracking
+}
+
+// DataStream is a bounded batch queue between producers and processDataStream workers.
+type DataStream struct {
+	mu       sync.Mutex
+	buf      []Batch
+	capacity int
+	policy   OverflowPolicy
+	spill    *spillFile
+	closed   bool
+	stats    QueueStats
+
+	readable chan struct{} // Signalled when a batch becomes available
+	writable chan struct{} // Signalled when a slot frees up
+	done     chan struct{} // Closed by Close
+}
+
+// NewDataStream creates a stream holding up to bufferSize batches in memory.
+func NewDataStream(bufferSize int, policy OverflowPolicy) (*DataStream, error) {
+	if bufferSize < 1 {
+		return nil, fmt.Errorf("buffer size must be positive, got %d", bufferSize)
+	}
+	ds := &DataStream{
+		buf:      make([]Batch, 0, bufferSize),
+		capacity: bufferSize,
+		policy:   policy,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	ds.stats.Capacity = bufferSize
+	if policy == SpillToDisk {
+		spill, err := newSpillFile()
+		if err != nil {
+			return nil, err
+		}
+		ds.spill = spill
+	}
+	return ds, nil
+}
+
+// AddData queues a batch according to the stream's overflow policy. Under
+// Block it waits for space without holding the stream lock, so consumers and
+// other producers keep making progress.
+func (ds *DataStream) AddData(data Batch) error {
+	var waitStart time.Time
+	for {
+		ds.mu.Lock()
+		if ds.closed {
+			ds.stats.Rejected++
+			ds.mu.Unlock()
+			return ErrStreamClosed
+		}
+		if !waitStart.IsZero() {
+			ds.stats.BlockedTime += time.Since(waitStart)
+			waitStart = time.Time{}
+		}
+
+		// Once batches are on disk, new ones follow them there to keep FIFO order.
+		if ds.spill != nil && ds.spill.pending > 0 {
+			err := ds.spillLocked(data)
+			ds.mu.Unlock()
+			notify(ds.readable)
+			return err
+		}
+
+		if len(ds.buf) < ds.capacity {
+			ds.pushLocked(data)
+			ds.mu.Unlock()
+			notify(ds.readable)
+			return nil
+		}
+
+		switch ds.policy {
+		case DropNewest:
+			ds.stats.DroppedNewest++
+			ds.mu.Unlock()
+			return ErrBatchDropped
+		case DropOldest:
+			ds.buf[0] = Batch{}
+			ds.buf = ds.buf[1:]
+			ds.stats.DroppedOldest++
+			ds.pushLocked(data)
+			ds.mu.Unlock()
+			notify(ds.readable)
+			return nil
+		case SpillToDisk:
+			err := ds.spillLocked(data)
+			ds.mu.Unlock()
+			notify(ds.readable)
+			return err
+		}
+
+		waitStart = time.Now()
+		ds.mu.Unlock()
+		select {
+		case <-ds.writable:
+		case <-ds.done:
+		}
+	}
+}
+
+func (ds *DataStream) pushLocked(data Batch) {
+	ds.buf = append(ds.buf, data)
+	ds.stats.Enqueued++
+	ds.trackDepthLocked()
+	if len(ds.buf) < ds.capacity {
+		notify(ds.writable) // Pass the wake-up on to the next blocked producer
+	}
+}
+
+func (ds *DataStream) spillLocked(data Batch) error {
+	if err := ds.spill.write(data); err != nil {
+		return fmt.Errorf("spill batch: %w", err)
+	}
+	ds.stats.Enqueued++
+	ds.stats.SpilledTotal++
+	ds.trackDepthLocked()
+	return nil
+}
+
+func (ds *DataStream) trackDepthLocked() {
+	if depth := ds.depthLocked(); depth > ds.stats.MaxDepth {
+		ds.stats.MaxDepth = depth
+	}
+}
+
+func (ds *DataStream) depthLocked() int {
+	depth := len(ds.buf)
+	if ds.spill != nil {
+		depth += ds.spill.pending
+	}
+	return depth
+}
+
+// Next blocks until a batch is available or ctx is done. It returns false
+// once ctx is done, or once the stream is closed and fully drained.
+func (ds *DataStream) Next(ctx context.Context) (Batch, bool) {
+	for {
+		ds.mu.Lock()
+		if data, ok := ds.popLocked(); ok {
+			more := ds.depthLocked() > 0
+			ds.mu.Unlock()
+			notify(ds.writable)
+			if more {
+				notify(ds.readable) // Wake another consumer for the remaining batches
+			}
+			return data, true
+		}
+		if ds.closed {
+			ds.releaseSpillLocked()
+			ds.mu.Unlock()
+			return Batch{}, false
+		}
+		ds.mu.Unlock()
+
+		select {
+		case <-ds.readable:
+		case <-ds.done:
+		case <-ctx.Done():
+			return Batch{}, false
+		}
+	}
+}
+
+func (ds *DataStream) popLocked() (Batch, bool) {
+	ds.refillLocked()
+	if len(ds.buf) == 0 {
+		return Batch{}, false
+	}
+	data := ds.buf[0]
+	ds.buf[0] = Batch{}
+	ds.buf = ds.buf[1:]
+	ds.stats.Dequeued++
+	ds.refillLocked()
+	return data, true
+}
+
+// refillLocked moves spilled batches back into memory as slots free up, so
+// they keep their place in line ahead of anything added later.
+func (ds *DataStream) refillLocked() {
+	for ds.spill != nil && ds.spill.pending > 0 && len(ds.buf) < ds.capacity {
+		data, err := ds.spill.read()
+		if err != nil {
+			slog.Error("Discarding unreadable spilled batch", "error", err)
+			continue
+		}
+		ds.buf = append(ds.buf, data)
+	}
+}
+
+func (ds *DataStream) releaseSpillLocked() {
+	if ds.spill == nil || ds.spill.pending > 0 {
+		return
+	}
+	if err := ds.spill.Close(); err != nil {
+		slog.Warn("Failed to remove spill file", "error", err)
+	}
+	ds.spill = nil
+}
+
+// Close stops accepting new batches. Batches already queued are still
+// delivered by Next, after which it reports the stream as drained.
+func (ds *DataStream) Close() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.closed {
+		return
+	}
+	ds.closed = true
+	close(ds.done)
+}
+
+// Discard closes the stream and throws away every batch still waiting in it,
+// including any spilled to disk. It returns how many batches were dropped.
+func (ds *DataStream) Discard() int {
+	ds.Close()
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	n := ds.depthLocked()
+	clear(ds.buf)
+	ds.buf = ds.buf[:0]
+	if ds.spill != nil {
+		ds.spill.pending = 0
+		ds.releaseSpillLocked()
+	}
+	ds.stats.Discarded += uint64(n)
+	return n
+}
+
+// Depth returns the number of batches waiting to be consumed.
+func (ds *DataStream) Depth() int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.depthLocked()
+}
+
+// Stats returns a snapshot of the stream's queue metrics.
+func (ds *DataStream) Stats() QueueStats {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	stats := ds.stats
+	stats.Depth = ds.depthLocked()
+	if ds.spill != nil {
+		stats.Spilled = ds.spill.pending
+	}
+	return stats
+}
+
+// notify performs a non-blocking send on a one-slot signal channel.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// spillFile is an append-only JSON-lines file of overflowed batches,
+// read back in the order they were written.
+type spillFile struct {
+	w       *os.File
+	r       *os.File
+	reader  *bufio.Reader
+	pending int
+}
+
+func newSpillFile() (*spillFile, error) {
+	w, err := os.CreateTemp("", "datastream-spill-*.jsonl")
+	if err != nil {
+		return nil, fmt.Errorf("create spill file: %w", err)
+	}
+	r, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, fmt.Errorf("open spill file: %w", err)
+	}
+	return &spillFile{w: w, r: r, reader: bufio.NewReader(r)}, nil
+}
+
+func (s *spillFile) write(data Batch) error {
+	line, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.pending++
+	return nil
+}
+
+func (s *spillFile) read() (Batch, error) {
+	line, err := s.reader.ReadBytes('\n')
+	s.pending--
+	if s.pending == 0 {
+		// Nothing left on disk, so start the file over instead of letting it grow.
+		if resetErr := s.reset(); resetErr != nil && err == nil {
+			err = resetErr
+		}
+	}
+	if err != nil {
+		return Batch{}, err
+	}
+	var data Batch
+	if err := json.Unmarshal(line, &data); err != nil {
+		return Batch{}, err
+	}
+	return data, nil
+}
+
+func (s *spillFile) reset() error {
+	if err := s.w.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := s.w.Seek(0, 0); err != nil {
+		return err
+	}
+	if _, err := s.r.Seek(0, 0); err != nil {
+		return err
+	}
+	s.reader.Reset(s.r)
+	return nil
+}
+
+// Close closes and removes the spill file.
+func (s *spillFile) Close() error {
+	s.r.Close()
+	err := s.w.Close()
+	if removeErr := os.Remove(s.w.Name()); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// drain closes ds and returns the values of every batch left in it.
+func drain(t *testing.T, ds *DataStream) [][]int {
+	t.Helper()
+	ds.Close()
+	var got [][]int
+	for {
+		batch, ok := ds.Next(context.Background())
+		if !ok {
+			return got
+		}
+		got = append(got, batch.Values)
+	}
+}
+
+func TestDataStreamDropNewest(t *testing.T) {
+	ds, err := NewDataStream(2, DropNewest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		err := ds.AddData(Batch{Values: []int{i}})
+		if i <= 2 && err != nil {
+			t.Fatalf("batch %d: unexpected error %v", i, err)
+		}
+		if i > 2 && !errors.Is(err, ErrBatchDropped) {
+			t.Fatalf("batch %d: expected ErrBatchDropped, got %v", i, err)
+		}
+	}
+
+	if got, want := drain(t, ds), [][]int{{1}, {2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if stats := ds.Stats(); stats.DroppedNewest != 2 || stats.Enqueued != 2 {
+		t.Errorf("expected 2 dropped and 2 enqueued, got %+v", stats)
+	}
+}
+
+func TestDataStreamDropOldest(t *testing.T) {
+	ds, err := NewDataStream(2, DropOldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 4; i++ {
+		if err := ds.AddData(Batch{Values: []int{i}}); err != nil {
+			t.Fatalf("batch %d: unexpected error %v", i, err)
+		}
+	}
+
+	if got, want := drain(t, ds), [][]int{{3}, {4}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if stats := ds.Stats(); stats.DroppedOldest != 2 {
+		t.Errorf("expected 2 dropped, got %d", stats.DroppedOldest)
+	}
+}
+
+func TestDataStreamSpillPreservesOrder(t *testing.T) {
+	ds, err := NewDataStream(2, SpillToDisk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want [][]int
+	for i := 1; i <= 6; i++ {
+		if err := ds.AddData(Batch{Values: []int{i, i * 10}}); err != nil {
+			t.Fatalf("batch %d: unexpected error %v", i, err)
+		}
+		want = append(want, []int{i, i * 10})
+	}
+	if stats := ds.Stats(); stats.Spilled != 4 || stats.Depth != 6 {
+		t.Fatalf("expected 4 spilled of 6 waiting, got %+v", stats)
+	}
+
+	// Consume one, add one more: the new batch must queue behind the spilled ones.
+	first, _ := ds.Next(context.Background())
+	if err := ds.AddData(Batch{Values: []int{7, 70}}); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, []int{7, 70})
+
+	got := append([][]int{first.Values}, drain(t, ds)...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if ds.spill != nil {
+		t.Error("expected spill file to be released after draining")
+	}
+}
+
+func TestDataStreamBlockWaitsForConsumer(t *testing.T) {
+	ds, err := NewDataStream(1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ds.AddData(Batch{Values: []int{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	added := make(chan error)
+	go func() { added <- ds.AddData(Batch{Values: []int{2}}) }()
+
+	select {
+	case err := <-added:
+		t.Fatalf("expected producer to block on a full stream, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Stats must stay readable while a producer is blocked.
+	if depth := ds.Depth(); depth != 1 {
+		t.Errorf("expected depth 1, got %d", depth)
+	}
+
+	if batch, _ := ds.Next(context.Background()); batch.Values[0] != 1 {
+		t.Errorf("expected first batch, got %v", batch.Values)
+	}
+	if err := <-added; err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if stats := ds.Stats(); stats.BlockedTime <= 0 {
+		t.Error("expected blocked time to be recorded")
+	}
+}
+
+func TestDataStreamCloseReleasesBlockedProducer(t *testing.T) {
+	ds, err := NewDataStream(1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ds.AddData(Batch{Values: []int{1}})
+
+	added := make(chan error)
+	go func() { added <- ds.AddData(Batch{Values: []int{2}}) }()
+	time.Sleep(20 * time.Millisecond)
+	ds.Close()
+
+	if err := <-added; !errors.Is(err, ErrStreamClosed) {
+		t.Errorf("expected ErrStreamClosed, got %v", err)
+	}
+}
+
+func TestDataStreamNextHonoursContext(t *testing.T) {
+	ds, err := NewDataStream(1, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := ds.Next(ctx); ok {
+		t.Error("expected Next to give up when the context expires")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+const (
+	logFile       = "system.log"
+	logMaxBytes   = 10 << 20 // Rotate once the active log file reaches this size
+	logMaxBackups = 5        // Rotated files kept as system.log.1 (newest) to system.log.5
+)
+
+// setupLogging sends JSON log records to a rotating log file and makes that the
+// default for both slog and the standard log package. The returned Closer
+// flushes and closes the file.
+func setupLogging(path string, level slog.Leveler) (io.Closer, error) {
+	file, err := openRotatingFile(path, logMaxBytes, logMaxBackups)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level})))
+	slog.Info("Logging initiated", "path", path)
+	return file, nil
+}
+
+// rotatingFile is an append-only log file that is renamed to path.1 once it
+// reaches maxBytes, shifting older backups up and deleting the oldest.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("stat log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends one log record, rotating first if it would overflow the file.
+// Records are never split across files.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("close log file: %w", err)
+	}
+	os.Remove(f.backupName(f.maxBackups))
+	for i := f.maxBackups - 1; i >= 1; i-- {
+		os.Rename(f.backupName(i), f.backupName(i+1)) // Missing backups are fine
+	}
+	if f.maxBackups > 0 {
+		if err := os.Rename(f.path, f.backupName(1)); err != nil {
+			return fmt.Errorf("rotate log file: %w", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("rotate log file: %w", err)
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileRotatesAndPrunes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system.log")
+	f, err := openRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	line := bytes.Repeat([]byte("x"), 59)
+	line = append(line, '\n')
+	for i := 0; i < 5; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each 60-byte record overflows a 100-byte file, so every write after the
+	// first rotates, and only the two newest backups are kept.
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", filepath.Base(name), err)
+		}
+		if !bytes.Equal(data, line) {
+			t.Errorf("%s: expected one whole record, got %d bytes", filepath.Base(name), len(data))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected backups beyond maxBackups to be removed")
+	}
+}
+
+func TestRotatingFileAppendsToExistingLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "system.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	f, err := openRotatingFile(path, 1<<20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("this run\n"))
+	f.Close()
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "previous run\nthis run\n" {
+		t.Errorf("unexpected log contents %q", data)
+	}
+}
+
+func TestStructuredBatchLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(prev)
+
+	ds, err := NewDataStream(4, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan BatchResult, 1)
+	pool := newWorkerPool(context.Background(), ds, results)
+	pool.Grow()
+	ds.AddData(Batch{StreamID: 2, BatchID: 5, Values: []int{1, 2}, CreatedAt: time.Now()})
+	<-results
+	ds.Close()
+	pool.Wait()
+
+	var record map[string]any
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record); err != nil {
+		t.Fatalf("expected one JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Batch processed" || record["stream_id"] != float64(2) || record["batch_id"] != float64(5) {
+		t.Errorf("unexpected record %v", record)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	bufferSize       = 100              // In-memory buffer size for the data stream
+	statsInterval    = time.Second      // How often queue metrics are logged
+	overflowPolicy   = SpillToDisk      // What producers do when the buffer is full
+	sinkBatchSize    = 20               // Batch results per database transaction
+	sinkFlushEvery   = 2 * time.Second  // Flush partial transactions at least this often
+	maxFailedFlushes = 3                // Consecutive failed flushes before the breaker opens
+	cooldownDuration = 5 * time.Second  // Circuit breaker cooldown
+	drainTimeout     = 10 * time.Second // How long shutdown waits for queued batches
+	flushTimeout     = 5 * time.Second  // How long shutdown waits for the final sink flush
+)
+
+var scaling = ScalingConfig{
+	MinWorkers:     1,
+	MaxWorkers:     8,
+	Interval:       500 * time.Millisecond,
+	ScaleUpDepth:   bufferSize / 2,
+	ScaleDownDepth: bufferSize / 10,
+}
+
+func main() {
+	ctx := context.Background()
+
+	// LOG_LEVEL takes debug, info, warn, or error; debug logs every processed batch.
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	logCloser, err := setupLogging(logFile, level)
+	if err != nil {
+		log.Fatal("Failed to open log file:", err)
+	}
+	defer logCloser.Close()
+
+	// Set RESULTS_DSN, e.g. "user=postgres password=example dbname=postgres sslmode=disable",
+	// to store batch results in PostgreSQL. Without it they are kept in memory.
+	var sink ResultSink = NewMemorySink()
+	if dsn := os.Getenv("RESULTS_DSN"); dsn != "" {
+		pgSink, err := OpenPostgresSink(ctx, dsn, defaultSQLSinkConfig)
+		if err != nil {
+			fatal("Failed to open result sink", err)
+		}
+		sink = pgSink
+	}
+	defer sink.Close()
+
+	sources, err := configuredSources(os.Getenv("STREAM_SOURCES"))
+	if err != nil {
+		fatal("Invalid STREAM_SOURCES", err)
+	}
+
+	p, err := newPipeline(pipelineConfig{
+		bufferSize:       bufferSize,
+		overflow:         overflowPolicy,
+		scaling:          scaling,
+		sinkBatchSize:    sinkBatchSize,
+		sinkFlushEvery:   sinkFlushEvery,
+		maxFailedFlushes: maxFailedFlushes,
+		cooldown:         cooldownDuration,
+		drainTimeout:     drainTimeout,
+		flushTimeout:     flushTimeout,
+	}, sources, sink)
+	if err != nil {
+		fatal("Failed to create data stream", err)
+	}
+
+	metrics.registry.GaugeFunc("stream_queue_depth", "Batches waiting in the data stream.",
+		func() float64 { return float64(p.ds.Depth()) })
+	metrics.registry.GaugeFunc("stream_workers", "Running processDataStream workers.",
+		func() float64 { return float64(p.pool.Size()) })
+	metrics.registry.GaugeFunc("stream_circuit_breaker_state", "Result sink breaker: 0 closed, 1 half-open, 2 open.",
+		func() float64 { return float64(p.breaker.State()) })
+
+	// Set METRICS_ADDR, e.g. ":9100", to serve the metrics above on /metrics.
+	if addr := os.Getenv("METRICS_ADDR"); addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.registry)
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				slog.Error("Metrics endpoint stopped", "addr", addr, "error", err)
+			}
+		}()
+		slog.Info("Serving metrics", "addr", addr)
+	}
+
+	// Ctrl-C or SIGTERM stops the sources and drains what is already queued
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	report := p.run(ctx)
+	summary, stats := report.Sink, report.Queue
+
+	if report.Interrupted {
+		fmt.Println("Interrupted, drained in-flight batches before exiting")
+	}
+	fmt.Printf("Total sum of all processed batches across all streams: %d\n", summary.TotalSum)
+	fmt.Printf("Batch results written: %d of %d, discarded: %d\n", summary.Written, summary.Received, summary.Discarded)
+	fmt.Printf("Batches enqueued: %d, processed: %d, dropped: %d, spilled: %d, max depth: %d, producer wait: %s\n",
+		stats.Enqueued, stats.Dequeued, stats.DroppedOldest+stats.DroppedNewest, stats.SpilledTotal, stats.MaxDepth, stats.BlockedTime)
+	if report.TimedOut {
+		fmt.Printf("Drain deadline of %s passed: %d batches left unprocessed, %d abandoned mid-flight, %d rejected\n",
+			drainTimeout, stats.Discarded, report.Abandoned, stats.Rejected)
+	}
+	fmt.Printf("Batches discarded in total: %d\n", report.Discarded())
+}
+
+// logQueueStats periodically reports queue depth and worker count until ctx is cancelled.
+func logQueueStats(ctx context.Context, ds *DataStream, pool *workerPool) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats := ds.Stats()
+			slog.Info("Queue stats", "depth", stats.Depth, "spilled", stats.Spilled, "workers", pool.Size(),
+				"enqueued", stats.Enqueued, "dequeued", stats.Dequeued, "dropped", stats.DroppedOldest+stats.DroppedNewest)
+		}
+	}
+}
+
+// configuredSources parses STREAM_SOURCES, a comma-separated list of source
+// specs such as "tail:/var/log/points.log,json+tcp::9000". Each entry becomes
+// one stream. When it is empty, numStreams synthetic streams are used.
+func configuredSources(specs string) ([]Source, error) {
+	var sources []Source
+	if strings.TrimSpace(specs) == "" {
+		for i := 0; i < numStreams; i++ {
+			sources = append(sources, SyntheticSource{Count: streamSize, MaxDelay: 50 * time.Millisecond})
+		}
+		return sources, nil
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		source, err := parseSourceSpec(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// fatal logs err and also prints it to stderr, since the log itself goes to a file.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+	os.Exit(1)
+}
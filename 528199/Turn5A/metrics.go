@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// metricsRegistry holds in-process metrics and renders them in the
+// Prometheus text exposition format.
+type metricsRegistry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{}
+}
+
+func (r *metricsRegistry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Counter registers a monotonically increasing metric, partitioned by label if it is non-empty.
+func (r *metricsRegistry) Counter(name, help, label string) *counterVec {
+	c := &counterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Histogram registers a distribution with the given upper bucket bounds, partitioned by label if it is non-empty.
+func (r *metricsRegistry) Histogram(name, help, label string, buckets []float64) *histogramVec {
+	h := &histogramVec{name: name, help: help, label: label, buckets: buckets, series: make(map[string]*histogram)}
+	r.register(h)
+	return h
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time.
+func (r *metricsRegistry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(&gaugeFunc{name: name, help: help, fn: fn})
+}
+
+// WritePrometheus writes every registered metric in registration order.
+func (r *metricsRegistry) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP exposes the registry as a Prometheus scrape target.
+func (r *metricsRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(w)
+}
+
+type counterVec struct {
+	name, help, label string
+	mu                sync.Mutex
+	values            map[string]float64 // Keyed by label value
+}
+
+// Inc adds one to the series for labelValue.
+func (c *counterVec) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+// Add adds v, which must not be negative, to the series for labelValue.
+func (c *counterVec) Add(labelValue string, v float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue] += v
+}
+
+// Value returns the current value of the series for labelValue.
+func (c *counterVec) Value(labelValue string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *counterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	if c.label == "" {
+		writeSample(w, c.name, "", c.values[""])
+		return
+	}
+	for _, lv := range sortedKeys(c.values) {
+		writeSample(w, c.name, labelPair(c.label, lv), c.values[lv])
+	}
+}
+
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (g *gaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	writeSample(w, g.name, "", g.fn())
+}
+
+type histogramVec struct {
+	name, help, label string
+	buckets           []float64
+	mu                sync.Mutex
+	series            map[string]*histogram // Keyed by label value
+}
+
+type histogram struct {
+	counts []uint64 // Per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Observe records v in the series for labelValue.
+func (h *histogramVec) Observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[labelValue]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, lv := range sortedKeys(h.series) {
+		s := h.series[lv]
+		base := ""
+		if h.label != "" {
+			base = labelPair(h.label, lv) + ","
+		}
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", base+labelPair("le", formatFloat(bound)), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", base+labelPair("le", "+Inf"), float64(s.count))
+		writeSample(w, h.name+"_sum", trimComma(base), s.sum)
+		writeSample(w, h.name+"_count", trimComma(base), float64(s.count))
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeSample(w *bufio.Writer, name, labels string, v float64) {
+	if labels != "" {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(v))
+		return
+	}
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(v))
+}
+
+func labelPair(name, value string) string {
+	return name + "=" + strconv.Quote(value)
+}
+
+func trimComma(s string) string {
+	if len(s) > 0 && s[len(s)-1] == ',' {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pipelineMetrics are the counters and histograms recorded by the stream processor.
+type pipelineMetrics struct {
+	registry          *metricsRegistry
+	batchesProcessed  *counterVec
+	batchFailures     *counterVec
+	sinkRetries       *counterVec
+	sinkWriteFailures *counterVec
+	batchLatency      *histogramVec
+}
+
+func newPipelineMetrics() *pipelineMetrics {
+	r := newMetricsRegistry()
+	return &pipelineMetrics{
+		registry: r,
+		batchesProcessed: r.Counter("stream_batches_processed_total",
+			"Batches aggregated by a worker.", "stream_id"),
+		batchFailures: r.Counter("stream_batch_failures_total",
+			"Batch results discarded because the result sink kept failing.", "stream_id"),
+		sinkRetries: r.Counter("stream_sink_retries_total",
+			"Result sink transactions retried after a transient error.", ""),
+		sinkWriteFailures: r.Counter("stream_sink_write_failures_total",
+			"Result sink flushes that failed after all retries.", ""),
+		batchLatency: r.Histogram("stream_batch_latency_seconds",
+			"Time from a batch being queued to its aggregates being computed.", "stream_id",
+			[]float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}),
+	}
+}
+
+// metrics is the process-wide metric set, served on /metrics when METRICS_ADDR is set.
+var metrics = newPipelineMetrics()
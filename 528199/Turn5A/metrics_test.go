@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRegistryExposition(t *testing.T) {
+	r := newMetricsRegistry()
+	processed := r.Counter("batches_total", "Batches processed.", "stream_id")
+	retries := r.Counter("retries_total", "Retries.", "")
+	latency := r.Histogram("latency_seconds", "Batch latency.", "stream_id", []float64{0.1, 1})
+	r.GaugeFunc("queue_depth", "Queue depth.", func() float64 { return 7 })
+
+	processed.Inc("1")
+	processed.Add("0", 2)
+	retries.Inc("")
+	latency.Observe("0", 0.05)
+	latency.Observe("0", 0.5)
+	latency.Observe("0", 3)
+
+	var sb strings.Builder
+	if err := r.WritePrometheus(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP batches_total Batches processed.
+# TYPE batches_total counter
+batches_total{stream_id="0"} 2
+batches_total{stream_id="1"} 1
+# HELP retries_total Retries.
+# TYPE retries_total counter
+retries_total 1
+# HELP latency_seconds Batch latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{stream_id="0",le="0.1"} 1
+latency_seconds_bucket{stream_id="0",le="1"} 2
+latency_seconds_bucket{stream_id="0",le="+Inf"} 3
+latency_seconds_sum{stream_id="0"} 3.55
+latency_seconds_count{stream_id="0"} 3
+# HELP queue_depth Queue depth.
+# TYPE queue_depth gauge
+queue_depth 7
+`
+	if got := sb.String(); got != want {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", got, want)
+	}
+}
+
+func TestMetricsRegistryServesHTTP(t *testing.T) {
+	r := newMetricsRegistry()
+	r.Counter("up_total", "Up.", "").Inc("")
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "up_total 1\n") {
+		t.Errorf("expected counter in body, got:\n%s", body)
+	}
+}
+
+func TestSinkWriterRecordsFailureMetrics(t *testing.T) {
+	before := metrics.batchFailures.Value("0")
+	sink := NewMemorySink()
+	sink.FailNext(1, io.ErrUnexpectedEOF)
+	writer := newSinkWriter(sink, newCircuitBreaker(1, time.Minute), 10, time.Hour)
+
+	writer.run(context.Background(), sendResults(3))
+
+	if got := metrics.batchFailures.Value("0") - before; got != 3 {
+		t.Errorf("expected 3 failed batches recorded for stream 0, got %v", got)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize    = 10  // Data points per batch
+	streamSize   = 500 // Number of data points generated by each synthetic source
+	maxQueueSize = 10  // Buffer between a stream's source and its batcher
+	numStreams   = 3   // Number of synthetic streams when STREAM_SOURCES is not set
+)
+
+// handleStream cuts the data points from one source into numbered batches and
+// queues them on ds. Cancelling ctx stops the source; the data points already
+// read still go out as a final, possibly short, batch.
+func handleStream(ctx context.Context, streamId int, source Source, ds *DataStream, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	dataStream := make(chan int, maxQueueSize)
+	go func() {
+		if err := source.Run(ctx, dataStream); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("Source failed", "stream_id", streamId, "source", source.String(), "error", err)
+		}
+	}()
+
+	batchCounter := 0
+	currentBatch := make([]int, 0, batchSize)
+	queueBatch := func() {
+		// Pass a copy of the current batch so it can be reused for the next one
+		batch := Batch{
+			StreamID:  streamId,
+			BatchID:   batchCounter,
+			Values:    append([]int(nil), currentBatch...),
+			CreatedAt: time.Now(),
+		}
+		if err := ds.AddData(batch); err != nil {
+			slog.Warn("Batch not queued", "stream_id", streamId, "batch_id", batchCounter, "error", err)
+		}
+		batchCounter++
+		currentBatch = currentBatch[:0]
+	}
+
+	for dataPoint := range dataStream {
+		currentBatch = append(currentBatch, dataPoint)
+		if len(currentBatch) == batchSize {
+			queueBatch()
+		}
+	}
+
+	// Queue the remaining data in the last batch
+	if len(currentBatch) > 0 {
+		queueBatch()
+	}
+}
+
+// processDataBatch reduces a batch to the aggregates stored by the result sink.
+// It refuses to start once ctx is done.
+func processDataBatch(ctx context.Context, batch Batch) (BatchResult, error) {
+	if err := ctx.Err(); err != nil {
+		return BatchResult{}, err
+	}
+	result := BatchResult{
+		StreamID:    batch.StreamID,
+		BatchID:     batch.BatchID,
+		Count:       len(batch.Values),
+		ProcessedAt: time.Now(),
+	}
+	for i, value := range batch.Values {
+		result.Sum += value
+		if i == 0 || value < result.Min {
+			result.Min = value
+		}
+		if i == 0 || value > result.Max {
+			result.Max = value
+		}
+	}
+	return result, nil
+}
+
+// pipelineConfig holds the tunables main passes to newPipeline.
+type pipelineConfig struct {
+	bufferSize       int
+	overflow         OverflowPolicy
+	scaling          ScalingConfig
+	sinkBatchSize    int
+	sinkFlushEvery   time.Duration
+	maxFailedFlushes int
+	cooldown         time.Duration
+	drainTimeout     time.Duration // How long queued batches may take to finish once shutdown starts
+	flushTimeout     time.Duration // How long the final sink flush may take
+}
+
+// pipeline wires sources, the data stream, the worker pool, and the result
+// sink together for one run.
+type pipeline struct {
+	cfg     pipelineConfig
+	sources []Source
+	sink    ResultSink
+	ds      *DataStream
+	results chan BatchResult
+	pool    *workerPool
+	breaker *circuitBreaker
+
+	drainCtx   context.Context // Cancelled when the drain deadline passes
+	abortDrain context.CancelFunc
+}
+
+func newPipeline(cfg pipelineConfig, sources []Source, sink ResultSink) (*pipeline, error) {
+	ds, err := NewDataStream(cfg.bufferSize, cfg.overflow)
+	if err != nil {
+		return nil, err
+	}
+	p := &pipeline{
+		cfg:     cfg,
+		sources: sources,
+		sink:    sink,
+		ds:      ds,
+		results: make(chan BatchResult, cfg.bufferSize),
+		breaker: newCircuitBreaker(cfg.maxFailedFlushes, cfg.cooldown),
+	}
+	p.drainCtx, p.abortDrain = context.WithCancel(context.Background())
+	p.pool = newWorkerPool(p.drainCtx, ds, p.results)
+	return p, nil
+}
+
+// ShutdownReport accounts for every batch a pipeline run saw.
+type ShutdownReport struct {
+	Interrupted bool        // ctx was cancelled before every source was exhausted
+	TimedOut    bool        // The drain deadline passed with work still in flight
+	Queue       QueueStats  // Final data stream counters, including batches discarded at the deadline
+	Abandoned   int         // Batches taken by a worker whose result never reached the sink writer
+	Sink        SinkSummary // What happened to the results that did reach it
+}
+
+// Discarded returns how many batches never made it into the sink, for any reason.
+func (r ShutdownReport) Discarded() int {
+	lost := r.Queue.DroppedOldest + r.Queue.DroppedNewest + r.Queue.Rejected + r.Queue.Discarded
+	return int(lost) + r.Abandoned + r.Sink.Discarded
+}
+
+// run streams every source through the pipeline until the sources are
+// exhausted or ctx is cancelled. Cancelling ctx stops the sources; batches
+// already cut are still processed and written if that finishes within
+// drainTimeout, and whatever is left after that is discarded and reported.
+// A source blocked in a read that ignores ctx, such as stdin, may outlive run.
+func (p *pipeline) run(ctx context.Context) ShutdownReport {
+	defer p.abortDrain()
+	stopWatch := context.AfterFunc(ctx, func() {
+		slog.Info("Shutdown requested, draining in-flight batches", "timeout", p.cfg.drainTimeout,
+			"queue_depth", p.ds.Depth())
+		timer := time.NewTimer(p.cfg.drainTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			slog.Warn("Drain deadline passed, abandoning remaining batches", "queue_depth", p.ds.Depth())
+			p.abortDrain()
+		case <-p.drainCtx.Done():
+		}
+	})
+
+	controllerCtx, stopController := context.WithCancel(p.drainCtx)
+	var controllers sync.WaitGroup
+	controllers.Add(2)
+	go func() {
+		defer controllers.Done()
+		runScalingController(controllerCtx, p.pool, p.cfg.scaling)
+	}()
+	go func() {
+		defer controllers.Done()
+		logQueueStats(controllerCtx, p.ds, p.pool)
+	}()
+
+	var streams sync.WaitGroup
+	for streamId, source := range p.sources {
+		streams.Add(1)
+		slog.Info("Starting stream", "stream_id", streamId, "source", source.String())
+		go handleStream(ctx, streamId, source, p.ds, &streams)
+	}
+	streamsDone := make(chan struct{})
+	go func() {
+		streams.Wait()
+		close(streamsDone)
+	}()
+
+	// Close the stream once every source is exhausted, or the drain deadline
+	// passes, then let the workers drain it
+	go func() {
+		select {
+		case <-streamsDone:
+		case <-p.drainCtx.Done():
+		}
+		p.ds.Close()
+		stopController()
+		controllers.Wait()
+		p.pool.Wait()
+		close(p.results)
+	}()
+
+	writer := newSinkWriter(p.sink, p.breaker, p.cfg.sinkBatchSize, p.cfg.sinkFlushEvery)
+	writer.finalFlushTimeout = p.cfg.flushTimeout
+	summary := writer.run(p.drainCtx, p.results)
+
+	report := ShutdownReport{
+		Interrupted: !stopWatch(),
+		TimedOut:    p.drainCtx.Err() != nil,
+		Abandoned:   p.pool.Abandoned(),
+		Sink:        summary,
+	}
+	p.ds.Discard()
+	report.Queue = p.ds.Stats()
+	slog.Info("Pipeline stopped", "interrupted", report.Interrupted, "timed_out", report.TimedOut,
+		"total_sum", summary.TotalSum, "results_written", summary.Written, "batches_discarded", report.Discarded(),
+		"batches_unprocessed", report.Queue.Discarded, "batches_abandoned", report.Abandoned,
+		"results_discarded", summary.Discarded)
+	return report
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// verifyNoLeaks fails the test if goroutines started after it is called are
+// still running once the test and its other cleanups have finished, in the
+// manner of go.uber.org/goleak. Call it first so its check runs last.
+func verifyNoLeaks(t *testing.T) {
+	t.Helper()
+	before := goroutines()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			var leaked []string
+			for id, stack := range goroutines() {
+				if _, existed := before[id]; !existed {
+					leaked = append(leaked, stack)
+				}
+			}
+			if len(leaked) == 0 {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("%d goroutines leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// goroutines returns the stack of every running goroutine keyed by its header, "goroutine N".
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		id, _, _ := strings.Cut(string(stack), " [")
+		stacks[id] = string(stack)
+	}
+	return stacks
+}
+
+// endlessSource emits ones until cancelled, counting every value it hands over.
+type endlessSource struct {
+	sent *atomic.Int64
+}
+
+func (s endlessSource) Run(ctx context.Context, out chan<- int) error {
+	defer close(out)
+	for {
+		select {
+		case out <- 1:
+			s.sent.Add(1)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if err := sleepCtx(ctx, time.Millisecond); err != nil {
+			return err
+		}
+	}
+}
+
+func (s endlessSource) String() string { return "endless" }
+
+// blockingSink never completes a write until its context gives up.
+type blockingSink struct{}
+
+func (blockingSink) WriteBatch(ctx context.Context, results []BatchResult) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (blockingSink) Close() error { return nil }
+
+func testPipelineConfig() pipelineConfig {
+	return pipelineConfig{
+		bufferSize:       4,
+		overflow:         Block,
+		scaling:          ScalingConfig{MinWorkers: 1, MaxWorkers: 2, Interval: 10 * time.Millisecond, ScaleUpDepth: 2, ScaleDownDepth: 0},
+		sinkBatchSize:    1,
+		sinkFlushEvery:   10 * time.Millisecond,
+		maxFailedFlushes: 3,
+		cooldown:         time.Minute,
+		drainTimeout:     time.Second,
+		flushTimeout:     time.Second,
+	}
+}
+
+func TestPipelineRunsToCompletion(t *testing.T) {
+	verifyNoLeaks(t)
+	values := make(sliceSource, batchSize*2+5)
+	for i := range values {
+		values[i] = i
+	}
+	sink := NewMemorySink()
+	p, err := newPipeline(testPipelineConfig(), []Source{values, values, values}, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := p.run(context.Background())
+
+	if report.Interrupted || report.TimedOut || report.Discarded() != 0 {
+		t.Errorf("expected a clean run, got %+v", report)
+	}
+	if want := 3 * (len(values) - 1) * len(values) / 2; report.Sink.TotalSum != want {
+		t.Errorf("expected total sum %d, got %d", want, report.Sink.TotalSum)
+	}
+	if rows := sink.Rows(); len(rows) != 9 || report.Sink.Written != 9 {
+		t.Errorf("expected 9 batch results written, got %d rows and %d written", len(rows), report.Sink.Written)
+	}
+}
+
+func TestPipelineShutdownDrainsInFlightBatches(t *testing.T) {
+	verifyNoLeaks(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent atomic.Int64
+	sink := NewMemorySink()
+	sources := []Source{endlessSource{&sent}, endlessSource{&sent}, TCPSource{Listener: ln}}
+	p, err := newPipeline(testPipelineConfig(), sources, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	report := p.run(ctx)
+
+	if !report.Interrupted || report.TimedOut {
+		t.Errorf("expected an interrupted run that drained in time, got %+v", report)
+	}
+	if report.Discarded() != 0 {
+		t.Errorf("expected nothing discarded, got %d", report.Discarded())
+	}
+	// Every value handed over before shutdown, including those in the final
+	// short batches, must be accounted for in the stored results.
+	stored := 0
+	for _, r := range sink.Rows() {
+		stored += r.Sum
+	}
+	if want := int(sent.Load()); want == 0 || report.Sink.TotalSum != want || stored != want {
+		t.Errorf("expected sum %d, got %d reported and %d stored", want, report.Sink.TotalSum, stored)
+	}
+}
+
+func TestPipelineShutdownDeadlineReportsDiscarded(t *testing.T) {
+	verifyNoLeaks(t)
+	var sent atomic.Int64
+	cfg := testPipelineConfig()
+	cfg.drainTimeout = 50 * time.Millisecond
+	cfg.flushTimeout = 20 * time.Millisecond
+	p, err := newPipeline(cfg, []Source{endlessSource{&sent}, endlessSource{&sent}}, blockingSink{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The sink never accepts a write, so results back up into the workers,
+	// the stream fills, and producers block until the deadline gives up on them.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+	start := time.Now()
+	report := p.run(ctx)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected shutdown to be bounded by the deadlines, took %s", elapsed)
+	}
+	if !report.Interrupted || !report.TimedOut {
+		t.Errorf("expected the drain deadline to pass, got %+v", report)
+	}
+	if report.Sink.Written != 0 || report.Sink.Discarded != report.Sink.Received {
+		t.Errorf("expected every received result to be discarded, got %+v", report.Sink)
+	}
+	if cut := int(report.Queue.Enqueued + report.Queue.Rejected); report.Discarded() == 0 || report.Discarded() != cut {
+		t.Errorf("expected all %d batches reported discarded, got %d (%+v)", cut, report.Discarded(), report)
+	}
+}
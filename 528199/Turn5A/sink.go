@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BatchResult holds the per-batch aggregates written to a ResultSink.
+type BatchResult struct {
+	StreamID    int
+	BatchID     int
+	Count       int
+	Sum         int
+	Min         int
+	Max         int
+	ProcessedAt time.Time
+}
+
+// ResultSink persists batch results. Writes are keyed by (StreamID, BatchID):
+// writing the same batch twice replaces the earlier row instead of duplicating it.
+type ResultSink interface {
+	// WriteBatch stores all results atomically; on error none of them are stored.
+	WriteBatch(ctx context.Context, results []BatchResult) error
+	Close() error
+}
+
+type resultKey struct {
+	streamID int
+	batchID  int
+}
+
+// MemorySink is an in-process ResultSink used when no database is configured
+// and as the stand-in for PostgreSQL in tests.
+type MemorySink struct {
+	mu      sync.Mutex
+	rows    map[resultKey]BatchResult
+	writes  int
+	failing int // Number of upcoming WriteBatch calls that fail with failErr
+	failErr error
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{rows: make(map[resultKey]BatchResult)}
+}
+
+// FailNext makes the next n calls to WriteBatch return err without storing anything.
+func (s *MemorySink) FailNext(n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing, s.failErr = n, err
+}
+
+func (s *MemorySink) WriteBatch(ctx context.Context, results []BatchResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.failing > 0 {
+		s.failing--
+		return s.failErr
+	}
+	for _, r := range results {
+		s.rows[resultKey{r.StreamID, r.BatchID}] = r
+	}
+	return nil
+}
+
+// Rows returns a copy of every stored result.
+func (s *MemorySink) Rows() []BatchResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make([]BatchResult, 0, len(s.rows))
+	for _, r := range s.rows {
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+// Writes returns how many times WriteBatch has been called.
+func (s *MemorySink) Writes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writes
+}
+
+func (s *MemorySink) Close() error { return nil }
+
+// SinkSummary reports what a sinkWriter did with the results it received.
+type SinkSummary struct {
+	Received  int // Results read from the results channel
+	Written   int // Results stored by the sink
+	Discarded int // Results given up on after the sink kept failing
+	TotalSum  int // Sum over every received result
+}
+
+const defaultFinalFlushTimeout = 5 * time.Second
+
+// sinkWriter groups results into batched sink writes and stops hammering the
+// sink through a circuit breaker while it is failing.
+type sinkWriter struct {
+	sink              ResultSink
+	breaker           *circuitBreaker
+	maxBatch          int           // Flush once this many results are pending
+	maxPending        int           // Oldest results are discarded beyond this while the sink is down
+	flushInterval     time.Duration // Flush whatever is pending at least this often
+	finalFlushTimeout time.Duration // Bounds the final flush, which still runs after ctx is cancelled
+
+	pending  []BatchResult
+	attempts int // Failed flushes since the last successful one
+	summary  SinkSummary
+}
+
+func newSinkWriter(sink ResultSink, breaker *circuitBreaker, maxBatch int, flushInterval time.Duration) *sinkWriter {
+	return &sinkWriter{
+		sink:              sink,
+		breaker:           breaker,
+		maxBatch:          maxBatch,
+		maxPending:        maxBatch * 100,
+		flushInterval:     flushInterval,
+		finalFlushTimeout: defaultFinalFlushTimeout,
+	}
+}
+
+// run writes everything received on results to the sink and returns once
+// results is closed and the final flush has been attempted. Once ctx is
+// cancelled results are only collected, and the final flush gets its own
+// finalFlushTimeout to write them.
+func (w *sinkWriter) run(ctx context.Context, results <-chan BatchResult) SinkSummary {
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case result, ok := <-results:
+			if !ok {
+				flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), w.finalFlushTimeout)
+				w.flush(flushCtx, true)
+				cancel()
+				return w.summary
+			}
+			w.summary.Received++
+			w.summary.TotalSum += result.Sum
+			w.pending = append(w.pending, result)
+			if len(w.pending) >= w.maxBatch {
+				w.flush(ctx, false)
+			}
+		case <-ticker.C:
+			w.flush(ctx, false)
+		}
+	}
+}
+
+// flush writes the pending results in one transaction. While the breaker is
+// open results stay pending, except on the final flush which always tries once.
+func (w *sinkWriter) flush(ctx context.Context, final bool) {
+	if len(w.pending) == 0 || (ctx.Err() != nil && !final) {
+		return
+	}
+	if !w.breaker.Allow() && !final {
+		w.trimPending()
+		return
+	}
+
+	if err := w.sink.WriteBatch(ctx, w.pending); err != nil {
+		w.attempts++
+		w.breaker.Trip()
+		metrics.sinkWriteFailures.Inc("")
+		slog.Error("Failed to write batch results", "attempt", w.attempts, "results", len(w.pending),
+			"breaker", w.breaker.State().String(), "error", err)
+		if final {
+			w.discard(len(w.pending))
+		} else {
+			w.trimPending()
+		}
+		return
+	}
+	w.breaker.Reset()
+	w.attempts = 0
+	w.summary.Written += len(w.pending)
+	w.pending = w.pending[:0]
+}
+
+func (w *sinkWriter) trimPending() {
+	if excess := len(w.pending) - w.maxPending; excess > 0 {
+		w.discard(excess)
+	}
+}
+
+// discard gives up on the n oldest pending results.
+func (w *sinkWriter) discard(n int) {
+	for _, r := range w.pending[:n] {
+		metrics.batchFailures.Inc(strconv.Itoa(r.StreamID))
+		slog.Warn("Batch result discarded", "stream_id", r.StreamID, "batch_id", r.BatchID, "attempt", w.attempts)
+	}
+	w.summary.Discarded += n
+	w.pending = append(w.pending[:0], w.pending[n:]...)
+}
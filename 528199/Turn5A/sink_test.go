@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestMemorySinkUpserts(t *testing.T) {
+	sink := NewMemorySink()
+	ctx := context.Background()
+
+	if err := sink.WriteBatch(ctx, []BatchResult{{StreamID: 1, BatchID: 0, Sum: 10}, {StreamID: 1, BatchID: 1, Sum: 20}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.WriteBatch(ctx, []BatchResult{{StreamID: 1, BatchID: 1, Sum: 25}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := sink.Rows()
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %d", len(rows))
+	}
+	for _, r := range rows {
+		if r.BatchID == 1 && r.Sum != 25 {
+			t.Errorf("expected batch 1 to be replaced with sum 25, got %d", r.Sum)
+		}
+	}
+}
+
+func TestProcessDataBatchAggregates(t *testing.T) {
+	r, err := processDataBatch(context.Background(), Batch{StreamID: 2, BatchID: 7, Values: []int{5, 1, 9}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.StreamID != 2 || r.BatchID != 7 || r.Count != 3 || r.Sum != 15 || r.Min != 1 || r.Max != 9 {
+		t.Errorf("unexpected aggregates %+v", r)
+	}
+}
+
+func sendResults(n int) <-chan BatchResult {
+	results := make(chan BatchResult, n)
+	for i := 0; i < n; i++ {
+		results <- BatchResult{StreamID: 0, BatchID: i, Count: 1, Sum: i}
+	}
+	close(results)
+	return results
+}
+
+func TestSinkWriterBatchesWrites(t *testing.T) {
+	sink := NewMemorySink()
+	writer := newSinkWriter(sink, newCircuitBreaker(3, time.Minute), 10, time.Hour)
+
+	summary := writer.run(context.Background(), sendResults(25))
+
+	if summary.Received != 25 || summary.Written != 25 || summary.Discarded != 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+	if summary.TotalSum != 300 {
+		t.Errorf("expected total sum 300, got %d", summary.TotalSum)
+	}
+	// Two full transactions of 10 plus the final flush of 5.
+	if writes := sink.Writes(); writes != 3 {
+		t.Errorf("expected 3 writes, got %d", writes)
+	}
+	if rows := len(sink.Rows()); rows != 25 {
+		t.Errorf("expected 25 rows, got %d", rows)
+	}
+}
+
+func TestSinkWriterKeepsResultsWhileBreakerOpen(t *testing.T) {
+	sink := NewMemorySink()
+	sink.FailNext(1, errors.New("connection refused"))
+	writer := newSinkWriter(sink, newCircuitBreaker(1, time.Minute), 10, time.Hour)
+
+	summary := writer.run(context.Background(), sendResults(30))
+
+	// The first flush fails and opens the breaker, so the next full batch is
+	// held back rather than sent. The final flush then writes everything.
+	if writes := sink.Writes(); writes != 2 {
+		t.Errorf("expected 2 writes, got %d", writes)
+	}
+	if summary.Written != 30 || summary.Discarded != 0 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestSinkWriterReportsDiscardedOnFinalFailure(t *testing.T) {
+	sink := NewMemorySink()
+	sink.FailNext(10, errors.New("connection refused"))
+	writer := newSinkWriter(sink, newCircuitBreaker(1, time.Minute), 10, time.Hour)
+
+	summary := writer.run(context.Background(), sendResults(5))
+
+	if summary.Written != 0 || summary.Discarded != 5 {
+		t.Errorf("unexpected summary %+v", summary)
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	errTransient := errors.New("transient")
+	isTransient := func(err error) bool { return errors.Is(err, errTransient) }
+
+	tests := []struct {
+		name      string
+		failures  []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{"Succeeds first time", nil, 1, false},
+		{"Recovers after transient errors", []error{errTransient, errTransient}, 3, false},
+		{"Gives up after max attempts", []error{errTransient, errTransient, errTransient, errTransient}, 3, true},
+		{"Does not retry permanent errors", []error{errors.New("syntax error")}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryTransient(context.Background(), 3, time.Millisecond, isTransient, func(int) error {
+				calls++
+				if calls <= len(tt.failures) {
+					return tt.failures[calls-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d calls, got %d", tt.wantCalls, calls)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error = %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestIsTransientDBError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{driver.ErrBadConn, true},
+		{fmt.Errorf("begin transaction: %w", driver.ErrBadConn), true},
+		{&pq.Error{Code: "08006"}, true},  // connection_failure
+		{&pq.Error{Code: "40001"}, true},  // serialization_failure
+		{&pq.Error{Code: "40P01"}, true},  // deadlock_detected
+		{&pq.Error{Code: "53300"}, true},  // too_many_connections
+		{&pq.Error{Code: "57P01"}, true},  // admin_shutdown
+		{&pq.Error{Code: "23505"}, false}, // unique_violation
+		{&pq.Error{Code: "42601"}, false}, // syntax_error
+		{errors.New("something else"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isTransientDBError(tt.err); got != tt.want {
+			t.Errorf("isTransientDBError(%v) = %v, expected %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCircuitBreakerStates(t *testing.T) {
+	cb := newCircuitBreaker(2, 20*time.Millisecond)
+
+	cb.Trip()
+	if cb.State() != breakerClosed || !cb.Allow() {
+		t.Fatal("expected breaker to stay closed below the failure limit")
+	}
+	cb.Trip()
+	if cb.State() != breakerOpen || cb.Allow() {
+		t.Fatal("expected breaker to open at the failure limit")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if cb.State() != breakerHalfOpen || !cb.Allow() {
+		t.Fatal("expected a trial call after the cooldown")
+	}
+	cb.Trip()
+	if cb.State() != breakerOpen {
+		t.Fatal("expected a failed trial call to reopen the breaker")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	cb.Allow()
+	cb.Reset()
+	if cb.State() != breakerClosed {
+		t.Fatal("expected a successful trial call to close the breaker")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Source produces the data points of one stream.
+type Source interface {
+	// Run sends data points to out until the source is exhausted, fails, or
+	// ctx is done. It closes out before returning.
+	Run(ctx context.Context, out chan<- int) error
+	String() string
+}
+
+// Format is the line encoding read by file, TCP, and stdin sources.
+type Format int
+
+const (
+	Numbers     Format = iota // One integer per line
+	JSONRecords               // One JSON number or object per line
+)
+
+// lineDecoder turns one line of input into a data point.
+type lineDecoder struct {
+	format Format
+	field  string // Object field holding the data point for JSONRecords
+}
+
+// decode returns ok=false for blank lines, which are skipped.
+func (d lineDecoder) decode(line []byte) (value int, ok bool, err error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return 0, false, nil
+	}
+	if d.format == Numbers {
+		value, err = strconv.Atoi(string(line))
+		return value, err == nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var record any
+	if err := dec.Decode(&record); err != nil {
+		return 0, false, err
+	}
+	if obj, isObj := record.(map[string]any); isObj {
+		field, found := obj[d.field]
+		if !found {
+			return 0, false, fmt.Errorf("record has no %q field", d.field)
+		}
+		record = field
+	}
+	num, isNum := record.(json.Number)
+	if !isNum {
+		return 0, false, fmt.Errorf("expected a number, got %T", record)
+	}
+	f, err := num.Float64()
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > math.MaxInt32 {
+		return 0, false, fmt.Errorf("expected an integer, got %s", num)
+	}
+	return int(f), true, nil
+}
+
+// scanLines decodes newline-delimited data points from r into out until r is
+// exhausted or ctx is done. Lines that fail to decode are logged and skipped.
+func scanLines(ctx context.Context, r io.Reader, dec lineDecoder, name string, out chan<- int) error {
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if err := emit(ctx, dec, scanner.Bytes(), name, lineNo, out); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func emit(ctx context.Context, dec lineDecoder, line []byte, name string, lineNo int, out chan<- int) error {
+	value, ok, err := dec.decode(line)
+	if err != nil {
+		slog.Warn("Skipping malformed data point", "source", name, "line", lineNo, "error", err)
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SyntheticSource is the original random generator: Count values in [0, 1000)
+// arriving at random intervals of up to MaxDelay.
+type SyntheticSource struct {
+	Count    int
+	MaxDelay time.Duration
+}
+
+// Simulating real-time data using a generator
+func (s SyntheticSource) Run(ctx context.Context, out chan<- int) error {
+	defer close(out)
+	for i := 0; i < s.Count; i++ {
+		select {
+		case out <- rand.Intn(1000):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if s.MaxDelay > 0 {
+			if err := sleepCtx(ctx, time.Duration(rand.Int63n(int64(s.MaxDelay)))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s SyntheticSource) String() string { return "synthetic" }
+
+// ReaderSource reads newline-delimited data points from an io.Reader such as
+// stdin. A blocked Read cannot be interrupted, so cancellation takes effect
+// at the next line.
+type ReaderSource struct {
+	Reader io.Reader
+	Name   string
+	Format Format
+	Field  string
+}
+
+// StdinSource reads data points from standard input.
+func StdinSource(format Format, field string) ReaderSource {
+	return ReaderSource{Reader: os.Stdin, Name: "stdin", Format: format, Field: field}
+}
+
+func (s ReaderSource) Run(ctx context.Context, out chan<- int) error {
+	defer close(out)
+	return scanLines(ctx, s.Reader, lineDecoder{s.Format, s.Field}, s.Name, out)
+}
+
+func (s ReaderSource) String() string { return s.Name }
+
+// FileSource reads data points from a file. With Follow set it keeps reading
+// as lines are appended, like tail -F, and reopens the file when it is
+// truncated or replaced by log rotation.
+type FileSource struct {
+	Path         string
+	Format       Format
+	Field        string
+	Follow       bool
+	PollInterval time.Duration // How often a followed file is checked for new data
+}
+
+func (s FileSource) Run(ctx context.Context, out chan<- int) error {
+	defer close(out)
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	dec := lineDecoder{s.Format, s.Field}
+	if !s.Follow {
+		return scanLines(ctx, file, dec, s.Path, out)
+	}
+
+	poll := s.PollInterval
+	if poll <= 0 {
+		poll = 250 * time.Millisecond
+	}
+	reader := bufio.NewReader(file)
+	var partial []byte
+	var offset int64
+	for lineNo := 1; ; {
+		chunk, err := reader.ReadBytes('\n')
+		offset += int64(len(chunk))
+		partial = append(partial, chunk...)
+		if err == nil {
+			if err := emit(ctx, dec, partial, s.Path, lineNo, out); err != nil {
+				return err
+			}
+			partial, lineNo = partial[:0], lineNo+1
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// At the end of the file: wait for more data, then check whether the
+		// file was truncated or rotated out from under us.
+		if err := sleepCtx(ctx, poll); err != nil {
+			return err
+		}
+		reopened, err := s.reopenIfReplaced(file, offset)
+		if err != nil {
+			return err
+		}
+		if reopened != nil {
+			file, offset, partial = reopened, 0, partial[:0]
+			reader.Reset(file)
+		}
+	}
+}
+
+// reopenIfReplaced returns a fresh handle when the file at s.Path is no longer
+// the one being read, or has shrunk below the current read offset.
+func (s FileSource) reopenIfReplaced(file *os.File, offset int64) (*os.File, error) {
+	current, err := os.Stat(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil // Mid-rotation; keep reading the old file until the new one appears
+	}
+	if err != nil {
+		return nil, err
+	}
+	open, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if os.SameFile(current, open) && current.Size() >= offset {
+		return nil, nil
+	}
+	slog.Info("Source file truncated or rotated, reopening", "source", s.Path)
+	reopened, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return reopened, nil
+}
+
+func (s FileSource) String() string {
+	if s.Follow {
+		return "tail:" + s.Path
+	}
+	return "file:" + s.Path
+}
+
+// TCPSource accepts connections on Addr, or on Listener if one is provided,
+// and reads newline-delimited data points from every connection until ctx is done.
+type TCPSource struct {
+	Addr     string
+	Listener net.Listener
+	Format   Format
+	Field    string
+}
+
+func (s TCPSource) Run(ctx context.Context, out chan<- int) error {
+	defer close(out)
+	ln := s.Listener
+	if ln == nil {
+		var err error
+		if ln, err = net.Listen("tcp", s.Addr); err != nil {
+			return err
+		}
+	}
+
+	var conns sync.WaitGroup
+	defer conns.Wait() // Every reader must stop before out is closed
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	dec := lineDecoder{s.Format, s.Field}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			defer conn.Close()
+			stopConn := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stopConn()
+			name := "tcp:" + conn.RemoteAddr().String()
+			if err := scanLines(ctx, conn, dec, name, out); err != nil && ctx.Err() == nil {
+				slog.Warn("Connection closed with error", "source", name, "error", err)
+			}
+		}()
+	}
+}
+
+func (s TCPSource) String() string {
+	if s.Listener != nil {
+		return "tcp:" + s.Listener.Addr().String()
+	}
+	return "tcp:" + s.Addr
+}
+
+// parseSourceSpec turns one STREAM_SOURCES entry into a Source. Entries are
+// "synthetic", "file:PATH", "tail:PATH", "tcp:ADDR", or "stdin". A "json+"
+// prefix reads JSON records whose "value" field holds the data point, and
+// "json.FIELD+" reads another field instead.
+func parseSourceSpec(spec string) (Source, error) {
+	format, field := Numbers, ""
+	if prefix, rest, found := strings.Cut(spec, "+"); found && strings.HasPrefix(prefix, "json") {
+		format, field = JSONRecords, "value"
+		if name, ok := strings.CutPrefix(prefix, "json."); ok && name != "" {
+			field = name
+		} else if prefix != "json" {
+			return nil, fmt.Errorf("source %q: unknown format %q", spec, prefix)
+		}
+		spec = rest
+	}
+
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "synthetic":
+		return SyntheticSource{Count: streamSize, MaxDelay: 50 * time.Millisecond}, nil
+	case "file", "tail":
+		if arg == "" {
+			return nil, fmt.Errorf("source %q: missing path", spec)
+		}
+		return FileSource{Path: arg, Format: format, Field: field, Follow: kind == "tail"}, nil
+	case "tcp":
+		if arg == "" {
+			return nil, fmt.Errorf("source %q: missing listen address", spec)
+		}
+		return TCPSource{Addr: arg, Format: format, Field: field}, nil
+	case "stdin":
+		return StdinSource(format, field), nil
+	}
+	return nil, fmt.Errorf("unknown source %q", spec)
+}
+
+// sleepCtx waits for d or until ctx is done, whichever comes first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
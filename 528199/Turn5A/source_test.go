@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collect runs source to completion, or until ctx is done, and returns what it produced.
+func collect(ctx context.Context, t *testing.T, source Source) ([]int, error) {
+	t.Helper()
+	out := make(chan int)
+	errc := make(chan error, 1)
+	go func() { errc <- source.Run(ctx, out) }()
+	var got []int
+	for v := range out {
+		got = append(got, v)
+	}
+	return got, <-errc
+}
+
+func TestLineDecoder(t *testing.T) {
+	tests := []struct {
+		name    string
+		dec     lineDecoder
+		line    string
+		want    int
+		wantOK  bool
+		wantErr bool
+	}{
+		{"Number", lineDecoder{Numbers, ""}, " 42\r", 42, true, false},
+		{"Blank line", lineDecoder{Numbers, ""}, "   ", 0, false, false},
+		{"Not a number", lineDecoder{Numbers, ""}, "forty", 0, false, true},
+		{"JSON number", lineDecoder{JSONRecords, "value"}, "17", 17, true, false},
+		{"JSON record", lineDecoder{JSONRecords, "value"}, `{"value": 9, "ts": "x"}`, 9, true, false},
+		{"JSON custom field", lineDecoder{JSONRecords, "amount"}, `{"amount": -3}`, -3, true, false},
+		{"JSON missing field", lineDecoder{JSONRecords, "value"}, `{"amount": 3}`, 0, false, true},
+		{"JSON fraction", lineDecoder{JSONRecords, "value"}, `{"value": 1.5}`, 0, false, true},
+		{"JSON string", lineDecoder{JSONRecords, "value"}, `{"value": "7"}`, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := tt.dec.decode([]byte(tt.line))
+			if got != tt.want || ok != tt.wantOK || (err != nil) != tt.wantErr {
+				t.Errorf("decode(%q) = (%d, %v, %v), expected (%d, %v, error=%v)", tt.line, got, ok, err, tt.want, tt.wantOK, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileSourceSkipsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "points.txt")
+	os.WriteFile(path, []byte("1\n2\nbad\n\n3\n4"), 0666)
+
+	got, err := collect(context.Background(), t, FileSource{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestFileSourceFollowsAppendsAndTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "points.jsonl")
+	os.WriteFile(path, []byte(`{"value": 1}`+"\n"), 0666)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := make(chan int)
+	errc := make(chan error, 1)
+	source := FileSource{Path: path, Format: JSONRecords, Field: "value", Follow: true, PollInterval: 5 * time.Millisecond}
+	go func() { errc <- source.Run(ctx, out) }()
+
+	expect := func(want int) {
+		t.Helper()
+		select {
+		case got := <-out:
+			if got != want {
+				t.Fatalf("expected %d, got %d", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %d", want)
+		}
+	}
+	expect(1)
+
+	// A line written in two pieces is only emitted once complete.
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0666)
+	f.WriteString(`{"val`)
+	time.Sleep(20 * time.Millisecond)
+	f.WriteString(`ue": 2}` + "\n")
+	f.Close()
+	expect(2)
+
+	// Truncating the file, as copytruncate rotation does, starts again from the top.
+	os.WriteFile(path, []byte(`{"value": 3}`+"\n"), 0666)
+	expect(3)
+
+	cancel()
+	for range out {
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestTCPSourceReadsEveryConnection(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan int)
+	errc := make(chan error, 1)
+	go func() { errc <- TCPSource{Listener: ln}.Run(ctx, out) }()
+
+	var clients sync.WaitGroup
+	for c := 0; c < 3; c++ {
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			for i := 0; i < 5; i++ {
+				fmt.Fprintf(conn, "%d\n", c*10+i)
+			}
+		}()
+	}
+
+	var got []int
+	for len(got) < 15 {
+		got = append(got, <-out)
+	}
+	clients.Wait()
+	cancel()
+	for range out {
+	}
+	<-errc
+
+	sort.Ints(got)
+	want := []int{0, 1, 2, 3, 4, 10, 11, 12, 13, 14, 20, 21, 22, 23, 24}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestReaderSource(t *testing.T) {
+	source := ReaderSource{Reader: strings.NewReader("5\n6\n"), Name: "stdin"}
+	got, err := collect(context.Background(), t, source)
+	if err != nil || !reflect.DeepEqual(got, []int{5, 6}) {
+		t.Errorf("expected [5 6], got %v (err %v)", got, err)
+	}
+}
+
+func TestSyntheticSourceStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	got, err := collect(ctx, t, SyntheticSource{Count: 1000})
+	if err != context.Canceled || len(got) > 1 {
+		t.Errorf("expected cancellation before producing, got %d values (err %v)", len(got), err)
+	}
+}
+
+func TestParseSourceSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Source
+	}{
+		{"synthetic", SyntheticSource{Count: streamSize, MaxDelay: 50 * time.Millisecond}},
+		{"file:data.txt", FileSource{Path: "data.txt"}},
+		{"tail:/var/log/points.log", FileSource{Path: "/var/log/points.log", Follow: true}},
+		{"json+tcp::9000", TCPSource{Addr: ":9000", Format: JSONRecords, Field: "value"}},
+		{"json.amount+file:a+b.jsonl", FileSource{Path: "a+b.jsonl", Format: JSONRecords, Field: "amount"}},
+		{"stdin", StdinSource(Numbers, "")},
+	}
+	for _, tt := range tests {
+		got, err := parseSourceSpec(tt.spec)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.spec, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %#v, got %#v", tt.spec, tt.want, got)
+		}
+	}
+
+	for _, spec := range []string{"", "ftp:x", "file:", "tcp", "jsonx+stdin"} {
+		if _, err := parseSourceSpec(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+// sliceSource replays fixed values, standing in for any Source in handleStream tests.
+type sliceSource []int
+
+func (s sliceSource) Run(ctx context.Context, out chan<- int) error {
+	defer close(out)
+	for _, v := range s {
+		select {
+		case out <- v:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s sliceSource) String() string { return "slice" }
+
+func TestHandleStreamBatchesAnySource(t *testing.T) {
+	ds, err := NewDataStream(10, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(sliceSource, batchSize*2+3)
+	for i := range values {
+		values[i] = i
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	handleStream(context.Background(), 4, values, ds, &wg)
+
+	var sizes []int
+	for _, want := range []int{0, 1, 2} {
+		batch, ok := ds.Next(context.Background())
+		if !ok || batch.StreamID != 4 || batch.BatchID != want {
+			t.Fatalf("expected stream 4 batch %d, got %+v", want, batch)
+		}
+		sizes = append(sizes, len(batch.Values))
+	}
+	if want := []int{batchSize, batchSize, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("expected batch sizes %v, got %v", want, sizes)
+	}
+}
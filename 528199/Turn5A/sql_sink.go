@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The schema and upsert are plain enough to run unchanged on PostgreSQL and SQLite.
+const (
+	createResultsTable = `CREATE TABLE IF NOT EXISTS batch_results (
+	stream_id    INTEGER   NOT NULL,
+	batch_id     INTEGER   NOT NULL,
+	item_count   INTEGER   NOT NULL,
+	total        BIGINT    NOT NULL,
+	min_value    INTEGER   NOT NULL,
+	max_value    INTEGER   NOT NULL,
+	processed_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (stream_id, batch_id)
+)`
+
+	upsertBatchResult = `INSERT INTO batch_results
+	(stream_id, batch_id, item_count, total, min_value, max_value, processed_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (stream_id, batch_id) DO UPDATE SET
+	item_count = excluded.item_count,
+	total = excluded.total,
+	min_value = excluded.min_value,
+	max_value = excluded.max_value,
+	processed_at = excluded.processed_at`
+)
+
+// SQLSinkConfig controls the connection pool and retry behaviour of a SQLSink.
+type SQLSinkConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	MaxAttempts     int           // Attempts per WriteBatch, including the first
+	RetryBackoff    time.Duration // Delay before the first retry, doubled for each one after
+}
+
+var defaultSQLSinkConfig = SQLSinkConfig{
+	MaxOpenConns:    10,
+	MaxIdleConns:    5,
+	ConnMaxLifetime: 30 * time.Minute,
+	MaxAttempts:     4,
+	RetryBackoff:    100 * time.Millisecond,
+}
+
+// SQLSink upserts batch results into the batch_results table, one transaction per WriteBatch.
+type SQLSink struct {
+	db        *sql.DB
+	cfg       SQLSinkConfig
+	transient func(error) bool
+}
+
+// OpenPostgresSink connects to PostgreSQL and makes sure the results table exists.
+func OpenPostgresSink(ctx context.Context, dsn string, cfg SQLSinkConfig) (*SQLSink, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres: %w", err)
+	}
+	sink, err := NewSQLSink(ctx, db, cfg)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return sink, nil
+}
+
+// NewSQLSink wraps an open database, applying the pool settings from cfg.
+func NewSQLSink(ctx context.Context, db *sql.DB, cfg SQLSinkConfig) (*SQLSink, error) {
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+
+	sink := &SQLSink{db: db, cfg: cfg, transient: isTransientDBError}
+	err := retryTransient(ctx, cfg.MaxAttempts, cfg.RetryBackoff, sink.transient, func(attempt int) error {
+		_, err := db.ExecContext(ctx, createResultsTable)
+		if err != nil {
+			slog.Warn("Failed to create results table", "attempt", attempt, "error", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create results table: %w", err)
+	}
+	return sink, nil
+}
+
+// WriteBatch upserts results in a single transaction, retrying the whole
+// transaction on transient errors such as dropped connections or deadlocks.
+func (s *SQLSink) WriteBatch(ctx context.Context, results []BatchResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	return retryTransient(ctx, s.cfg.MaxAttempts, s.cfg.RetryBackoff, s.transient, func(attempt int) error {
+		if attempt > 1 {
+			metrics.sinkRetries.Inc("")
+		}
+		err := s.writeOnce(ctx, results)
+		if err != nil {
+			slog.Warn("Result transaction failed", "attempt", attempt, "results", len(results),
+				"transient", s.transient(err), "error", err)
+		}
+		return err
+	})
+}
+
+func (s *SQLSink) writeOnce(ctx context.Context, results []BatchResult) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	stmt, err := tx.PrepareContext(ctx, upsertBatchResult)
+	if err != nil {
+		return fmt.Errorf("prepare upsert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, r := range results {
+		if _, err := stmt.ExecContext(ctx, r.StreamID, r.BatchID, r.Count, r.Sum, r.Min, r.Max, r.ProcessedAt.UTC()); err != nil {
+			return fmt.Errorf("upsert stream %d batch %d: %w", r.StreamID, r.BatchID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+func (s *SQLSink) Close() error {
+	return s.db.Close()
+}
+
+// retryTransient calls op with attempt numbers starting at 1 until it succeeds,
+// returns a non-transient error, or has been tried maxAttempts times, doubling
+// the backoff between attempts.
+func retryTransient(ctx context.Context, maxAttempts int, backoff time.Duration, transient func(error) bool, op func(attempt int) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = op(attempt)
+		if err == nil || !transient(err) || attempt >= maxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientDBError reports whether err is worth retrying: lost or refused
+// connections, serialization failures, deadlocks, and server overload or restarts.
+func isTransientDBError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection_exception
+			"40", // transaction_rollback: serialization_failure, deadlock_detected
+			"53": // insufficient_resources: too_many_connections, out_of_memory
+			return true
+		}
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown, crash_shutdown, cannot_connect_now
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// openSQLiteSink runs SQLSink against an embedded SQLite file in place of PostgreSQL.
+func openSQLiteSink(t *testing.T) (*SQLSink, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := defaultSQLSinkConfig
+	cfg.MaxOpenConns = 1 // SQLite allows a single writer
+	sink, err := NewSQLSink(context.Background(), db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink, db
+}
+
+func TestSQLSinkUpsertsOnStreamAndBatch(t *testing.T) {
+	sink, db := openSQLiteSink(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	first := []BatchResult{
+		{StreamID: 0, BatchID: 0, Count: 10, Sum: 100, Min: 1, Max: 20, ProcessedAt: now},
+		{StreamID: 0, BatchID: 1, Count: 10, Sum: 200, Min: 2, Max: 30, ProcessedAt: now},
+		{StreamID: 1, BatchID: 0, Count: 10, Sum: 300, Min: 3, Max: 40, ProcessedAt: now},
+	}
+	if err := sink.WriteBatch(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	// A replayed batch must overwrite its row rather than add a second one.
+	if err := sink.WriteBatch(ctx, []BatchResult{{StreamID: 0, BatchID: 1, Count: 5, Sum: 50, Min: 5, Max: 15, ProcessedAt: now}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows, total int
+	if err := db.QueryRow("SELECT COUNT(*), SUM(total) FROM batch_results").Scan(&rows, &total); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 || total != 450 {
+		t.Errorf("expected 3 rows summing to 450, got %d rows summing to %d", rows, total)
+	}
+
+	var count, minValue, maxValue int
+	err := db.QueryRow("SELECT item_count, min_value, max_value FROM batch_results WHERE stream_id = 0 AND batch_id = 1").
+		Scan(&count, &minValue, &maxValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 5 || minValue != 5 || maxValue != 15 {
+		t.Errorf("expected replaced aggregates (5, 5, 15), got (%d, %d, %d)", count, minValue, maxValue)
+	}
+}
+
+func TestSQLSinkRollsBackFailedBatch(t *testing.T) {
+	sink, db := openSQLiteSink(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := sink.WriteBatch(ctx, []BatchResult{{StreamID: 0, BatchID: 0, ProcessedAt: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := sink.WriteBatch(ctx, []BatchResult{{StreamID: 0, BatchID: 1, ProcessedAt: time.Now()}}); err == nil {
+		t.Fatal("expected write with a cancelled context to fail")
+	}
+
+	var rows int
+	if err := db.QueryRow("SELECT COUNT(*) FROM batch_results").Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("expected only the committed row, got %d rows", rows)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Process data using a range loop (stream processing) until the stream drains
+// or a context ends. retire only interrupts the wait for the next batch, so the
+// pool can shrink without losing work; ctx also bounds processing and handing
+// the result off, and is cancelled when the shutdown drain deadline passes.
+// It returns the number of batches it took but could not deliver.
+func processDataStream(ctx, retire context.Context, ds *DataStream, results chan<- BatchResult) (abandoned int) {
+	for ctx.Err() == nil {
+		batch, ok := ds.Next(retire)
+		if !ok {
+			return abandoned
+		}
+		result, err := processDataBatch(ctx, batch)
+		if err != nil {
+			slog.Warn("Batch abandoned", "stream_id", batch.StreamID, "batch_id", batch.BatchID, "error", err)
+			abandoned++
+			continue
+		}
+		streamLabel := strconv.Itoa(batch.StreamID)
+		latency := result.ProcessedAt.Sub(batch.CreatedAt)
+		metrics.batchesProcessed.Inc(streamLabel)
+		metrics.batchLatency.Observe(streamLabel, latency.Seconds())
+		slog.Debug("Batch processed", "stream_id", batch.StreamID, "batch_id", batch.BatchID, "sum", result.Sum, "latency", latency)
+		select {
+		case results <- result:
+		case <-ctx.Done():
+			slog.Warn("Batch result abandoned", "stream_id", batch.StreamID, "batch_id", batch.BatchID, "error", ctx.Err())
+			abandoned++
+		}
+	}
+	return abandoned
+}
+
+// workerPool runs a resizable set of processDataStream workers over one stream.
+type workerPool struct {
+	ctx       context.Context // Parent of every worker; cancelling it stops the pool
+	ds        *DataStream
+	results   chan<- BatchResult
+	wg        sync.WaitGroup
+	abandoned atomic.Int64
+
+	mu      sync.Mutex
+	cancels []context.CancelFunc
+}
+
+func newWorkerPool(ctx context.Context, ds *DataStream, results chan<- BatchResult) *workerPool {
+	return &workerPool{ctx: ctx, ds: ds, results: results}
+}
+
+// Grow starts one more worker.
+func (p *workerPool) Grow() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	retire, cancel := context.WithCancel(p.ctx)
+	p.cancels = append(p.cancels, cancel)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer cancel()
+		p.abandoned.Add(int64(processDataStream(p.ctx, retire, p.ds, p.results)))
+	}()
+}
+
+// Shrink stops the most recently started worker once it finishes its current batch.
+func (p *workerPool) Shrink() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.cancels) == 0 {
+		return
+	}
+	last := len(p.cancels) - 1
+	p.cancels[last]()
+	p.cancels = p.cancels[:last]
+}
+
+// Abandoned returns how many batches workers took off the stream but could not
+// deliver before the pool's context was cancelled.
+func (p *workerPool) Abandoned() int {
+	return int(p.abandoned.Load())
+}
+
+// Size returns the number of running workers.
+func (p *workerPool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.cancels)
+}
+
+// Wait blocks until every worker has exited.
+func (p *workerPool) Wait() {
+	p.wg.Wait()
+}
+
+// ScalingConfig bounds how the controller resizes the pool in response to queue lag.
+type ScalingConfig struct {
+	MinWorkers     int
+	MaxWorkers     int
+	Interval       time.Duration // How often lag is sampled
+	ScaleUpDepth   int           // Add a worker when at least this many batches are waiting
+	ScaleDownDepth int           // Remove a worker when at most this many batches are waiting
+}
+
+// scaleDecision returns +1, -1, or 0 workers for the observed queue depth.
+func scaleDecision(cfg ScalingConfig, depth, workers int) int {
+	switch {
+	case workers < cfg.MinWorkers:
+		return 1
+	case workers > cfg.MaxWorkers:
+		return -1
+	case depth >= cfg.ScaleUpDepth && workers < cfg.MaxWorkers:
+		return 1
+	case depth <= cfg.ScaleDownDepth && workers > cfg.MinWorkers:
+		return -1
+	}
+	return 0
+}
+
+// runScalingController samples the stream depth every cfg.Interval and grows or
+// shrinks the pool one worker at a time until ctx is cancelled.
+func runScalingController(ctx context.Context, pool *workerPool, cfg ScalingConfig) {
+	for pool.Size() < cfg.MinWorkers {
+		pool.Grow()
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			depth, workers := pool.ds.Depth(), pool.Size()
+			switch scaleDecision(cfg, depth, workers) {
+			case 1:
+				pool.Grow()
+				slog.Info("Scaled workers up", "queue_depth", depth, "workers", workers+1)
+			case -1:
+				pool.Shrink()
+				slog.Info("Scaled workers down", "queue_depth", depth, "workers", workers-1)
+			}
+		}
+	}
+}
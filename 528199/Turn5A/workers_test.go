@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScaleDecision(t *testing.T) {
+	cfg := ScalingConfig{MinWorkers: 1, MaxWorkers: 4, ScaleUpDepth: 50, ScaleDownDepth: 5}
+
+	tests := []struct {
+		name    string
+		depth   int
+		workers int
+		want    int
+	}{
+		{"Below minimum", 0, 0, 1},
+		{"Lagging grows", 60, 2, 1},
+		{"Lagging at maximum holds", 60, 4, 0},
+		{"Idle shrinks", 2, 3, -1},
+		{"Idle at minimum holds", 2, 1, 0},
+		{"Between thresholds holds", 20, 2, 0},
+		{"Above maximum shrinks", 60, 5, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scaleDecision(cfg, tt.depth, tt.workers); got != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScalingControllerFollowsLag(t *testing.T) {
+	ds, err := NewDataStream(100, Block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make(chan BatchResult)
+	pool := newWorkerPool(context.Background(), ds, results)
+	cfg := ScalingConfig{MinWorkers: 1, MaxWorkers: 3, Interval: 5 * time.Millisecond, ScaleUpDepth: 10, ScaleDownDepth: 0}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runScalingController(ctx, pool, cfg)
+		close(done)
+	}()
+
+	// Nobody reads results yet, so the backlog cannot shrink and the pool should max out.
+	for i := 0; i < 50; i++ {
+		if err := ds.AddData(Batch{Values: []int{1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, func() bool { return pool.Size() == cfg.MaxWorkers })
+
+	// Once results are consumed the backlog empties and the pool falls back to the minimum.
+	total := 0
+	for total < 50 {
+		total += (<-results).Sum
+	}
+	waitFor(t, func() bool { return pool.Size() == cfg.MinWorkers })
+
+	cancel()
+	<-done
+	ds.Close()
+	pool.Wait()
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met before deadline")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
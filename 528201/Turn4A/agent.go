@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Agent runs the test a coordinator hands out and reports its metrics back.
+type Agent struct {
+	Name   string
+	API    coordinatorAPI
+	Client *http.Client // Client used to generate load
+}
+
+// finalReportAttempts bounds how often the final report is retried.
+const finalReportAttempts = 5
+
+// Run registers with the coordinator, waits for a test, runs it from the
+// shared start time while heartbeating and sending interim metrics, and
+// returns the final metrics once they have been reported.
+func (a *Agent) Run(ctx context.Context) (Metrics, error) {
+	reg, err := a.API.Register(ctx, RegisterRequest{Name: a.Name})
+	if err != nil {
+		return Metrics{}, fmt.Errorf("register: %w", err)
+	}
+	log.Printf("Registered as %s", reg.AgentID)
+
+	assignment, err := a.awaitAssignment(ctx, reg)
+	if err != nil {
+		return Metrics{}, err
+	}
+	plan := assignment.Plan
+	log.Printf("Assigned a %s-loop test starting at %s", plan.Mode, assignment.StartAt.Format(time.RFC3339Nano))
+
+	// Keep heartbeating for the rest of the test; a stop from the coordinator cancels it.
+	testCtx, cancel := context.WithCancel(ctx)
+	heartbeats := make(chan struct{})
+	go func() {
+		defer close(heartbeats)
+		a.heartbeat(testCtx, reg, cancel)
+	}()
+	defer func() { <-heartbeats }()
+	defer cancel()
+
+	rec := newRecorder()
+	var startTime time.Time
+	var sampler *resourceSampler
+	snapshot := func() Metrics {
+		m := Metrics{Mode: plan.Mode, Concurrency: plan.Concurrency}
+		if !startTime.IsZero() {
+			rec.fill(&m, time.Since(startTime))
+			sampler.fillAll(&m)
+		}
+		return m
+	}
+
+	var runErr error
+	seq := 0
+	timer := time.NewTimer(time.Until(assignment.StartAt))
+	select {
+	case <-timer.C:
+		sampler = startResourceSampler(resourceSampleInterval)
+		startTime = time.Now()
+		seq, runErr = a.runWithReports(testCtx, reg, plan, rec, snapshot)
+		sampler.stop()
+	case <-testCtx.Done():
+		timer.Stop()
+	}
+
+	// The results are worth delivering even if the agent itself is shutting down.
+	final := snapshot()
+	report := MetricsReport{AgentID: reg.AgentID, Seq: seq + 1, Final: true, Metrics: final}
+	err = a.sendFinal(context.WithoutCancel(ctx), reg, report)
+	return final, errors.Join(runErr, err)
+}
+
+// awaitAssignment heartbeats until the coordinator hands out a test.
+func (a *Agent) awaitAssignment(ctx context.Context, reg RegisterResponse) (*Assignment, error) {
+	ticker := time.NewTicker(reg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		resp, err := a.API.Heartbeat(ctx, Heartbeat{AgentID: reg.AgentID})
+		if err != nil {
+			log.Printf("Heartbeat failed: %v", err)
+		} else if resp.Assignment != nil {
+			return resp.Assignment, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// heartbeat keeps the agent alive in the coordinator's eyes until ctx is
+// done, calling stop if the coordinator aborts the test.
+func (a *Agent) heartbeat(ctx context.Context, reg RegisterResponse, stop context.CancelFunc) {
+	ticker := time.NewTicker(reg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		resp, err := a.API.Heartbeat(ctx, Heartbeat{AgentID: reg.AgentID})
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Heartbeat failed: %v", err)
+			}
+			continue
+		}
+		if resp.Stop {
+			log.Printf("Coordinator stopped the test")
+			stop()
+			return
+		}
+	}
+}
+
+// runWithReports runs plan, sending a cumulative snapshot every
+// ReportInterval. It returns the sequence number of the last snapshot sent.
+func (a *Agent) runWithReports(ctx context.Context, reg RegisterResponse, plan TestPlan, rec *recorder, snapshot func() Metrics) (int, error) {
+	done := make(chan error, 1)
+	go func() { done <- a.execute(ctx, plan, rec) }()
+
+	ticker := time.NewTicker(reg.ReportInterval)
+	defer ticker.Stop()
+	for seq := 0; ; {
+		select {
+		case err := <-done:
+			return seq, err
+		case <-ticker.C:
+			seq++
+			report := MetricsReport{AgentID: reg.AgentID, Seq: seq, Metrics: snapshot()}
+			if err := a.API.ReportMetrics(ctx, report); err != nil && ctx.Err() == nil {
+				log.Printf("Failed to send interim metrics: %v", err)
+			}
+		}
+	}
+}
+
+func (a *Agent) execute(ctx context.Context, plan TestPlan, rec *recorder) error {
+	client := a.Client
+	if plan.Client != nil {
+		var err error
+		if client, err = plan.Client.NewClient(); err != nil {
+			return err
+		}
+		defer client.CloseIdleConnections()
+	}
+	switch plan.Mode {
+	case "closed":
+		var workload Workload = urlWorkload(plan.URL)
+		if plan.ScenarioFile != "" {
+			s, err := LoadScenario(plan.ScenarioFile)
+			if err != nil {
+				return err
+			}
+			workload = s
+		}
+		closedLoop(ctx, client, workload, plan.Concurrency, plan.Duration, rec)
+	case "open":
+		openLoop(ctx, client, plan.URL, plan.Stages, plan.MaxInFlight, rec)
+	default:
+		return fmt.Errorf("unknown mode %q", plan.Mode)
+	}
+	return nil
+}
+
+// sendFinal delivers the final report, retrying in case the coordinator is briefly unreachable.
+func (a *Agent) sendFinal(ctx context.Context, reg RegisterResponse, report MetricsReport) error {
+	var err error
+	for attempt := 1; attempt <= finalReportAttempts; attempt++ {
+		if err = a.API.ReportMetrics(ctx, report); err == nil {
+			return nil
+		}
+		log.Printf("Failed to send final metrics (attempt %d): %v", attempt, err)
+		select {
+		case <-time.After(reg.HeartbeatInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("final report: %w", err)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ClientProfile spells out how the load generator connects, so that
+// connection reuse, pool size, and protocol are the same from run to run
+// instead of whatever the default client happens to do.
+type ClientProfile struct {
+	Protocol              string        `json:"protocol"`   // "http1", "h2c" (HTTP/2 without TLS), or "h2" (HTTP/2 over TLS)
+	KeepAlive             bool          `json:"keep_alive"` // Reuse connections; HTTP/2 always does
+	MaxIdleConnsPerHost   int           `json:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `json:"max_conns_per_host,omitempty"` // 0 for no limit; not enforced by h2c
+	DialTimeout           time.Duration `json:"dial_timeout"`
+	TLSHandshakeTimeout   time.Duration `json:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout,omitempty"` // 0 for none; not enforced by h2c
+	RequestTimeout        time.Duration `json:"request_timeout"`                   // The whole request, body included
+	InsecureSkipVerify    bool          `json:"insecure_skip_verify,omitempty"`
+	TraceConnections      bool          `json:"trace_connections"` // Record connection reuse and setup time per request
+}
+
+var defaultClientProfile = ClientProfile{
+	Protocol:            "http1",
+	KeepAlive:           true,
+	MaxIdleConnsPerHost: 100,
+	DialTimeout:         5 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+	RequestTimeout:      30 * time.Second,
+	TraceConnections:    true,
+}
+
+func (p ClientProfile) validate() error {
+	switch p.Protocol {
+	case "http1", "h2c", "h2":
+	default:
+		return fmt.Errorf("unknown protocol %q", p.Protocol)
+	}
+	if p.Protocol != "http1" && !p.KeepAlive {
+		return errors.New("keep-alive can only be turned off for http1")
+	}
+	return nil
+}
+
+// NewClient builds an http.Client that follows the profile.
+func (p ClientProfile) NewClient() (*http.Client, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: p.DialTimeout, KeepAlive: 30 * time.Second}
+	tlsConfig := &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify}
+
+	var transport http.RoundTripper
+	if p.Protocol == "h2c" {
+		transport = &http2.Transport{
+			AllowHTTP: true,
+			// h2c speaks HTTP/2 straight over TCP, so the "TLS" dial is a plain one.
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, addr)
+			},
+		}
+	} else {
+		t := &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSClientConfig:       tlsConfig,
+			DisableKeepAlives:     !p.KeepAlive,
+			MaxIdleConnsPerHost:   p.MaxIdleConnsPerHost,
+			MaxConnsPerHost:       p.MaxConnsPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   p.TLSHandshakeTimeout,
+			ResponseHeaderTimeout: p.ResponseHeaderTimeout,
+			ForceAttemptHTTP2:     p.Protocol == "h2",
+		}
+		if p.Protocol == "http1" {
+			// A non-nil, empty map keeps TLS connections from upgrading to HTTP/2.
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+		transport = t
+	}
+	if p.TraceConnections {
+		transport = &tracingTransport{base: transport}
+	}
+	return &http.Client{Transport: transport, Timeout: p.RequestTimeout}, nil
+}
+
+// connObserver is told, for every traced request, whether it reused a
+// connection and, if not, how long setting up the new one took.
+type connObserver interface {
+	observeConn(reused bool, setup time.Duration)
+}
+
+type connObserverKey struct{}
+
+// withConnObserver has requests made with ctx report their connection to o.
+// It costs nothing unless the client traces connections.
+func withConnObserver(ctx context.Context, o connObserver) context.Context {
+	return context.WithValue(ctx, connObserverKey{}, o)
+}
+
+// tracingTransport reports each request's connection to the connObserver in
+// its context, using httptrace.
+type tracingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	o, ok := req.Context().Value(connObserverKey{}).(connObserver)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	// Dials can run on another goroutine, and may even outlive the request.
+	var mu sync.Mutex
+	var requested, dialing time.Time
+	startDial := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if dialing.IsZero() {
+			dialing = time.Now()
+		}
+	}
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			mu.Lock()
+			defer mu.Unlock()
+			requested = time.Now()
+		},
+		DNSStart:     func(httptrace.DNSStartInfo) { startDial() },
+		ConnectStart: func(string, string) { startDial() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				o.observeConn(true, 0)
+				return
+			}
+			// Without dial events, as with h2c's own dialer or a connection
+			// dialed for another request, count from when one was asked for.
+			mu.Lock()
+			from := dialing
+			if from.IsZero() {
+				from = requested
+			}
+			mu.Unlock()
+			o.observeConn(false, time.Since(from))
+		},
+	}
+	return t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// protoServer counts the connections it accepts and the HTTP versions it serves.
+type protoServer struct {
+	conns  atomic.Int64
+	mu     sync.Mutex
+	protos map[string]int
+}
+
+func newProtoServer() *protoServer { return &protoServer{protos: map[string]int{}} }
+
+func (s *protoServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.protos[r.Proto]++
+	s.mu.Unlock()
+}
+
+func (s *protoServer) connState(_ net.Conn, state http.ConnState) {
+	if state == http.StateNew {
+		s.conns.Add(1)
+	}
+}
+
+func (s *protoServer) only(proto string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.protos) == 1 && s.protos[proto] > 0
+}
+
+func testProfile(protocol string) ClientProfile {
+	p := defaultClientProfile
+	p.Protocol = protocol
+	p.InsecureSkipVerify = true
+	return p
+}
+
+func runWithProfile(t *testing.T, p ClientProfile, url string) Metrics {
+	t.Helper()
+	client, err := p.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.CloseIdleConnections()
+	return runTest(context.Background(), client, urlWorkload(url), 2, 100*time.Millisecond)
+}
+
+func TestClientProfileKeepAlive(t *testing.T) {
+	for _, keepAlive := range []bool{true, false} {
+		ps := newProtoServer()
+		srv := httptest.NewUnstartedServer(ps)
+		srv.Config.ConnState = ps.connState
+		srv.Start()
+
+		p := testProfile("http1")
+		p.KeepAlive = keepAlive
+		m := runWithProfile(t, p, srv.URL)
+		srv.Close()
+
+		if m.TotalRequests == 0 || m.FailedRequests != 0 || m.ConnsOpened+m.ConnsReused != m.TotalRequests {
+			t.Fatalf("keep-alive %v: expected every request traced, got %+v", keepAlive, m)
+		}
+		if int64(m.ConnsOpened) != ps.conns.Load() {
+			t.Errorf("keep-alive %v: traced %d new connections, server saw %d", keepAlive, m.ConnsOpened, ps.conns.Load())
+		}
+		if keepAlive && (m.ConnsOpened > 2 || m.ConnsReused == 0) {
+			t.Errorf("expected two workers to reuse two connections, got %d new and %d reused", m.ConnsOpened, m.ConnsReused)
+		}
+		if !keepAlive && m.ConnsReused != 0 {
+			t.Errorf("expected a new connection per request without keep-alive, got %d reused", m.ConnsReused)
+		}
+		if m.ConnSetupP50 <= 0 || m.ConnSetup.Count() != uint64(m.ConnsOpened) {
+			t.Errorf("keep-alive %v: expected connection setup times, got p50 %s", keepAlive, m.ConnSetupP50)
+		}
+	}
+}
+
+func TestClientProfileProtocols(t *testing.T) {
+	plain := newProtoServer()
+	cleartext := httptest.NewServer(h2c.NewHandler(plain, &http2.Server{}))
+	defer cleartext.Close()
+	secure := newProtoServer()
+	tlsSrv := httptest.NewUnstartedServer(secure)
+	tlsSrv.EnableHTTP2 = true
+	tlsSrv.StartTLS()
+	defer tlsSrv.Close()
+
+	if m := runWithProfile(t, testProfile("h2c"), cleartext.URL); m.FailedRequests != 0 || !plain.only("HTTP/2.0") {
+		t.Errorf("h2c: expected only HTTP/2 requests, got %v (%+v)", plain.protos, m.Errors)
+	}
+	if m := runWithProfile(t, testProfile("h2"), tlsSrv.URL); m.FailedRequests != 0 || !secure.only("HTTP/2.0") {
+		t.Errorf("h2: expected only HTTP/2 requests, got %v (%+v)", secure.protos, m.Errors)
+	}
+	// The same TLS server, which offers HTTP/2, is held to HTTP/1.1 by the http1 profile.
+	secure.mu.Lock()
+	secure.protos = map[string]int{}
+	secure.mu.Unlock()
+	if m := runWithProfile(t, testProfile("http1"), tlsSrv.URL); m.FailedRequests != 0 || !secure.only("HTTP/1.1") {
+		t.Errorf("http1: expected only HTTP/1.1 requests, got %v (%+v)", secure.protos, m.Errors)
+	}
+}
+
+func TestClientProfileValidates(t *testing.T) {
+	noKeepAlive := testProfile("h2")
+	noKeepAlive.KeepAlive = false
+	for name, p := range map[string]ClientProfile{"Unknown protocol": testProfile("spdy"), "HTTP/2 without keep-alive": noKeepAlive} {
+		if _, err := p.NewClient(); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CoordinatorConfig sets the protocol timings handed to agents.
+type CoordinatorConfig struct {
+	HeartbeatInterval time.Duration
+	ReportInterval    time.Duration // How often agents send interim metrics
+	DeadAfter         time.Duration // An agent silent for this long is considered dead
+	StartDelay        time.Duration // Lead time between Start and the shared start time
+}
+
+var defaultCoordinatorConfig = CoordinatorConfig{
+	HeartbeatInterval: time.Second,
+	ReportInterval:    5 * time.Second,
+	DeadAfter:         5 * time.Second,
+	StartDelay:        3 * time.Second,
+}
+
+// Agent statuses as seen by the coordinator.
+const (
+	agentIdle     = "idle"     // Registered, not part of the test
+	agentRunning  = "running"  // Assigned and reporting
+	agentFinished = "finished" // Sent its final report
+	agentDead     = "dead"     // Assigned but silent for longer than DeadAfter
+)
+
+var (
+	ErrUnknownAgent = errors.New("unknown agent")
+	ErrNoAgents     = errors.New("no live agents to run the test")
+	ErrTestStarted  = errors.New("a test has already been started")
+)
+
+type agentState struct {
+	id       string
+	name     string
+	status   string
+	assigned bool
+	lastSeen time.Time
+	seq      int
+	metrics  Metrics // Latest cumulative report
+}
+
+// Coordinator registers agents, hands out one test, tracks their liveness and
+// progress, and merges their results. It is safe for concurrent use and
+// serves agents either in process or through Handler.
+type Coordinator struct {
+	cfg CoordinatorConfig
+
+	mu      sync.Mutex
+	agents  map[string]*agentState
+	order   []string // Agent IDs in registration order
+	plan    *TestPlan
+	startAt time.Time
+	stopped bool
+	changed chan struct{} // Closed and replaced whenever an agent registers or finishes
+}
+
+func NewCoordinator(cfg CoordinatorConfig) *Coordinator {
+	return &Coordinator{cfg: cfg, agents: make(map[string]*agentState), changed: make(chan struct{})}
+}
+
+func (c *Coordinator) Register(ctx context.Context, req RegisterRequest) (RegisterResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := fmt.Sprintf("agent-%d", len(c.order)+1)
+	c.agents[id] = &agentState{id: id, name: req.Name, status: agentIdle, lastSeen: time.Now()}
+	c.order = append(c.order, id)
+	c.notifyLocked()
+	log.Printf("Agent %s (%s) registered", id, req.Name)
+	return RegisterResponse{AgentID: id, HeartbeatInterval: c.cfg.HeartbeatInterval, ReportInterval: c.cfg.ReportInterval}, nil
+}
+
+func (c *Coordinator) Heartbeat(ctx context.Context, hb Heartbeat) (HeartbeatResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agent, err := c.touchLocked(hb.AgentID)
+	if err != nil {
+		return HeartbeatResponse{}, err
+	}
+	resp := HeartbeatResponse{Stop: c.stopped && agent.assigned}
+	if agent.assigned && c.plan != nil {
+		resp.Assignment = &Assignment{Plan: c.shareLocked(), StartAt: c.startAt}
+	}
+	return resp, nil
+}
+
+func (c *Coordinator) ReportMetrics(ctx context.Context, report MetricsReport) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	agent, err := c.touchLocked(report.AgentID)
+	if err != nil {
+		return err
+	}
+	if !agent.assigned {
+		return fmt.Errorf("%s is not part of the test", agent.id)
+	}
+	if report.Seq <= agent.seq {
+		return nil // A retried or reordered older report
+	}
+	agent.seq = report.Seq
+	agent.metrics = report.Metrics
+	if report.Final {
+		agent.status = agentFinished
+		c.notifyLocked()
+		log.Printf("Agent %s finished: %d requests", agent.id, report.Metrics.TotalRequests)
+	}
+	return nil
+}
+
+// touchLocked records that an agent is alive, reviving it if it was presumed dead.
+func (c *Coordinator) touchLocked(id string) (*agentState, error) {
+	agent, ok := c.agents[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAgent, id)
+	}
+	agent.lastSeen = time.Now()
+	if agent.status == agentDead {
+		agent.status = agentIdle
+		if agent.assigned {
+			agent.status = agentRunning
+			log.Printf("Agent %s is back", id)
+		}
+	}
+	return agent, nil
+}
+
+// sweepLocked marks agents that have gone quiet as dead. An idle agent that
+// goes quiet is simply dropped from consideration, as it holds no results.
+func (c *Coordinator) sweepLocked() {
+	for _, id := range c.order {
+		agent := c.agents[id]
+		if agent.status == agentFinished || agent.status == agentDead || time.Since(agent.lastSeen) < c.cfg.DeadAfter {
+			continue
+		}
+		agent.status = agentDead
+		if agent.assigned {
+			log.Printf("Agent %s missed heartbeats for %s, presumed dead", id, c.cfg.DeadAfter)
+			c.notifyLocked()
+		}
+	}
+}
+
+func (c *Coordinator) notifyLocked() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// WaitForAgents blocks until at least n agents are alive and registered.
+func (c *Coordinator) WaitForAgents(ctx context.Context, n int) error {
+	ticker := time.NewTicker(c.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		c.mu.Lock()
+		c.sweepLocked()
+		live := 0
+		for _, agent := range c.agents {
+			if agent.status != agentDead {
+				live++
+			}
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		if live >= n {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Start assigns plan to every live agent. They pick it up with their next
+// heartbeat and all begin at the returned time.
+func (c *Coordinator) Start(plan TestPlan) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.plan != nil {
+		return time.Time{}, ErrTestStarted
+	}
+	c.sweepLocked()
+	assigned := 0
+	for _, agent := range c.agents {
+		if agent.status == agentIdle {
+			agent.assigned = true
+			agent.status = agentRunning
+			assigned++
+		}
+	}
+	if assigned == 0 {
+		return time.Time{}, ErrNoAgents
+	}
+	c.plan = &plan
+	c.startAt = time.Now().Add(c.cfg.StartDelay)
+	log.Printf("Starting %s-loop test on %d agents at %s", plan.Mode, assigned, c.startAt.Format(time.RFC3339Nano))
+	return c.startAt, nil
+}
+
+// shareLocked is one agent's part of the plan: open-loop arrival rates are
+// split evenly across the assigned agents.
+func (c *Coordinator) shareLocked() TestPlan {
+	share := *c.plan
+	if len(share.Stages) > 0 {
+		n := 0
+		for _, agent := range c.agents {
+			if agent.assigned {
+				n++
+			}
+		}
+		share.Stages = make([]Stage, len(c.plan.Stages))
+		for i, st := range c.plan.Stages {
+			share.Stages[i] = Stage{Duration: st.Duration, TargetRPS: st.TargetRPS / float64(n)}
+		}
+	}
+	return share
+}
+
+// Stop aborts the running test; agents stop at their next heartbeat and send final reports.
+func (c *Coordinator) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopped = true
+}
+
+// AgentResult is one agent's part of a Report.
+type AgentResult struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Metrics Metrics `json:"metrics"`
+}
+
+// Report merges the latest results of every agent that took part in the test.
+type Report struct {
+	Plan     *TestPlan     `json:"plan"`
+	StartAt  time.Time     `json:"start_at"`
+	Complete bool          `json:"complete"` // Every agent sent its final report
+	Agents   []AgentResult `json:"agents"`
+	Total    Metrics       `json:"total"`
+}
+
+// Report returns the results so far. Agents that died still contribute what
+// they reported before going silent.
+func (c *Coordinator) Report() Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reportLocked()
+}
+
+func (c *Coordinator) reportLocked() Report {
+	c.sweepLocked()
+	report := Report{Plan: c.plan, StartAt: c.startAt, Complete: c.plan != nil}
+	var parts []Metrics
+	for _, id := range c.order {
+		agent := c.agents[id]
+		if !agent.assigned {
+			continue
+		}
+		report.Agents = append(report.Agents, AgentResult{ID: id, Name: agent.name, Status: agent.status, Metrics: agent.metrics})
+		parts = append(parts, agent.metrics)
+		if agent.status != agentFinished {
+			report.Complete = false
+		}
+	}
+	report.Total = mergeMetrics(parts)
+	return report
+}
+
+// Wait blocks until every assigned agent has finished or died, or ctx is
+// done, and returns the merged report.
+func (c *Coordinator) Wait(ctx context.Context) Report {
+	ticker := time.NewTicker(c.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		c.mu.Lock()
+		report := c.reportLocked()
+		pending := false
+		for _, agent := range report.Agents {
+			pending = pending || agent.Status == agentRunning
+		}
+		changed := c.changed
+		c.mu.Unlock()
+		if report.Plan != nil && !pending {
+			return report
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return report
+		}
+	}
+}
+
+// Handler serves the protocol over HTTP: POST /register, /heartbeat, and
+// /metrics for agents, and GET /report for the merged results so far.
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register", handleJSON(c.Register))
+	mux.HandleFunc("POST /heartbeat", handleJSON(c.Heartbeat))
+	mux.HandleFunc("POST /metrics", handleJSON(func(ctx context.Context, report MetricsReport) (struct{}, error) {
+		return struct{}{}, c.ReportMetrics(ctx, report)
+	}))
+	mux.HandleFunc("GET /report", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Report())
+	})
+	return mux
+}
+
+// handleJSON adapts a protocol method to an HTTP handler.
+func handleJSON[In, Out any](method func(context.Context, In) (Out, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in In
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		out, err := method(r.Context(), in)
+		if errors.Is(err, ErrUnknownAgent) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
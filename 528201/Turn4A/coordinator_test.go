@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testCoordinatorConfig = CoordinatorConfig{
+	HeartbeatInterval: 20 * time.Millisecond,
+	ReportInterval:    50 * time.Millisecond,
+	DeadAfter:         200 * time.Millisecond,
+	StartDelay:        100 * time.Millisecond,
+}
+
+// countingAPI counts the interim reports an agent sends.
+type countingAPI struct {
+	coordinatorAPI
+	interim atomic.Int64
+}
+
+func (c *countingAPI) ReportMetrics(ctx context.Context, report MetricsReport) error {
+	if !report.Final {
+		c.interim.Add(1)
+	}
+	return c.coordinatorAPI.ReportMetrics(ctx, report)
+}
+
+func TestDistributedTestMergesAgentResults(t *testing.T) {
+	var hits atomic.Int64
+	var firstHit atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		firstHit.CompareAndSwap(0, time.Now().UnixNano())
+		hits.Add(1)
+		time.Sleep(time.Millisecond)
+	}))
+	defer target.Close()
+
+	c := NewCoordinator(testCoordinatorConfig)
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	const agents = 3
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	apis := make([]*countingAPI, agents)
+	results := make([]Metrics, agents)
+	var wg sync.WaitGroup
+	for i := range agents {
+		apis[i] = &countingAPI{coordinatorAPI: newHTTPCoordinator(srv.URL, srv.Client())}
+		agent := &Agent{Name: "test", API: apis[i], Client: target.Client()}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			if results[i], err = agent.Run(ctx); err != nil {
+				t.Errorf("agent %d: %v", i, err)
+			}
+		}()
+	}
+
+	if err := c.WaitForAgents(ctx, agents); err != nil {
+		t.Fatal(err)
+	}
+	startAt, err := c.Start(TestPlan{Mode: "closed", URL: target.URL, Concurrency: 2, Duration: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := c.Wait(ctx)
+	wg.Wait()
+
+	if !report.Complete || len(report.Agents) != agents {
+		t.Fatalf("expected %d finished agents, got %+v", agents, report.Agents)
+	}
+	if first := time.Unix(0, firstHit.Load()); first.Before(startAt) {
+		t.Errorf("expected no requests before the shared start %s, got one at %s", startAt, first)
+	}
+	sum := 0
+	for i, agent := range report.Agents {
+		if agent.Status != agentFinished {
+			t.Errorf("%s: expected finished, got %s", agent.ID, agent.Status)
+		}
+		sum += agent.Metrics.TotalRequests
+		if apis[i].interim.Load() == 0 {
+			t.Errorf("agent %d sent no interim reports", i)
+		}
+	}
+	total := report.Total
+	if total.TotalRequests != sum || int64(sum) != hits.Load() {
+		t.Errorf("expected the total to match the agents (%d) and the server (%d), got %d", sum, hits.Load(), total.TotalRequests)
+	}
+	if total.Latency.Count() != uint64(sum) || total.StatusCodes[200] != sum {
+		t.Errorf("expected %d merged latencies and 200s, got %d and %v", sum, total.Latency.Count(), total.StatusCodes)
+	}
+	if total.Concurrency != agents*2 || total.LatencyP50 <= 0 || total.LatencyMax < total.LatencyP99 {
+		t.Errorf("unexpected merged metrics %+v", total)
+	}
+}
+
+func TestCoordinatorDetectsDeadAgents(t *testing.T) {
+	c := NewCoordinator(testCoordinatorConfig)
+	ctx := context.Background()
+	live, _ := c.Register(ctx, RegisterRequest{Name: "live"})
+	silent, _ := c.Register(ctx, RegisterRequest{Name: "silent"})
+	if _, err := c.Start(TestPlan{Mode: "closed", Concurrency: 1, Duration: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Start(TestPlan{Mode: "closed"}); err != ErrTestStarted {
+		t.Errorf("expected a second start to fail, got %v", err)
+	}
+
+	// The silent agent reports once and then goes quiet.
+	partial := Metrics{TotalRequests: 5, Latency: NewHistogram()}
+	c.ReportMetrics(ctx, MetricsReport{AgentID: silent.AgentID, Seq: 1, Metrics: partial})
+	final := Metrics{TotalRequests: 10, Latency: NewHistogram()}
+	if err := c.ReportMetrics(ctx, MetricsReport{AgentID: live.AgentID, Seq: 2, Final: true, Metrics: final}); err != nil {
+		t.Fatal(err)
+	}
+	// A late interim report must not overwrite the final one.
+	c.ReportMetrics(ctx, MetricsReport{AgentID: live.AgentID, Seq: 1, Metrics: Metrics{TotalRequests: 1}})
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	report := c.Wait(waitCtx)
+	if waitCtx.Err() != nil {
+		t.Fatal("Wait did not return once the silent agent was dead")
+	}
+	if report.Complete || report.Agents[0].Status != agentFinished || report.Agents[1].Status != agentDead {
+		t.Errorf("expected a finished and a dead agent, got %+v", report.Agents)
+	}
+	if report.Total.TotalRequests != 15 {
+		t.Errorf("expected the dead agent's partial results in the total, got %d", report.Total.TotalRequests)
+	}
+
+	// A heartbeat brings the agent back into the test.
+	if _, err := c.Heartbeat(ctx, Heartbeat{AgentID: silent.AgentID}); err != nil {
+		t.Fatal(err)
+	}
+	if status := c.Report().Agents[1].Status; status != agentRunning {
+		t.Errorf("expected the agent to be running again, got %s", status)
+	}
+	if _, err := c.Heartbeat(ctx, Heartbeat{AgentID: "agent-9"}); err == nil {
+		t.Error("expected an unknown agent to be rejected")
+	}
+}
+
+func TestCoordinatorSplitsOpenLoopRate(t *testing.T) {
+	c := NewCoordinator(testCoordinatorConfig)
+	ctx := context.Background()
+	a, _ := c.Register(ctx, RegisterRequest{})
+	c.Register(ctx, RegisterRequest{})
+	if _, err := c.Start(TestPlan{Mode: "open", Stages: []Stage{{time.Second, 100}, {time.Second, 300}}}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Heartbeat(ctx, Heartbeat{AgentID: a.AgentID})
+	if err != nil || resp.Assignment == nil {
+		t.Fatalf("expected an assignment, got %+v (%v)", resp, err)
+	}
+	if stages := resp.Assignment.Plan.Stages; stages[0].TargetRPS != 50 || stages[1].TargetRPS != 150 {
+		t.Errorf("expected the rate split across two agents, got %+v", stages)
+	}
+}
+
+func TestReportWithoutAgents(t *testing.T) {
+	c := NewCoordinator(testCoordinatorConfig)
+	if _, err := c.Start(TestPlan{Mode: "closed"}); err != ErrNoAgents {
+		t.Errorf("expected ErrNoAgents, got %v", err)
+	}
+	report := c.Report()
+	if report.Complete || report.Total.TotalRequests != 0 || math.IsNaN(report.Total.ErrorRate) {
+		t.Errorf("expected an empty report, got %+v", report)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboard shows the step that is running, refreshed at a fixed interval.
+// On a terminal it redraws a full screen; otherwise, such as when output is
+// piped to a file, it prints one plain line per refresh.
+type dashboard struct {
+	out io.Writer
+	tty bool
+
+	mu       sync.Mutex
+	snapshot func() Metrics // Current step's metrics so far; nil between steps
+	prev     Metrics        // At the previous refresh, for the current rate
+	prevAt   time.Time
+}
+
+func newDashboard(out *os.File) *dashboard {
+	info, err := out.Stat()
+	return &dashboard{out: out, tty: err == nil && info.Mode()&os.ModeCharDevice != 0}
+}
+
+// startDashboard shows a dashboard on stdout, refreshed every second, until
+// stop is called. Steps passed to live are shown as they run.
+func startDashboard(ctx context.Context) (live func(snapshot func() Metrics), stop func()) {
+	d := newDashboard(os.Stdout)
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.run(ctx, time.Second)
+	}()
+	return d.watch, func() {
+		cancel()
+		<-done
+	}
+}
+
+// watch switches the dashboard to a new step.
+func (d *dashboard) watch(snapshot func() Metrics) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.snapshot = snapshot
+	d.prev, d.prevAt = Metrics{}, time.Now()
+}
+
+// run refreshes the dashboard every interval until ctx is done.
+func (d *dashboard) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			d.refresh(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *dashboard) refresh(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.snapshot == nil {
+		return
+	}
+	m := d.snapshot()
+	rps := 0.0
+	if dt := now.Sub(d.prevAt).Seconds(); dt > 0 {
+		rps = float64(m.TotalRequests-d.prev.TotalRequests) / dt
+	}
+	d.prev, d.prevAt = m, now
+	if d.tty {
+		renderScreen(d.out, now, m, rps)
+	} else {
+		renderLine(d.out, now, m, rps)
+	}
+}
+
+func megabytes(b uint64) string { return fmt.Sprintf("%.1f MB", float64(b)/(1<<20)) }
+
+// renderLine is the plain-text form: one self-contained line per refresh.
+func renderLine(w io.Writer, now time.Time, m Metrics, rps float64) {
+	fmt.Fprintf(w, "%s concurrency=%d elapsed=%s requests=%d rps=%.1f p50=%s p90=%s p99=%s errors=%d (%.2f%%) cpu=%.0f%% rss=%s goroutines=%d\n",
+		now.Format("15:04:05"), m.Concurrency, m.ElapsedTime.Round(time.Second), m.TotalRequests, rps,
+		m.LatencyP50, m.LatencyP90, m.LatencyP99, m.FailedRequests, m.ErrorRate, m.CPUUsage, megabytes(m.MemoryUsage), m.Goroutines)
+}
+
+// renderScreen clears the terminal and draws the full dashboard.
+func renderScreen(w io.Writer, now time.Time, m Metrics, rps float64) {
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J") // Cursor home, clear screen
+	fmt.Fprintf(&b, "Stress test — %s\n\n", now.Format("15:04:05"))
+	row := func(label, format string, args ...any) {
+		fmt.Fprintf(&b, "  %-14s "+format+"\n", append([]any{label}, args...)...)
+	}
+	row("Concurrency", "%d", m.Concurrency)
+	row("Step elapsed", "%s", m.ElapsedTime.Round(time.Second))
+	row("Requests", "%d", m.TotalRequests)
+	row("Throughput", "%.1f/s now, %.1f/s for the step", rps, m.AchievedRPS)
+	row("Latency", "p50 %s   p90 %s   p99 %s   max %s", m.LatencyP50, m.LatencyP90, m.LatencyP99, m.LatencyMax)
+	row("Errors", "%d (%.2f%%)", m.FailedRequests, m.ErrorRate)
+	for _, code := range slices.Sorted(maps.Keys(m.StatusCodes)) {
+		if code != 200 {
+			row("", "HTTP %d: %d", code, m.StatusCodes[code])
+		}
+	}
+	for _, class := range slices.Sorted(maps.Keys(m.Errors)) {
+		row("", "%s: %d", class, m.Errors[class])
+	}
+	row("Connections", "%d new, %d reused", m.ConnsOpened, m.ConnsReused)
+	b.WriteString("\n")
+	row("Tester CPU", "%.0f%%", m.CPUUsage)
+	row("Tester memory", "%s RSS", megabytes(m.MemoryUsage))
+	row("Goroutines", "%d", m.Goroutines)
+	io.WriteString(w, b.String())
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a strings.Builder safe for the dashboard goroutine and the test.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.b.String()
+}
+
+func TestDashboardRendersRateFromDeltas(t *testing.T) {
+	for _, tty := range []bool{false, true} {
+		var out strings.Builder
+		d := &dashboard{out: &out, tty: tty}
+		d.refresh(time.Now()) // Nothing to show before the first step
+		if out.Len() != 0 {
+			t.Fatalf("tty %v: expected no output before a step, got %q", tty, out.String())
+		}
+
+		total := 0
+		d.watch(func() Metrics {
+			return Metrics{Concurrency: 8, TotalRequests: total, FailedRequests: 3, ErrorRate: 1.5, Errors: map[string]int{errTimeout: 3},
+				LatencyP99: 42 * time.Millisecond, CPUUsage: 80, MemoryUsage: 3 << 20, Goroutines: 20}
+		})
+		start := d.prevAt
+		total = 200
+		d.refresh(start.Add(time.Second))
+		total = 500
+		d.refresh(start.Add(3 * time.Second))
+
+		got := out.String()
+		if tty {
+			for _, want := range []string{"\033[2J", "Concurrency", "150.0/s now", "p99 42ms", "timeout: 3", "80%", "3.0 MB RSS"} {
+				if !strings.Contains(got, want) {
+					t.Errorf("expected the screen to contain %q, got %q", want, got)
+				}
+			}
+			continue
+		}
+		lines := strings.Split(strings.TrimSpace(got), "\n")
+		if len(lines) != 2 || !strings.Contains(lines[0], "rps=200.0") || !strings.Contains(lines[1], "rps=150.0") {
+			t.Errorf("expected two lines at 200 and 150 requests/s, got %q", got)
+		}
+		if strings.Contains(got, "\033") || !strings.Contains(lines[1], "concurrency=8") || !strings.Contains(lines[1], "cpu=80%") {
+			t.Errorf("expected plain lines with the step's figures, got %q", got)
+		}
+	}
+}
+
+func TestDashboardFollowsStressTest(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	var out syncBuffer
+	d := &dashboard{out: &out}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.run(ctx, 20*time.Millisecond)
+	}()
+	results, _ := stressTest(context.Background(), srv.Client(), urlWorkload(srv.URL), 4, 2, 150*time.Millisecond, SLO{}, d.watch)
+	cancel()
+	<-done
+
+	got := out.String()
+	if len(results) != 2 || !strings.Contains(got, "concurrency=2 ") || !strings.Contains(got, "concurrency=4 ") {
+		t.Errorf("expected lines for both steps, got %q", got)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+)
+
+// Error classes reported in Metrics.Errors.
+const (
+	errDNS      = "dns"
+	errConnect  = "connect"
+	errTLS      = "tls"
+	errTimeout  = "timeout"
+	errReset    = "reset"
+	errCanceled = "canceled"
+	errExtract  = "extract" // A scenario step could not extract a value from its response
+	errOther    = "other"
+)
+
+// classifyError sorts a failed request into one of the error classes above,
+// so a flood of timeouts can be told apart from a server refusing connections.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+
+	switch {
+	case errors.Is(err, errExtractFailed):
+		return errExtract
+	case errors.As(err, &dnsErr):
+		return errDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.Is(err, context.Canceled):
+		return errCanceled
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr),
+		errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr), errors.As(err, &invalidCert):
+		return errTLS
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return errReset
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return errConnect
+	}
+	return errOther
+}
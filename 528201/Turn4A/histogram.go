@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram records latencies in log-linear buckets in the manner of
+// HdrHistogram: every value is kept to three significant digits, memory grows
+// only with the largest value recorded, and histograms filled by different
+// goroutines can be merged without losing precision. It is not safe for
+// concurrent use.
+type Histogram struct {
+	counts []uint64
+	total  uint64
+	min    int64
+	max    int64
+	sum    float64
+}
+
+const (
+	subBucketBits      = 11 // 2048 sub-buckets resolve 1 part in 1000 within every power of two
+	subBucketHalfBits  = subBucketBits - 1
+	subBucketCount     = 1 << subBucketBits
+	subBucketHalfCount = subBucketCount / 2
+	subBucketMask      = subBucketCount - 1
+)
+
+func NewHistogram() *Histogram {
+	return &Histogram{}
+}
+
+// countsIndex maps a value to its bucket. Values below subBucketCount get a
+// bucket each; above that every power of two is split into subBucketHalfCount buckets.
+func countsIndex(v int64) int {
+	bucket := bits.Len64(uint64(v)|subBucketMask) - subBucketBits
+	sub := int(v >> bucket)
+	return (bucket+1)<<subBucketHalfBits + sub - subBucketHalfCount
+}
+
+// bucketRange returns the lowest value in bucket i and the bucket's width.
+func bucketRange(i int) (low, width int64) {
+	bucket := i>>subBucketHalfBits - 1
+	sub := i&(subBucketHalfCount-1) + subBucketHalfCount
+	if bucket < 0 {
+		sub -= subBucketHalfCount
+		bucket = 0
+	}
+	return int64(sub) << bucket, 1 << bucket
+}
+
+// Record adds one observation. Negative durations are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	v := max(int64(d), 0)
+	i := countsIndex(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	if h.total == 0 || v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += float64(v)
+}
+
+// Merge adds every observation in other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(other.counts)-len(h.counts))...)
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	if h.total == 0 || other.min < h.min {
+		h.min = other.min
+	}
+	h.max = max(h.max, other.max)
+	h.total += other.total
+	h.sum += other.sum
+}
+
+// Percentile returns the latency at or below which p percent of observations
+// fall, accurate to the histogram's precision. It returns 0 when empty.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(min(max(p, 0), 100) / 100 * float64(h.total)))
+	target = max(target, 1)
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			low, width := bucketRange(i)
+			return time.Duration(min(low+width-1, h.max))
+		}
+	}
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) Count() uint64 { return h.total }
+
+func (h *Histogram) Min() time.Duration { return time.Duration(h.min) }
+
+func (h *Histogram) Max() time.Duration { return time.Duration(h.max) }
+
+// Mean returns the exact average of the recorded values.
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / float64(h.total))
+}
+
+// histogramJSON is the wire form of a Histogram: only non-empty buckets are
+// listed, each as [lowest value in ns, count], so it stays small and can be
+// merged after decoding just like the original.
+type histogramJSON struct {
+	Count   uint64     `json:"count"`
+	Min     int64      `json:"min"`
+	Max     int64      `json:"max"`
+	Sum     float64    `json:"sum"`
+	Buckets [][2]int64 `json:"buckets"`
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	out := histogramJSON{Count: h.total, Min: h.min, Max: h.max, Sum: h.sum, Buckets: [][2]int64{}}
+	for i, c := range h.counts {
+		if c > 0 {
+			low, _ := bucketRange(i)
+			out.Buckets = append(out.Buckets, [2]int64{low, int64(c)})
+		}
+	}
+	return json.Marshal(out)
+}
+
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	var in histogramJSON
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	*h = Histogram{total: in.Count, min: in.Min, max: in.Max, sum: in.Sum}
+	for _, b := range in.Buckets {
+		i := countsIndex(b[0])
+		if i >= len(h.counts) {
+			h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+		}
+		h.counts[i] += uint64(b[1])
+	}
+	return nil
+}
+
+// GobEncode and GobDecode let a Histogram travel over net/rpc in the same
+// sparse form as its JSON.
+func (h *Histogram) GobEncode() ([]byte, error) { return h.MarshalJSON() }
+
+func (h *Histogram) GobDecode(data []byte) error { return h.UnmarshalJSON(data) }
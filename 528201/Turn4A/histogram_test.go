@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentilesWithinPrecision(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{99, 9900 * time.Microsecond},
+		{99.9, 9990 * time.Microsecond},
+		{100, 10 * time.Millisecond},
+	} {
+		got := h.Percentile(tt.p)
+		if diff := math.Abs(float64(got-tt.want)) / float64(tt.want); diff > 0.001 {
+			t.Errorf("p%v: expected %s within 0.1%%, got %s", tt.p, tt.want, got)
+		}
+	}
+	if h.Count() != 10000 || h.Min() != time.Microsecond || h.Max() != 10*time.Millisecond {
+		t.Errorf("unexpected count %d, min %s, max %s", h.Count(), h.Min(), h.Max())
+	}
+	if mean := h.Mean(); mean != 5000500*time.Nanosecond {
+		t.Errorf("expected exact mean 5.0005ms, got %s", mean)
+	}
+}
+
+func TestHistogramBucketsRoundTrip(t *testing.T) {
+	for _, v := range []int64{0, 1, 2047, 2048, 4095, 4096, 123456789, 1 << 40} {
+		low, width := bucketRange(countsIndex(v))
+		if v < low || v >= low+width {
+			t.Errorf("%d mapped to bucket [%d, %d)", v, low, low+width)
+		}
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	fast, slow, all := NewHistogram(), NewHistogram(), NewHistogram()
+	for i := 0; i < 900; i++ {
+		fast.Record(time.Millisecond)
+		all.Record(time.Millisecond)
+	}
+	for i := 0; i < 100; i++ {
+		slow.Record(time.Second)
+		all.Record(time.Second)
+	}
+
+	merged := NewHistogram()
+	merged.Merge(fast)
+	merged.Merge(slow)
+	merged.Merge(nil)
+	for _, p := range []float64{50, 90, 91, 99, 100} {
+		if got, want := merged.Percentile(p), all.Percentile(p); got != want {
+			t.Errorf("p%v: merged %s, expected %s", p, got, want)
+		}
+	}
+	if merged.Min() != time.Millisecond || merged.Count() != 1000 {
+		t.Errorf("unexpected merged min %s, count %d", merged.Min(), merged.Count())
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	h := NewHistogram()
+	if h.Percentile(99) != 0 || h.Mean() != 0 {
+		t.Error("expected zero percentiles and mean from an empty histogram")
+	}
+}
+
+func TestHistogramJSONRoundTrip(t *testing.T) {
+	h := NewHistogram()
+	for _, d := range []time.Duration{0, 3 * time.Microsecond, 40 * time.Millisecond, 40 * time.Millisecond, 2 * time.Second} {
+		h.Record(d)
+	}
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Histogram
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []float64{0, 25, 50, 75, 100} {
+		if got, want := decoded.Percentile(p), h.Percentile(p); got != want {
+			t.Errorf("p%v: decoded %s, expected %s", p, got, want)
+		}
+	}
+	if decoded.Count() != 5 || decoded.Min() != 0 || decoded.Max() != 2*time.Second || decoded.Mean() != h.Mean() {
+		t.Errorf("decoded histogram lost its summary: %s", data)
+	}
+}
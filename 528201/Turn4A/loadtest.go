@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sendRequest performs one GET. It returns the status (0 without a response)
+// and the number of body bytes read.
+func sendRequest(ctx context.Context, client *http.Client, url string) (int, int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	status, n, _, err := send(client, req, false)
+	return status, n, err
+}
+
+// send performs req and drains the response body so the connection can be
+// reused. The body is only kept, and returned, when keepBody is set.
+func send(client *http.Client, req *http.Request, keepBody bool) (status int, n int64, body []byte, err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer resp.Body.Close()
+	if keepBody {
+		body, err = io.ReadAll(resp.Body)
+		return resp.StatusCode, int64(len(body)), body, err
+	}
+	n, err = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, n, nil, err
+}
+
+// stressTest runs closed-loop tests at increasing concurrency levels, looking
+// for the saturation point as each completes. It stops early once a step
+// breaks the SLO. If live is not nil, it is handed each step as it starts; see runStep.
+func stressTest(ctx context.Context, client *http.Client, workload Workload, maxConcurrency int, step int, duration time.Duration, slo SLO,
+	live func(snapshot func() Metrics)) ([]Metrics, SaturationSummary) {
+	var results []Metrics
+	detector := newSaturationDetector(slo)
+	for concurrency := step; concurrency <= maxConcurrency && ctx.Err() == nil; concurrency += step {
+		log.Printf("Starting stress test with concurrency: %d", concurrency)
+		metrics := runStep(ctx, client, workload, concurrency, duration, live)
+		results = append(results, metrics)
+		if detector.observe(metrics) {
+			log.Printf("Stopping: %s", detector.reason)
+			break
+		}
+	}
+	return results, detector.summary()
+}
+
+// runTest is the closed-loop mode: each worker is a virtual user of workload
+// that starts its next iteration as soon as the previous one returns. A slow
+// server therefore slows the senders down, so its latency percentiles
+// understate what users arriving at a fixed rate would see; use runOpenLoop for that.
+func runTest(ctx context.Context, client *http.Client, workload Workload, concurrency int, duration time.Duration) Metrics {
+	return runStep(ctx, client, workload, concurrency, duration, nil)
+}
+
+// runStep is runTest that, if live is not nil, first passes it a function
+// returning the step's metrics so far, for live monitoring.
+func runStep(ctx context.Context, client *http.Client, workload Workload, concurrency int, duration time.Duration,
+	live func(snapshot func() Metrics)) Metrics {
+	rec := newRecorder()
+	sampler := startResourceSampler(resourceSampleInterval)
+	startTime := time.Now()
+	snapshot := func() Metrics {
+		m := Metrics{Mode: "closed", Concurrency: concurrency}
+		rec.fill(&m, time.Since(startTime))
+		sampler.fillAll(&m)
+		return m
+	}
+	if live != nil {
+		live(snapshot)
+	}
+	closedLoop(ctx, client, workload, concurrency, duration, rec)
+	elapsed := time.Since(startTime)
+	sampler.stop()
+	metrics := Metrics{Mode: "closed", Concurrency: concurrency}
+	rec.fill(&metrics, elapsed)
+	sampler.fillAll(&metrics)
+	return metrics
+}
+
+// closedLoop runs the virtual users of runTest, recording into rec, which may
+// be sampled while the test is running.
+func closedLoop(ctx context.Context, client *http.Client, workload Workload, concurrency int, duration time.Duration, rec *recorder) {
+	var wg sync.WaitGroup
+	startTime := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		user := workload.NewUser(i)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && time.Since(startTime) <= duration {
+				user.Iterate(ctx, client, rec)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Stage is one segment of an open-loop load profile. The arrival rate moves
+// linearly from the previous stage's target (zero before the first stage) to
+// TargetRPS over Duration, so a stage with the same target as the one before
+// holds the rate steady.
+type Stage struct {
+	Duration  time.Duration `json:"duration"`
+	TargetRPS float64       `json:"target_rps"`
+}
+
+// rampProfile is the usual ramp-up, hold, ramp-down profile peaking at rps.
+func rampProfile(rps float64, rampUp, hold, rampDown time.Duration) []Stage {
+	var stages []Stage
+	for _, s := range []Stage{{rampUp, rps}, {hold, rps}, {rampDown, 0}} {
+		if s.Duration > 0 {
+			stages = append(stages, s)
+		}
+	}
+	return stages
+}
+
+// arrivalSchedule yields the intended send times of a staged profile. The
+// n-th request is due when the integral of the arrival rate reaches n, so the
+// schedule follows the profile exactly no matter how the server responds.
+type arrivalSchedule struct {
+	stages     []Stage
+	stage      int
+	fromRPS    float64       // Rate at the start of the current stage
+	stageStart time.Duration // Offset of the current stage from the start of the test
+	before     float64       // Arrivals due before the current stage
+	n          int           // Requests scheduled so far
+}
+
+func newArrivalSchedule(stages []Stage) *arrivalSchedule {
+	return &arrivalSchedule{stages: stages}
+}
+
+// next returns the offset from the start of the test at which the next
+// request is due and the stage it belongs to, or ok=false once the profile ends.
+func (s *arrivalSchedule) next() (offset time.Duration, stage int, ok bool) {
+	s.n++
+	for s.stage < len(s.stages) {
+		st := s.stages[s.stage]
+		a, b, d := s.fromRPS, st.TargetRPS, st.Duration.Seconds()
+		if k := float64(s.n) - s.before; k <= (a+b)/2*d {
+			// Solve a*u + (b-a)/(2d)*u^2 = k for u, in a form that stays
+			// stable when the rate is constant or falling.
+			c := (b - a) / (2 * d)
+			u := 2 * k / (a + math.Sqrt(max(a*a+4*c*k, 0)))
+			return s.stageStart + time.Duration(u*float64(time.Second)), s.stage, true
+		}
+		s.before += (a + b) / 2 * d
+		s.stageStart += st.Duration
+		s.fromRPS = b
+		s.stage++
+	}
+	return 0, 0, false
+}
+
+// runOpenLoop is the open-loop mode: requests are sent on the schedule given
+// by stages whether or not earlier ones have returned, and latency is measured
+// from when each request was due rather than when it was actually sent. That
+// keeps a stalled server from hiding its own delay (coordinated omission).
+// maxInFlight caps concurrent requests; once reached, sends fall behind
+// schedule and that lag shows up in the latencies. It returns one Metrics per stage.
+func runOpenLoop(ctx context.Context, client *http.Client, url string, stages []Stage, maxInFlight int) []Metrics {
+	return openLoop(ctx, client, url, stages, maxInFlight, nil)
+}
+
+// openLoop is runOpenLoop that also records every request, across all
+// stages, in total when it is not nil, so progress can be sampled mid-test.
+func openLoop(ctx context.Context, client *http.Client, url string, stages []Stage, maxInFlight int, total *recorder) []Metrics {
+	recorders := make([]*recorder, len(stages))
+	for i := range recorders {
+		recorders[i] = newRecorder()
+	}
+	slots := make(chan struct{}, maxInFlight)
+	schedule := newArrivalSchedule(stages)
+	var wg sync.WaitGroup
+	sampler := startResourceSampler(resourceSampleInterval)
+	startTime := time.Now()
+
+dispatch:
+	for {
+		offset, stage, ok := schedule.next()
+		if !ok {
+			break
+		}
+		due := startTime.Add(offset)
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			break dispatch
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break dispatch
+		}
+
+		recs := recorderSet{recorders[stage]}
+		if total != nil {
+			recs = append(recs, total)
+		}
+		recs.start()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, n, err := sendRequest(withConnObserver(ctx, recs), client, url)
+			recs.done(time.Since(due), status, n, err)
+			<-slots
+		}()
+	}
+	wg.Wait()
+	sampler.stop()
+
+	// Resource samples go to the stage during which they were taken; the
+	// last stage also gets any taken while its requests were finishing.
+	results := make([]Metrics, len(stages))
+	var from time.Duration
+	for i, st := range stages {
+		results[i] = Metrics{Mode: "open", TargetRPS: st.TargetRPS}
+		recorders[i].fill(&results[i], st.Duration)
+		to := from + st.Duration
+		if i == len(stages)-1 {
+			to = math.MaxInt64
+		}
+		sampler.fill(&results[i], from, to)
+		from = to
+	}
+	return results
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArrivalScheduleFollowsStages(t *testing.T) {
+	stages := rampProfile(100, 2*time.Second, time.Second, 2*time.Second)
+	schedule := newArrivalSchedule(stages)
+
+	perStage := make([]int, len(stages))
+	var last time.Duration
+	for {
+		offset, stage, ok := schedule.next()
+		if !ok {
+			break
+		}
+		if offset < last {
+			t.Fatalf("schedule went backwards: %s after %s", offset, last)
+		}
+		last = offset
+		perStage[stage]++
+	}
+
+	// Ramping 0->100/s over 2s and back down each average 50/s; holding is 100/s.
+	want := []int{100, 100, 100}
+	for i := range want {
+		if perStage[i] != want[i] {
+			t.Errorf("stage %d: expected %d arrivals, got %d", i, want[i], perStage[i])
+		}
+	}
+	if last > 5*time.Second {
+		t.Errorf("expected the last arrival within the 5s profile, got %s", last)
+	}
+}
+
+func TestArrivalScheduleConstantRateIsEvenlySpaced(t *testing.T) {
+	schedule := newArrivalSchedule([]Stage{{Duration: time.Second, TargetRPS: 10}, {Duration: time.Second, TargetRPS: 10}})
+	// The first stage ramps up from zero; the second holds 10/s.
+	var offsets []time.Duration
+	for {
+		o, stage, ok := schedule.next()
+		if !ok {
+			break
+		}
+		if stage == 1 {
+			offsets = append(offsets, o)
+		}
+	}
+	if len(offsets) != 10 {
+		t.Fatalf("expected 10 arrivals while holding 10/s, got %d", len(offsets))
+	}
+	for i := 1; i < len(offsets); i++ {
+		if gap := offsets[i] - offsets[i-1]; gap < 99*time.Millisecond || gap > 101*time.Millisecond {
+			t.Errorf("expected 100ms between arrivals, got %s", gap)
+		}
+	}
+}
+
+// TestOpenLoopMeasuresFromIntendedSendTime stalls the server once. A closed
+// loop would simply stop sending during the stall; the open loop keeps
+// scheduling requests, and their latencies include the time spent waiting.
+func TestOpenLoopMeasuresFromIntendedSendTime(t *testing.T) {
+	var once sync.Once
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { time.Sleep(300 * time.Millisecond) })
+	}))
+	defer srv.Close()
+
+	stages := []Stage{{Duration: 0, TargetRPS: 100}, {Duration: 500 * time.Millisecond, TargetRPS: 100}}
+	results := runOpenLoop(context.Background(), srv.Client(), srv.URL, stages, 1)
+
+	hold := results[1]
+	if hold.Mode != "open" || hold.TotalRequests != 50 || hold.FailedRequests != 0 {
+		t.Fatalf("expected 50 successful requests, got %+v", hold)
+	}
+	// With one request in flight at a time, everything due during the stall
+	// queues behind it, so well over 10% of requests see at least 100ms.
+	if hold.LatencyP90 < 100*time.Millisecond || hold.LatencyMax < 300*time.Millisecond {
+		t.Errorf("expected the stall to show in p90 and max, got p90 %s, max %s", hold.LatencyP90, hold.LatencyMax)
+	}
+	if hold.Concurrency != 1 {
+		t.Errorf("expected peak concurrency capped at 1, got %d", hold.Concurrency)
+	}
+	if len(results[0].Resources) != 0 || len(hold.Resources) == 0 {
+		t.Errorf("expected resource samples only in the stage that ran, got %d and %d",
+			len(results[0].Resources), len(hold.Resources))
+	}
+}
+
+func TestRunTestClosedLoop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	m := runTest(context.Background(), srv.Client(), urlWorkload(srv.URL), 4, 100*time.Millisecond)
+	if m.Mode != "closed" || m.Concurrency != 4 || m.TotalRequests == 0 || m.FailedRequests != 0 {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+	if m.LatencyP50 <= 0 || m.LatencyP50 > m.LatencyP99 || m.AchievedRPS <= 0 {
+		t.Errorf("expected ordered latency percentiles and a throughput, got %+v", m)
+	}
+	if len(m.Resources) == 0 || m.Goroutines < 4 {
+		t.Errorf("expected resource samples covering the workers, got %+v", m.Resources)
+	}
+
+	m = runTest(context.Background(), srv.Client(), urlWorkload(srv.URL+"?fail=1"), 1, 20*time.Millisecond)
+	if m.ErrorRate != 100 {
+		t.Errorf("expected every request to fail, got error rate %.1f", m.ErrorRate)
+	}
+}
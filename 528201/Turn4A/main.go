@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		runReport(os.Args[2:])
+		return
+	}
+
+	role := flag.String("role", "standalone", `"standalone" runs the test itself; "coordinator" runs it across agents; "agent" runs the coordinator's tests`)
+	url := flag.String("url", "http://your-api-endpoint.com/resource", "Endpoint under test")
+	mode := flag.String("mode", "closed", `"closed" steps up concurrency; "open" sends at a fixed arrival rate`)
+	scenario := flag.String("scenario", "", "YAML scenario file to run instead of a GET of -url in closed-loop mode")
+	out := flag.String("out", "stress_test_results.json", "Where to save the results")
+
+	// Closed loop
+	maxConcurrency := flag.Int("max-concurrency", 500, "Maximum concurrency to test")
+	step := flag.Int("step", 50, "Increment step for concurrency")
+	duration := flag.Duration("duration", 15*time.Second, "Duration for each concurrency level")
+	sloP99 := flag.Duration("slo-p99", time.Second, "Stop stepping up once p99 latency exceeds this (0 disables)")
+	sloErrorRate := flag.Float64("slo-error-rate", 1, "Stop stepping up once the error rate exceeds this percentage (0 disables)")
+	showDashboard := flag.Bool("dashboard", true, "Show a live dashboard of the running step (plain lines when stdout is not a terminal)")
+
+	// Open loop
+	rps := flag.Float64("rps", 200, "Peak arrival rate in requests per second")
+	rampUp := flag.Duration("ramp-up", 10*time.Second, "Time to ramp up to the peak rate")
+	hold := flag.Duration("hold", 30*time.Second, "Time to hold the peak rate")
+	rampDown := flag.Duration("ramp-down", 10*time.Second, "Time to ramp back down to zero")
+	maxInFlight := flag.Int("max-in-flight", 1000, "Cap on concurrent requests in open-loop mode")
+
+	// Client
+	profile := defaultClientProfile
+	flag.StringVar(&profile.Protocol, "protocol", profile.Protocol, `"http1", "h2c" (HTTP/2 without TLS), or "h2" (HTTP/2 over TLS)`)
+	flag.BoolVar(&profile.KeepAlive, "keep-alive", profile.KeepAlive, "Reuse connections between requests")
+	flag.IntVar(&profile.MaxIdleConnsPerHost, "max-idle-per-host", profile.MaxIdleConnsPerHost, "Idle connections kept per host")
+	flag.IntVar(&profile.MaxConnsPerHost, "max-conns-per-host", profile.MaxConnsPerHost, "Cap on connections per host (0 for none)")
+	flag.DurationVar(&profile.DialTimeout, "dial-timeout", profile.DialTimeout, "Timeout for establishing a TCP connection")
+	flag.DurationVar(&profile.TLSHandshakeTimeout, "tls-timeout", profile.TLSHandshakeTimeout, "Timeout for the TLS handshake")
+	flag.DurationVar(&profile.ResponseHeaderTimeout, "header-timeout", profile.ResponseHeaderTimeout, "Timeout waiting for response headers (0 for none)")
+	flag.DurationVar(&profile.RequestTimeout, "request-timeout", profile.RequestTimeout, "Timeout for a whole request")
+	flag.BoolVar(&profile.InsecureSkipVerify, "insecure", profile.InsecureSkipVerify, "Skip TLS certificate verification")
+	flag.BoolVar(&profile.TraceConnections, "trace-conns", profile.TraceConnections, "Trace connection reuse and setup time per request")
+
+	// Distributed
+	listen := flag.String("listen", ":8080", "Coordinator: address to serve agents on")
+	agents := flag.Int("agents", 1, "Coordinator: number of agents to wait for before starting")
+	concurrency := flag.Int("concurrency", 50, "Coordinator: closed-loop workers per agent")
+	transport := flag.String("transport", "http", `How agents and the coordinator talk: "http" or "rpc" (net/rpc)`)
+	coordinator := flag.String("coordinator", "http://localhost:8080", "Agent: coordinator URL, or host:port with -transport rpc")
+	name, _ := os.Hostname()
+	flag.StringVar(&name, "name", name, "Agent: name to register under")
+	flag.Parse()
+	switch {
+	case *step <= 0:
+		log.Fatalf("-step must be positive, got %d", *step)
+	case *maxConcurrency < *step:
+		log.Fatalf("-max-concurrency (%d) must be at least -step (%d)", *maxConcurrency, *step)
+	case *maxInFlight <= 0:
+		log.Fatalf("-max-in-flight must be positive, got %d", *maxInFlight)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	client, err := profile.NewClient()
+	if err != nil {
+		log.Fatalf("Invalid client profile: %v", err)
+	}
+	log.Printf("Client profile: %+v", profile)
+
+	switch *role {
+	case "standalone":
+	case "coordinator":
+		plan := TestPlan{Mode: *mode, URL: *url, ScenarioFile: *scenario, Concurrency: *concurrency, Duration: *duration,
+			MaxInFlight: *maxInFlight, Client: &profile}
+		if *mode == "open" {
+			plan.Stages = rampProfile(*rps, *rampUp, *hold, *rampDown)
+		}
+		runCoordinator(ctx, *transport, *listen, *agents, plan, *out)
+		return
+	case "agent":
+		agent := &Agent{Name: name, Client: client}
+		switch *transport {
+		case "http":
+			agent.API = newHTTPCoordinator(*coordinator, &http.Client{Timeout: 10 * time.Second})
+		case "rpc":
+			conn, err := rpc.Dial("tcp", *coordinator)
+			if err != nil {
+				log.Fatalf("Failed to reach the coordinator: %v", err)
+			}
+			defer conn.Close()
+			agent.API = newRPCCoordinator(conn)
+		default:
+			log.Fatalf("Unknown transport %q", *transport)
+		}
+		m, err := agent.Run(ctx)
+		if err != nil {
+			log.Fatalf("Agent failed: %v", err)
+		}
+		log.Printf("Agent done: %d requests, %.2f%% failed", m.TotalRequests, m.ErrorRate)
+		return
+	default:
+		log.Fatalf("Unknown role %q", *role)
+	}
+
+	// Run the stress tests
+	var results []Metrics
+	var summary *SaturationSummary
+	switch *mode {
+	case "closed":
+		var workload Workload = urlWorkload(*url)
+		if *scenario != "" {
+			s, err := LoadScenario(*scenario)
+			if err != nil {
+				log.Fatalf("Failed to load scenario: %v", err)
+			}
+			log.Printf("Running scenario %q with %d steps", s.Name, len(s.Steps))
+			workload = s
+		}
+		var s SaturationSummary
+		var live func(snapshot func() Metrics)
+		stopDashboard := func() {}
+		if *showDashboard {
+			live, stopDashboard = startDashboard(ctx)
+		}
+		results, s = stressTest(ctx, client, workload, *maxConcurrency, *step, *duration, SLO{P99: *sloP99, ErrorRate: *sloErrorRate}, live)
+		stopDashboard()
+		summary = &s
+	case "open":
+		stages := rampProfile(*rps, *rampUp, *hold, *rampDown)
+		log.Printf("Starting open-loop test at up to %.0f requests/s over %d stages", *rps, len(stages))
+		results = runOpenLoop(ctx, client, *url, stages, *maxInFlight)
+	default:
+		log.Fatalf("Unknown mode %q", *mode)
+	}
+
+	for _, m := range results {
+		logMetrics(m)
+	}
+	if summary != nil {
+		log.Printf("Max sustainable concurrency %d at %.1f requests/s (p99 %s); %s",
+			summary.MaxSustainableConcurrency, summary.MaxSustainableRPS, summary.SustainableP99, summary.Reason)
+	}
+
+	// Save results to a file for visualization
+	saveMetricsToFile(Results{Client: &profile, Summary: summary, Results: results}, *out)
+
+	fmt.Printf("Stress test completed. Results saved to %s and %s\n", *out,
+		strings.TrimSuffix(*out, filepath.Ext(*out))+".csv")
+}
+
+func logMetrics(m Metrics) {
+	log.Printf("%s loop, concurrency %d: %d requests (%.1f/s), %.2f%% failed, p50 %s, p90 %s, p99 %s, p99.9 %s, statuses %v, errors %v, "+
+		"connections %d new (setup p50 %s, p99 %s) and %d reused",
+		m.Mode, m.Concurrency, m.TotalRequests, m.AchievedRPS, m.ErrorRate, m.LatencyP50, m.LatencyP90, m.LatencyP99, m.LatencyP999,
+		m.StatusCodes, m.Errors, m.ConnsOpened, m.ConnSetupP50, m.ConnSetupP99, m.ConnsReused)
+}
+
+// runCoordinator serves agents on addr, starts plan once n agents have
+// registered, and saves each agent's results followed by the merged total.
+// An interrupt stops the agents early and still collects what they measured.
+func runCoordinator(ctx context.Context, transport, addr string, n int, plan TestPlan, out string) {
+	c := NewCoordinator(defaultCoordinatorConfig)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Failed to listen on %s: %v", addr, err)
+	}
+	switch transport {
+	case "http":
+		server := &http.Server{Handler: c.Handler()}
+		go server.Serve(listener)
+		defer server.Close()
+	case "rpc":
+		go NewRPCServer(c).Accept(listener)
+		defer listener.Close()
+	default:
+		log.Fatalf("Unknown transport %q", transport)
+	}
+
+	log.Printf("Coordinator listening on %s, waiting for %d agents", addr, n)
+	if err := c.WaitForAgents(ctx, n); err != nil {
+		log.Fatalf("Gave up waiting for agents: %v", err)
+	}
+	if _, err := c.Start(plan); err != nil {
+		log.Fatalf("Failed to start the test: %v", err)
+	}
+
+	report := c.Wait(ctx)
+	if ctx.Err() != nil {
+		log.Printf("Interrupted, stopping agents")
+		c.Stop()
+		// Give the agents a chance to notice and send their final reports.
+		wait, cancel := context.WithTimeout(context.Background(), 2*defaultCoordinatorConfig.DeadAfter)
+		report = c.Wait(wait)
+		cancel()
+	}
+	if !report.Complete {
+		log.Printf("Not every agent finished; the total only includes what they reported")
+	}
+
+	var results []Metrics
+	for _, agent := range report.Agents {
+		log.Printf("Agent %s (%s): %s", agent.ID, agent.Name, agent.Status)
+		logMetrics(agent.Metrics)
+		results = append(results, agent.Metrics)
+	}
+	log.Printf("Total across %d agents:", len(report.Agents))
+	logMetrics(report.Total)
+	saveMetricsToFile(Results{Client: plan.Client, Results: append(results, report.Total)}, out)
+	fmt.Printf("Distributed stress test completed. Results saved to %s and %s\n", out,
+		strings.TrimSuffix(out, filepath.Ext(out))+".csv")
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Metrics struct {
+	Mode           string           `json:"mode"` // "closed" or "open" loop
+	TotalRequests  int              `json:"total_requests"`
+	FailedRequests int              `json:"failed_requests"`
+	ErrorRate      float64          `json:"error_rate"`
+	TargetRPS      float64          `json:"target_rps,omitempty"` // Open loop: arrival rate at the end of the stage
+	AchievedRPS    float64          `json:"achieved_rps"`
+	LatencyP50     time.Duration    `json:"latency_p50"`
+	LatencyP90     time.Duration    `json:"latency_p90"`
+	LatencyP99     time.Duration    `json:"latency_p99"`
+	LatencyP999    time.Duration    `json:"latency_p99_9"`
+	LatencyMax     time.Duration    `json:"latency_max"`
+	Latency        *Histogram       `json:"latency_histogram"` // Mergeable across stages, runs, and agents
+	StatusCodes    map[int]int      `json:"status_codes"`      // Responses by HTTP status
+	Errors         map[string]int   `json:"errors"`            // Transport errors by class
+	BytesReceived  int64            `json:"bytes_received"`    // Response body bytes
+	ConnsOpened    int              `json:"conns_opened"`      // Traced requests that had to open a connection
+	ConnsReused    int              `json:"conns_reused"`      // Traced requests that reused one
+	ConnSetupP50   time.Duration    `json:"conn_setup_p50"`    // DNS, connect, and TLS time of new connections
+	ConnSetupP99   time.Duration    `json:"conn_setup_p99"`
+	ConnSetup      *Histogram       `json:"conn_setup_histogram,omitempty"`
+	CPUUsage       float64          `json:"cpu_usage"`           // Tester's average CPU percent; 100 is one core
+	MemoryUsage    uint64           `json:"memory_usage"`        // Tester's peak RSS in bytes
+	Goroutines     int              `json:"goroutines"`          // Tester's peak goroutine count
+	Resources      []ResourceSample `json:"resources,omitempty"` // Tester's resource usage over time
+	Concurrency    int              `json:"concurrency"`         // Closed loop: workers; open loop: peak requests in flight
+	ElapsedTime    time.Duration    `json:"elapsed_time"`
+}
+
+// recorder collects the outcome of requests made by many goroutines.
+type recorder struct {
+	mu       sync.Mutex
+	total    int
+	failed   int
+	inFlight int
+	peak     int
+	bytes    int64
+	latency  *Histogram
+	statuses map[int]int
+	errors   map[string]int
+	opened   int
+	reused   int
+	setup    *Histogram
+}
+
+func newRecorder() *recorder {
+	return &recorder{latency: NewHistogram(), statuses: make(map[int]int), errors: make(map[string]int), setup: NewHistogram()}
+}
+
+// start marks a request as in flight.
+func (r *recorder) start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight++
+	r.peak = max(r.peak, r.inFlight)
+}
+
+// done records a finished request; anything but a 200 counts as a failure.
+// A response cut off mid-body counts under both its status and its error.
+func (r *recorder) done(latency time.Duration, status int, bytes int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.inFlight--
+	r.total++
+	if err != nil || status != 200 {
+		r.failed++
+	}
+	if status != 0 {
+		r.statuses[status]++
+	}
+	if err != nil {
+		r.errors[classifyError(err)]++
+	}
+	r.bytes += bytes
+	r.latency.Record(latency)
+}
+
+// observeConn records how a traced request got its connection.
+func (r *recorder) observeConn(reused bool, setup time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if reused {
+		r.reused++
+		return
+	}
+	r.opened++
+	r.setup.Record(setup)
+}
+
+// recorderSet records every request in each of its recorders.
+type recorderSet []*recorder
+
+func (rs recorderSet) start() {
+	for _, r := range rs {
+		r.start()
+	}
+}
+
+func (rs recorderSet) done(latency time.Duration, status int, bytes int64, err error) {
+	for _, r := range rs {
+		r.done(latency, status, bytes, err)
+	}
+}
+
+func (rs recorderSet) observeConn(reused bool, setup time.Duration) {
+	for _, r := range rs {
+		r.observeConn(reused, setup)
+	}
+}
+
+// fill copies the request counts and latency percentiles into m, and raises
+// m.Concurrency to the peak number of requests in flight.
+func (r *recorder) fill(m *Metrics, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m.TotalRequests = r.total
+	m.FailedRequests = r.failed
+	if r.total > 0 {
+		m.ErrorRate = float64(r.failed) / float64(r.total) * 100
+	}
+	if elapsed > 0 {
+		m.AchievedRPS = float64(r.total) / elapsed.Seconds()
+	}
+	m.LatencyP50 = r.latency.Percentile(50)
+	m.LatencyP90 = r.latency.Percentile(90)
+	m.LatencyP99 = r.latency.Percentile(99)
+	m.LatencyP999 = r.latency.Percentile(99.9)
+	m.LatencyMax = r.latency.Max()
+	m.Latency = NewHistogram()
+	m.Latency.Merge(r.latency)
+	m.StatusCodes = maps.Clone(r.statuses)
+	m.Errors = maps.Clone(r.errors)
+	m.BytesReceived = r.bytes
+	m.ConnsOpened = r.opened
+	m.ConnsReused = r.reused
+	m.ConnSetupP50 = r.setup.Percentile(50)
+	m.ConnSetupP99 = r.setup.Percentile(99)
+	if r.opened > 0 {
+		m.ConnSetup = NewHistogram()
+		m.ConnSetup.Merge(r.setup)
+	}
+	m.Concurrency = max(m.Concurrency, r.peak)
+	m.ElapsedTime = elapsed
+}
+
+// mergeMetrics combines results from separate runs of the same test, such as
+// one per agent. Counts and rates add up, and percentiles are recomputed from
+// the merged latency histograms rather than averaged.
+func mergeMetrics(parts []Metrics) Metrics {
+	total := Metrics{Latency: NewHistogram(), StatusCodes: map[int]int{}, Errors: map[string]int{}, ConnSetup: NewHistogram()}
+	for _, m := range parts {
+		if total.Mode == "" {
+			total.Mode = m.Mode
+		}
+		total.TotalRequests += m.TotalRequests
+		total.FailedRequests += m.FailedRequests
+		total.TargetRPS += m.TargetRPS
+		total.AchievedRPS += m.AchievedRPS
+		total.Latency.Merge(m.Latency)
+		for code, n := range m.StatusCodes {
+			total.StatusCodes[code] += n
+		}
+		for class, n := range m.Errors {
+			total.Errors[class] += n
+		}
+		total.BytesReceived += m.BytesReceived
+		total.ConnsOpened += m.ConnsOpened
+		total.ConnsReused += m.ConnsReused
+		total.ConnSetup.Merge(m.ConnSetup)
+		total.CPUUsage += m.CPUUsage
+		total.MemoryUsage += m.MemoryUsage
+		total.Goroutines += m.Goroutines
+		total.Concurrency += m.Concurrency
+		total.ElapsedTime = max(total.ElapsedTime, m.ElapsedTime)
+	}
+	if total.TotalRequests > 0 {
+		total.ErrorRate = float64(total.FailedRequests) / float64(total.TotalRequests) * 100
+	}
+	total.LatencyP50 = total.Latency.Percentile(50)
+	total.LatencyP90 = total.Latency.Percentile(90)
+	total.LatencyP99 = total.Latency.Percentile(99)
+	total.LatencyP999 = total.Latency.Percentile(99.9)
+	total.LatencyMax = total.Latency.Max()
+	total.ConnSetupP50 = total.ConnSetup.Percentile(50)
+	total.ConnSetupP99 = total.ConnSetup.Percentile(99)
+	return total
+}
+
+// Results is the layout of the JSON results file.
+type Results struct {
+	Client  *ClientProfile     `json:"client,omitempty"`  // How the load generator connected
+	Summary *SaturationSummary `json:"summary,omitempty"` // Stepped closed-loop tests only
+	Results []Metrics          `json:"results"`
+}
+
+// saveMetricsToFile writes results to filename as JSON and, next to it with
+// a .csv extension, as one CSV row per test.
+func saveMetricsToFile(results Results, filename string) {
+	if err := writeFile(filename, func(w io.Writer) error { return writeMetricsJSON(w, results) }); err != nil {
+		log.Fatalf("Failed to write metrics to file: %v", err)
+	}
+	csvName := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".csv"
+	if err := writeFile(csvName, func(w io.Writer) error { return writeMetricsCSV(w, results.Results) }); err != nil {
+		log.Fatalf("Failed to write metrics to file: %v", err)
+	}
+}
+
+func writeFile(filename string, write func(io.Writer) error) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeMetricsJSON(w io.Writer, results Results) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+// writeMetricsCSV writes one row per Metrics. Latencies are in milliseconds,
+// and every status code and error class seen in any row gets its own column.
+func writeMetricsCSV(w io.Writer, metrics []Metrics) error {
+	statusSet, errorSet := map[int]bool{}, map[string]bool{}
+	for _, m := range metrics {
+		for code := range m.StatusCodes {
+			statusSet[code] = true
+		}
+		for class := range m.Errors {
+			errorSet[class] = true
+		}
+	}
+	statuses := slices.Sorted(maps.Keys(statusSet))
+	classes := slices.Sorted(maps.Keys(errorSet))
+
+	header := []string{"mode", "concurrency", "target_rps", "achieved_rps", "total_requests", "failed_requests", "error_rate",
+		"latency_p50_ms", "latency_p90_ms", "latency_p99_ms", "latency_p99_9_ms", "latency_max_ms", "bytes_received",
+		"conns_opened", "conns_reused", "conn_setup_p50_ms", "conn_setup_p99_ms"}
+	for _, code := range statuses {
+		header = append(header, "status_"+strconv.Itoa(code))
+	}
+	for _, class := range classes {
+		header = append(header, "error_"+class)
+	}
+	header = append(header, "cpu_usage", "memory_usage", "goroutines", "elapsed_s")
+
+	cw := csv.NewWriter(w)
+	cw.Write(header)
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+	num := func(f float64) string { return strconv.FormatFloat(f, 'f', -1, 64) }
+	for _, m := range metrics {
+		row := []string{m.Mode, strconv.Itoa(m.Concurrency), num(m.TargetRPS), num(m.AchievedRPS),
+			strconv.Itoa(m.TotalRequests), strconv.Itoa(m.FailedRequests), num(m.ErrorRate),
+			ms(m.LatencyP50), ms(m.LatencyP90), ms(m.LatencyP99), ms(m.LatencyP999), ms(m.LatencyMax),
+			strconv.FormatInt(m.BytesReceived, 10),
+			strconv.Itoa(m.ConnsOpened), strconv.Itoa(m.ConnsReused), ms(m.ConnSetupP50), ms(m.ConnSetupP99)}
+		for _, code := range statuses {
+			row = append(row, strconv.Itoa(m.StatusCodes[code]))
+		}
+		for _, class := range classes {
+			row = append(row, strconv.Itoa(m.Errors[class]))
+		}
+		row = append(row, num(m.CPUUsage), strconv.FormatUint(m.MemoryUsage, 10), strconv.Itoa(m.Goroutines),
+			num(m.ElapsedTime.Seconds()))
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
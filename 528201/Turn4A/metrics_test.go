@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	wrap := func(err error) error { return &url.Error{Op: "Get", URL: "http://example.test", Err: err} }
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"DNS", wrap(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "example.test"}}), errDNS},
+		{"Refused", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), errConnect},
+		{"Deadline", wrap(context.DeadlineExceeded), errTimeout},
+		{"Canceled", wrap(context.Canceled), errCanceled},
+		{"TLS alert", wrap(tls.AlertError(40)), errTLS},
+		{"Reset", wrap(&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), errReset},
+		{"Closed mid-response", wrap(io.ErrUnexpectedEOF), errReset},
+		{"Unknown", wrap(fmt.Errorf("unsupported protocol scheme")), errOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestRecorderBreaksDownRealFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			io.WriteString(w, "hello")
+		}
+	}))
+	defer srv.Close()
+	tlsSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer tlsSrv.Close()
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedURL := "http://" + closed.Addr().String()
+	closed.Close()
+
+	rec := newRecorder()
+	for _, u := range []string{srv.URL, srv.URL, srv.URL + "/missing", srv.URL + "/slow", tlsSrv.URL, closedURL} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if strings.HasSuffix(u, "/slow") {
+			ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+		}
+		rec.start()
+		status, n, err := sendRequest(ctx, http.DefaultClient, u)
+		rec.done(time.Millisecond, status, n, err)
+		cancel()
+	}
+
+	var m Metrics
+	rec.fill(&m, time.Second)
+	if m.TotalRequests != 6 || m.FailedRequests != 4 {
+		t.Errorf("expected 6 requests with 4 failures, got %d and %d", m.TotalRequests, m.FailedRequests)
+	}
+	if m.StatusCodes[200] != 2 || m.StatusCodes[404] != 1 {
+		t.Errorf("unexpected status codes %v", m.StatusCodes)
+	}
+	if m.Errors[errTimeout] != 1 || m.Errors[errTLS] != 1 || m.Errors[errConnect] != 1 {
+		t.Errorf("unexpected error classes %v", m.Errors)
+	}
+	if want := int64(2*len("hello") + len("404 page not found\n")); m.BytesReceived != want {
+		t.Errorf("expected %d bytes received, got %d", want, m.BytesReceived)
+	}
+	if m.Latency.Count() != 6 {
+		t.Errorf("expected the latency histogram in the metrics, got %d samples", m.Latency.Count())
+	}
+}
+
+func TestSaveMetricsWritesJSONAndCSV(t *testing.T) {
+	first, second := newRecorder(), newRecorder()
+	first.start()
+	first.done(10*time.Millisecond, 200, 5, nil)
+	second.start()
+	second.done(30*time.Millisecond, 0, 0, context.DeadlineExceeded)
+	metrics := make([]Metrics, 2)
+	first.fill(&metrics[0], time.Second)
+	second.fill(&metrics[1], time.Second)
+	metrics[0].Mode, metrics[1].Mode = "closed", "closed"
+
+	path := filepath.Join(t.TempDir(), "results.json")
+	saveMetricsToFile(Results{Summary: &SaturationSummary{MaxSustainableConcurrency: 50}, Results: metrics}, path)
+
+	data, _ := os.ReadFile(path)
+	var decoded Results
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Summary == nil || decoded.Summary.MaxSustainableConcurrency != 50 {
+		t.Errorf("expected the summary in the JSON, got %+v", decoded.Summary)
+	}
+	merged := NewHistogram()
+	for _, m := range decoded.Results {
+		merged.Merge(m.Latency)
+	}
+	if merged.Count() != 2 || merged.Max() != 30*time.Millisecond || decoded.Results[1].Errors[errTimeout] != 1 {
+		t.Errorf("JSON lost detail: %s", data)
+	}
+
+	data, _ = os.ReadFile(strings.TrimSuffix(path, ".json") + ".csv")
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d", len(rows))
+	}
+	columns := map[string]int{}
+	for i, name := range rows[0] {
+		columns[name] = i
+	}
+	for name, want := range map[string][2]string{
+		"status_200":     {"1", "0"},
+		"error_timeout":  {"0", "1"},
+		"latency_p50_ms": {"10.000", "30.000"},
+		"bytes_received": {"5", "0"},
+	} {
+		i, ok := columns[name]
+		if !ok {
+			t.Errorf("missing column %s in %v", name, rows[0])
+			continue
+		}
+		if rows[1][i] != want[0] || rows[2][i] != want[1] {
+			t.Errorf("%s: expected %v, got [%s %s]", name, want, rows[1][i], rows[2][i])
+		}
+	}
+}
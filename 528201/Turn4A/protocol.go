@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The agent/coordinator protocol. An agent registers once, then heartbeats
+// every HeartbeatInterval. A heartbeat answer carries the agent's Assignment
+// once the coordinator starts a test; every agent begins at the same StartAt.
+// While the test runs the agent reports cumulative metrics every
+// ReportInterval, and once more with Final set when it is done. Agents that
+// stop heartbeating for DeadAfter are considered dead.
+
+// TestPlan describes the test the coordinator hands out.
+type TestPlan struct {
+	Mode         string         `json:"mode"` // "closed" or "open"
+	URL          string         `json:"url"`
+	ScenarioFile string         `json:"scenario_file,omitempty"` // Closed loop; must exist on every agent
+	Concurrency  int            `json:"concurrency,omitempty"`   // Closed loop: workers per agent
+	Duration     time.Duration  `json:"duration,omitempty"`      // Closed loop
+	Stages       []Stage        `json:"stages,omitempty"`        // Open loop: rates across all agents combined
+	MaxInFlight  int            `json:"max_in_flight,omitempty"` // Open loop: per agent
+	Client       *ClientProfile `json:"client,omitempty"`        // Overrides the agent's own client
+}
+
+type RegisterRequest struct {
+	Name string `json:"name"` // Free-form label, such as the agent's hostname
+}
+
+type RegisterResponse struct {
+	AgentID           string        `json:"agent_id"`
+	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+	ReportInterval    time.Duration `json:"report_interval"`
+}
+
+type Heartbeat struct {
+	AgentID string `json:"agent_id"`
+}
+
+type HeartbeatResponse struct {
+	Assignment *Assignment `json:"assignment,omitempty"`
+	Stop       bool        `json:"stop,omitempty"` // The coordinator aborted the test
+}
+
+// Assignment is one agent's share of a TestPlan.
+type Assignment struct {
+	Plan    TestPlan  `json:"plan"`
+	StartAt time.Time `json:"start_at"` // Wall-clock start shared by every agent, so clocks should be synced
+}
+
+// MetricsReport carries everything an agent has measured so far. Reports are
+// cumulative, so a lost one costs nothing but freshness.
+type MetricsReport struct {
+	AgentID string  `json:"agent_id"`
+	Seq     int     `json:"seq"`
+	Final   bool    `json:"final"`
+	Metrics Metrics `json:"metrics"`
+}
+
+// coordinatorAPI is what an agent needs from its coordinator. *Coordinator
+// implements it directly, and httpCoordinator and rpcCoordinator over the network.
+type coordinatorAPI interface {
+	Register(ctx context.Context, req RegisterRequest) (RegisterResponse, error)
+	Heartbeat(ctx context.Context, hb Heartbeat) (HeartbeatResponse, error)
+	ReportMetrics(ctx context.Context, report MetricsReport) error
+}
+
+// httpCoordinator talks to a coordinator's HTTP endpoints.
+type httpCoordinator struct {
+	baseURL string
+	client  *http.Client
+}
+
+func newHTTPCoordinator(baseURL string, client *http.Client) *httpCoordinator {
+	return &httpCoordinator{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+func (c *httpCoordinator) Register(ctx context.Context, req RegisterRequest) (RegisterResponse, error) {
+	var resp RegisterResponse
+	err := c.post(ctx, "/register", req, &resp)
+	return resp, err
+}
+
+func (c *httpCoordinator) Heartbeat(ctx context.Context, hb Heartbeat) (HeartbeatResponse, error) {
+	var resp HeartbeatResponse
+	err := c.post(ctx, "/heartbeat", hb, &resp)
+	return resp, err
+}
+
+func (c *httpCoordinator) ReportMetrics(ctx context.Context, report MetricsReport) error {
+	return c.post(ctx, "/metrics", report, nil)
+}
+
+func (c *httpCoordinator) post(ctx context.Context, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("%s: %s: %s", path, resp.Status, strings.TrimSpace(msg.String()))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runReport implements the report command: it reads results files and
+// writes a self-contained HTML report that charts and compares them.
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	out := fs.String("out", "report.html", "Where to write the HTML report")
+	title := fs.String("title", "Stress test report", "Report title")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s report [flags] results.json [other.json ...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var runs []reportRun
+	for _, path := range fs.Args() {
+		results, err := loadResults(path)
+		if err != nil {
+			log.Fatalf("Failed to read results: %v", err)
+		}
+		runs = append(runs, reportRun{Name: filepath.Base(path), Results: results})
+	}
+	if err := writeFile(*out, func(w io.Writer) error { return writeHTMLReport(w, *title, runs) }); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	fmt.Printf("Report for %d runs saved to %s\n", len(runs), *out)
+}
+
+// loadResults reads a results file written by saveMetricsToFile. Files from
+// before the summary was added hold a bare list of Metrics, which is accepted too.
+func loadResults(path string) (Results, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Results{}, err
+	}
+	var results Results
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(data, &results.Results)
+	} else {
+		err = json.Unmarshal(data, &results)
+	}
+	if err != nil {
+		return Results{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return results, nil
+}
+
+// reportRun is one results file in a report.
+type reportRun struct {
+	Name    string
+	Results Results
+}
+
+// runColors tells runs apart in every chart.
+var runColors = []string{"#1f77b4", "#d62728", "#2ca02c", "#9467bd", "#ff7f0e", "#8c564b"}
+
+func runColor(i int) string { return runColors[i%len(runColors)] }
+
+type point struct{ X, Y float64 }
+
+type series struct {
+	Name   string
+	Color  string
+	Dashed bool
+	Points []point
+}
+
+// lineChart is a chart of one or more series, rendered as inline SVG.
+type lineChart struct {
+	Title, XLabel, YLabel string
+	Series                []series
+}
+
+const (
+	chartWidth, chartHeight = 640, 300
+	marginLeft, marginRight = 64, 16
+	marginTop, marginBottom = 12, 44
+)
+
+// niceStep picks a round tick spacing (1, 2, or 5 times a power of ten)
+// giving about five ticks up to max.
+func niceStep(max float64) float64 {
+	raw := max / 5
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatTick(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// SVG renders the chart. Both axes start at zero.
+func (c lineChart) SVG() template.HTML {
+	maxX, maxY := 0.0, 0.0
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			maxX, maxY = max(maxX, p.X), max(maxY, p.Y)
+		}
+	}
+	if maxX <= 0 {
+		maxX = 1
+	}
+	if maxY <= 0 {
+		maxY = 1
+	}
+	xStep, yStep := niceStep(maxX), niceStep(maxY)
+	xTicks, yTicks := int(math.Ceil(maxX/xStep)), int(math.Ceil(maxY/yStep))
+	maxX, maxY = float64(xTicks)*xStep, float64(yTicks)*yStep
+
+	plotW := float64(chartWidth - marginLeft - marginRight)
+	plotH := float64(chartHeight - marginTop - marginBottom)
+	x := func(v float64) float64 { return marginLeft + v/maxX*plotW }
+	y := func(v float64) float64 { return marginTop + plotH - v/maxY*plotH }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg viewBox="0 0 %d %d" width="%d" height="%d" role="img" aria-label="%s">`,
+		chartWidth, chartHeight, chartWidth, chartHeight, html.EscapeString(c.Title))
+	for i := 0; i <= yTicks; i++ {
+		v := float64(i) * yStep
+		fmt.Fprintf(&b, `<line class="grid" x1="%d" x2="%d" y1="%.1f" y2="%.1f"/>`, marginLeft, chartWidth-marginRight, y(v), y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, marginLeft-6, y(v), formatTick(v))
+	}
+	for i := 0; i <= xTicks; i++ {
+		v := float64(i) * xStep
+		fmt.Fprintf(&b, `<line class="grid" x1="%.1f" x2="%.1f" y1="%d" y2="%.1f"/>`, x(v), x(v), marginTop, y(0))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x(v), y(0)+16, formatTick(v))
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, marginLeft+plotW/2, chartHeight-6, html.EscapeString(c.XLabel))
+	fmt.Fprintf(&b, `<text transform="translate(14 %.1f) rotate(-90)" text-anchor="middle">%s</text>`, marginTop+plotH/2, html.EscapeString(c.YLabel))
+
+	for _, s := range c.Series {
+		dash := ""
+		if s.Dashed {
+			dash = ` stroke-dasharray="6 4"`
+		}
+		var pts []string
+		for _, p := range s.Points {
+			pts = append(pts, fmt.Sprintf("%.1f,%.1f", x(p.X), y(p.Y)))
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="2"%s points="%s"/>`, s.Color, dash, strings.Join(pts, " "))
+		for _, p := range s.Points {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s: %s, %s</title></circle>`,
+				x(p.X), y(p.Y), s.Color, html.EscapeString(s.Name), formatTick(p.X), strconv.FormatFloat(p.Y, 'f', 2, 64))
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// chartsFor draws throughput, latency percentiles, and error rate for every
+// run, once against concurrency for closed-loop steps and once against time.
+func chartsFor(runs []reportRun) []lineChart {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	type figure struct {
+		title, label string
+		values       []func(Metrics) float64
+		names        []string
+	}
+	figures := []figure{
+		{"Throughput", "requests/s", []func(Metrics) float64{func(m Metrics) float64 { return m.AchievedRPS }}, []string{""}},
+		{"Latency percentiles", "ms", []func(Metrics) float64{
+			func(m Metrics) float64 { return ms(m.LatencyP50) },
+			func(m Metrics) float64 { return ms(m.LatencyP90) },
+			func(m Metrics) float64 { return ms(m.LatencyP99) },
+		}, []string{" p50", " p90", " p99"}},
+		{"Error rate", "%", []func(Metrics) float64{func(m Metrics) float64 { return m.ErrorRate }}, []string{""}},
+	}
+
+	closed := false
+	for _, run := range runs {
+		for _, m := range run.Results.Results {
+			closed = closed || m.Mode == "closed"
+		}
+	}
+	var charts []lineChart
+	for _, byConcurrency := range []bool{true, false} {
+		if byConcurrency && !closed {
+			continue
+		}
+		for _, f := range figures {
+			c := lineChart{Title: f.title + " by elapsed time", XLabel: "elapsed time (s)", YLabel: f.label}
+			if byConcurrency {
+				c.Title, c.XLabel = f.title+" by concurrency", "concurrency"
+			}
+			for i, run := range runs {
+				for j, value := range f.values {
+					s := series{Name: run.Name + f.names[j], Color: runColor(i), Dashed: j < len(f.values)-1}
+					var elapsed time.Duration
+					for _, m := range run.Results.Results {
+						elapsed += m.ElapsedTime
+						switch {
+						case !byConcurrency:
+							s.Points = append(s.Points, point{elapsed.Seconds(), value(m)})
+						case m.Mode == "closed":
+							s.Points = append(s.Points, point{float64(m.Concurrency), value(m)})
+						}
+					}
+					if len(s.Points) > 0 {
+						c.Series = append(c.Series, s)
+					}
+				}
+			}
+			charts = append(charts, c)
+		}
+	}
+	return charts
+}
+
+// comparisonRow is one key figure across runs. Change compares the second
+// run with the first and is only filled in when exactly two runs are compared.
+type comparisonRow struct {
+	Label  string
+	Values []string
+	Change string
+}
+
+// compare lists the key figures of every run side by side.
+func compare(runs []reportRun) []comparisonRow {
+	type keyFigure struct {
+		label  string
+		value  func(Results) (float64, bool)
+		format func(float64) string
+	}
+	steps := func(r Results, pick func(Metrics) float64, better func(a, b float64) bool) (float64, bool) {
+		best, ok := 0.0, false
+		for _, m := range r.Results {
+			if m.TotalRequests == 0 {
+				continue
+			}
+			if v := pick(m); !ok || better(v, best) {
+				best, ok = v, true
+			}
+		}
+		return best, ok
+	}
+	higher := func(a, b float64) bool { return a > b }
+	lower := func(a, b float64) bool { return a < b }
+	p99 := func(m Metrics) float64 { return float64(m.LatencyP99) }
+	count := func(v float64) string { return strconv.FormatFloat(v, 'f', 0, 64) }
+	rate := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) + "/s" }
+	duration := func(v float64) string { return time.Duration(v).Round(10 * time.Microsecond).String() }
+	percent := func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) + "%" }
+
+	figures := []keyFigure{
+		{"Steps", func(r Results) (float64, bool) { return float64(len(r.Results)), true }, count},
+		{"Requests", func(r Results) (float64, bool) {
+			total := 0
+			for _, m := range r.Results {
+				total += m.TotalRequests
+			}
+			return float64(total), true
+		}, count},
+		{"Peak throughput", func(r Results) (float64, bool) {
+			return steps(r, func(m Metrics) float64 { return m.AchievedRPS }, higher)
+		}, rate},
+		{"Best p99", func(r Results) (float64, bool) { return steps(r, p99, lower) }, duration},
+		{"Worst p99", func(r Results) (float64, bool) { return steps(r, p99, higher) }, duration},
+		{"Worst error rate", func(r Results) (float64, bool) {
+			return steps(r, func(m Metrics) float64 { return m.ErrorRate }, higher)
+		}, percent},
+		{"Max sustainable concurrency", func(r Results) (float64, bool) {
+			if r.Summary == nil {
+				return 0, false
+			}
+			return float64(r.Summary.MaxSustainableConcurrency), true
+		}, count},
+		{"Max sustainable throughput", func(r Results) (float64, bool) {
+			if r.Summary == nil {
+				return 0, false
+			}
+			return r.Summary.MaxSustainableRPS, true
+		}, rate},
+	}
+
+	var rows []comparisonRow
+	for _, f := range figures {
+		row := comparisonRow{Label: f.label}
+		var values []float64
+		var oks []bool
+		for _, run := range runs {
+			v, ok := f.value(run.Results)
+			values, oks = append(values, v), append(oks, ok)
+			if ok {
+				row.Values = append(row.Values, f.format(v))
+			} else {
+				row.Values = append(row.Values, "–")
+			}
+		}
+		if len(runs) == 2 && oks[0] && oks[1] && values[0] != 0 {
+			row.Change = fmt.Sprintf("%+.1f%%", (values[1]-values[0])/values[0]*100)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.charts { display: flex; flex-wrap: wrap; gap: 1em; }
+figure { margin: 0; }
+figcaption { font-weight: bold; margin-bottom: 4px; }
+svg text { font-size: 11px; fill: #444; }
+svg .grid { stroke: #e4e4e4; }
+.legend span { display: inline-block; margin-right: 1.5em; }
+.swatch { display: inline-block; width: 12px; height: 12px; margin-right: 4px; vertical-align: middle; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Generated {{.Generated}}.</p>
+<p class="legend">{{range .Runs}}<span><span class="swatch" style="background: {{.Color}}"></span>{{.Name}}</span>{{end}}</p>
+
+<h2>{{if eq (len .Runs) 1}}Summary{{else}}Comparison{{end}}</h2>
+<table>
+<tr><th></th>{{range .Runs}}<th>{{.Name}}</th>{{end}}{{if .ShowChange}}<th>Change</th>{{end}}</tr>
+{{range .Rows}}<tr><td>{{.Label}}</td>{{range .Values}}<td>{{.}}</td>{{end}}{{if $.ShowChange}}<td>{{.Change}}</td>{{end}}</tr>
+{{end}}</table>
+{{range $run := .Runs}}{{with $run.Results.Summary}}<p><strong>{{$run.Name}}</strong>: {{.Reason}}.</p>{{end}}{{end}}
+
+<h2>Charts</h2>
+<p>Dashed lines are p50 and p90; solid lines are p99.</p>
+<div class="charts">
+{{range .Charts}}<figure><figcaption>{{.Title}}</figcaption>{{.SVG}}</figure>
+{{end}}</div>
+
+{{range .Runs}}<h2>{{.Name}}</h2>
+<table>
+<tr><th>Mode</th><th>Concurrency</th><th>Target</th><th>Throughput</th><th>Requests</th><th>Error rate</th><th>p50</th><th>p90</th><th>p99</th><th>p99.9</th><th>Max</th><th>Tester CPU</th></tr>
+{{range .Results.Results}}<tr><td>{{.Mode}}</td><td>{{.Concurrency}}</td><td>{{printf "%.1f" .TargetRPS}}</td><td>{{printf "%.1f/s" .AchievedRPS}}</td><td>{{.TotalRequests}}</td><td>{{printf "%.2f%%" .ErrorRate}}</td><td>{{.LatencyP50}}</td><td>{{.LatencyP90}}</td><td>{{.LatencyP99}}</td><td>{{.LatencyP999}}</td><td>{{.LatencyMax}}</td><td>{{printf "%.0f%%" .CPUUsage}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type reportPage struct {
+	Title      string
+	Generated  string
+	Runs       []reportPageRun
+	Rows       []comparisonRow
+	ShowChange bool
+	Charts     []lineChart
+}
+
+type reportPageRun struct {
+	reportRun
+	Color string
+}
+
+// writeHTMLReport writes a single HTML page, with its charts as inline SVG
+// and no external resources, so it can be attached anywhere.
+func writeHTMLReport(w io.Writer, title string, runs []reportRun) error {
+	page := reportPage{
+		Title:      title,
+		Generated:  time.Now().Format("2006-01-02 15:04 MST"),
+		Rows:       compare(runs),
+		ShowChange: len(runs) == 2,
+		Charts:     chartsFor(runs),
+	}
+	for i, run := range runs {
+		page.Runs = append(page.Runs, reportPageRun{reportRun: run, Color: runColor(i)})
+	}
+	return reportTemplate.Execute(w, page)
+}
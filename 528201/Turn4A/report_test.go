@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func steppedResults(scale float64) []Metrics {
+	var steps []Metrics
+	for i, c := range []int{10, 20, 30} {
+		steps = append(steps, Metrics{
+			Mode: "closed", Concurrency: c, TotalRequests: 100 * (i + 1), AchievedRPS: scale * float64(100*(i+1)),
+			LatencyP50: time.Duration(i+1) * time.Millisecond, LatencyP90: time.Duration(i+2) * time.Millisecond,
+			LatencyP99: time.Duration(i+5) * time.Millisecond, ElapsedTime: time.Second,
+		})
+	}
+	return steps
+}
+
+func TestHTMLReportComparesTwoRuns(t *testing.T) {
+	dir := t.TempDir()
+	before, after := filepath.Join(dir, "before.json"), filepath.Join(dir, "after.json")
+	summary := &SaturationSummary{MaxSustainableConcurrency: 20, MaxSustainableRPS: 200, Reason: "no saturation"}
+	saveMetricsToFile(Results{Summary: summary, Results: steppedResults(1)}, before)
+	saveMetricsToFile(Results{Results: steppedResults(1.5)}, after)
+
+	var runs []reportRun
+	for _, path := range []string{before, after} {
+		results, err := loadResults(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, reportRun{Name: filepath.Base(path), Results: results})
+	}
+	var b strings.Builder
+	if err := writeHTMLReport(&b, "Release <1.2>", runs); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+
+	if n := strings.Count(page, "<svg"); n != 6 {
+		t.Errorf("expected 3 charts by concurrency and 3 by time, got %d", n)
+	}
+	for _, want := range []string{"Release &lt;1.2&gt;", "before.json", "after.json", "Change", "50.0%", "no saturation", "#d62728"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected the report to contain %q", want)
+		}
+	}
+	for _, external := range []string{"<script", "<link", "src=", "ZgotmplZ"} {
+		if strings.Contains(page, external) {
+			t.Errorf("expected a self-contained report, found %q", external)
+		}
+	}
+}
+
+func TestLoadResultsAcceptsBareList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.json")
+	data, _ := json.Marshal(steppedResults(1))
+	if err := os.WriteFile(path, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	results, err := loadResults(path)
+	if err != nil || len(results.Results) != 3 || results.Summary != nil {
+		t.Errorf("expected 3 steps and no summary, got %+v (%v)", results, err)
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	for max, want := range map[float64]float64{1: 0.2, 7: 2, 10: 2, 26: 10, 480: 100, 0.03: 0.01} {
+		if got := niceStep(max); got != want {
+			t.Errorf("niceStep(%g): expected %g, got %g", max, want, got)
+		}
+	}
+}
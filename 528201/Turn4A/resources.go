@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResourceSample is the tester's own resource usage at one point of a test.
+type ResourceSample struct {
+	Offset       time.Duration `json:"offset"`         // Since the sampler started
+	CPUTime      time.Duration `json:"cpu_time"`       // Process CPU time used since the sampler started
+	CPUPercent   float64       `json:"cpu_percent"`    // Since the previous sample; 100 is one core fully busy
+	RSS          uint64        `json:"rss"`            // Resident set size in bytes
+	HeapInUse    uint64        `json:"heap_in_use"`    // Bytes in in-use heap spans
+	GCPauseTotal time.Duration `json:"gc_pause_total"` // Stop-the-world pauses since the sampler started
+	NumGC        uint32        `json:"num_gc"`         // Collections since the sampler started
+	Goroutines   int           `json:"goroutines"`
+}
+
+// resourceSampleInterval is how often resources are sampled during a test.
+const resourceSampleInterval = time.Second
+
+// clockTicks is the unit of the CPU times in /proc/self/stat (USER_HZ),
+// which is 100 on every mainstream Linux platform.
+const clockTicks = 100
+
+// processUsage is a raw reading of the counters a ResourceSample is made from.
+type processUsage struct {
+	at    time.Time
+	cpu   time.Duration
+	rss   uint64
+	mem   runtime.MemStats
+	procs int
+}
+
+func readProcessUsage() processUsage {
+	u := processUsage{at: time.Now(), procs: runtime.NumGoroutine()}
+	// Without /proc, CPU and RSS stay zero; the runtime figures still work.
+	if data, err := os.ReadFile("/proc/self/stat"); err == nil {
+		u.cpu, u.rss, _ = parseProcStat(data)
+	}
+	runtime.ReadMemStats(&u.mem)
+	return u
+}
+
+// parseProcStat extracts the process's CPU time (user plus system) and
+// resident set size from the contents of /proc/<pid>/stat.
+func parseProcStat(data []byte) (cpu time.Duration, rss uint64, err error) {
+	// The command name is in parentheses and may itself contain spaces and
+	// parentheses, so the numbered fields start after the last ')'.
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return 0, 0, errors.New("malformed /proc stat: no command name")
+	}
+	// fields[0] is field 3 of proc(5); utime, stime, and rss are fields 14, 15, and 24.
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		return 0, 0, errors.New("malformed /proc stat: too few fields")
+	}
+	var vals [3]uint64
+	for i, f := range []int{11, 12, 21} {
+		if vals[i], err = strconv.ParseUint(fields[f], 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	cpu = time.Duration(vals[0]+vals[1]) * time.Second / clockTicks
+	return cpu, vals[2] * uint64(os.Getpagesize()), nil
+}
+
+// resourceSampler records a ResourceSample at a fixed interval in the
+// background, so a test's resource usage can be seen over time rather than
+// only at its end.
+type resourceSampler struct {
+	base processUsage // Reading when the sampler started
+	quit chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	samples []ResourceSample
+	last    processUsage
+}
+
+func startResourceSampler(interval time.Duration) *resourceSampler {
+	base := readProcessUsage()
+	s := &resourceSampler{base: base, last: base, quit: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.sample()
+			case <-s.quit:
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *resourceSampler) sample() {
+	u := readProcessUsage()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sample := ResourceSample{
+		Offset:       u.at.Sub(s.base.at),
+		CPUTime:      u.cpu - s.base.cpu,
+		RSS:          u.rss,
+		HeapInUse:    u.mem.HeapInuse,
+		GCPauseTotal: time.Duration(u.mem.PauseTotalNs - s.base.mem.PauseTotalNs),
+		NumGC:        u.mem.NumGC - s.base.mem.NumGC,
+		Goroutines:   u.procs,
+	}
+	if wall := u.at.Sub(s.last.at); wall > 0 {
+		sample.CPUPercent = float64(u.cpu-s.last.cpu) / float64(wall) * 100
+	}
+	s.samples = append(s.samples, sample)
+	s.last = u
+}
+
+// stop takes a last sample, so even a test shorter than the interval has
+// one, and stops the sampler.
+func (s *resourceSampler) stop() {
+	close(s.quit)
+	<-s.done
+	s.sample()
+}
+
+// fill attaches the samples taken between from and to (offsets from the
+// start of the sampler) to m, and summarizes them: CPUUsage is the average
+// CPU percent over that span, MemoryUsage the peak RSS (or heap in use where
+// RSS is unavailable), and Goroutines the peak goroutine count.
+func (s *resourceSampler) fill(m *Metrics, from, to time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var prev ResourceSample // The sampler's start if nothing precedes the span
+	m.Resources = nil
+	m.MemoryUsage, m.Goroutines = 0, 0
+	for _, sample := range s.samples {
+		if sample.Offset <= from {
+			prev = sample
+			continue
+		}
+		if sample.Offset > to {
+			break
+		}
+		m.Resources = append(m.Resources, sample)
+		m.MemoryUsage = max(m.MemoryUsage, sample.RSS)
+		if sample.RSS == 0 {
+			m.MemoryUsage = max(m.MemoryUsage, sample.HeapInUse)
+		}
+		m.Goroutines = max(m.Goroutines, sample.Goroutines)
+	}
+	m.CPUUsage = 0
+	if n := len(m.Resources); n > 0 {
+		last := m.Resources[n-1]
+		if wall := last.Offset - prev.Offset; wall > 0 {
+			m.CPUUsage = float64(last.CPUTime-prev.CPUTime) / float64(wall) * 100
+		}
+	}
+}
+
+// fillAll is fill over everything sampled so far.
+func (s *resourceSampler) fillAll(m *Metrics) {
+	s.fill(m, -1, math.MaxInt64)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// A command name with spaces and parentheses, 250+50 ticks of CPU, and 300 pages resident.
+	stat := "4242 (load (test) x) R 1 4242 4242 0 -1 4194304 100 0 0 0 250 50 0 0 20 0 9 0 123 456789 300 18446744073709551615"
+	cpu, rss, err := parseProcStat([]byte(stat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu != 3*time.Second || rss != 300*uint64(os.Getpagesize()) {
+		t.Errorf("expected 3s of CPU and 300 pages, got %s and %d bytes", cpu, rss)
+	}
+	for _, bad := range []string{"4242 load R 1", "4242 (load) R 1 2 3", "4242 (load) R 1 4242 4242 0 -1 4194304 100 0 0 0 x 50 0 0 20 0 9 0 123 456789 300"} {
+		if _, _, err := parseProcStat([]byte(bad)); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestResourceSamplerRecordsSeries(t *testing.T) {
+	s := startResourceSampler(10 * time.Millisecond)
+	deadline := time.Now().Add(120 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		// Keep a core busy so there is CPU time to measure.
+	}
+	s.stop()
+
+	var all Metrics
+	s.fillAll(&all)
+	if len(all.Resources) < 5 {
+		t.Fatalf("expected a sample every 10ms, got %d", len(all.Resources))
+	}
+	for i, sample := range all.Resources {
+		if i > 0 && sample.Offset <= all.Resources[i-1].Offset {
+			t.Errorf("sample %d: offsets out of order", i)
+		}
+		if sample.HeapInUse == 0 || sample.Goroutines == 0 {
+			t.Errorf("sample %d: expected heap and goroutine figures, got %+v", i, sample)
+		}
+	}
+	if runtime.GOOS == "linux" && (all.CPUUsage < 30 || all.MemoryUsage == 0) {
+		t.Errorf("expected a busy core and a resident set, got %.1f%% and %d bytes", all.CPUUsage, all.MemoryUsage)
+	}
+
+	// Splitting the run in two shares the samples out between the halves.
+	mid := all.Resources[len(all.Resources)/2].Offset
+	var first, second Metrics
+	s.fill(&first, -1, mid)
+	s.fill(&second, mid, all.Resources[len(all.Resources)-1].Offset)
+	if len(first.Resources)+len(second.Resources) != len(all.Resources) {
+		t.Errorf("expected %d samples split between the halves, got %d and %d",
+			len(all.Resources), len(first.Resources), len(second.Resources))
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/rpc"
+	"time"
+)
+
+// BenchmarkRPC serves a Coordinator over net/rpc as an alternative to its
+// HTTP handler. Agents use Register, Heartbeat, and ReportMetrics exactly as
+// over HTTP. QueryResults returns the merged results so far for live
+// dashboards. The Coordinator does its own locking, so any number of
+// connections may call in concurrently.
+//
+// StartTest is called on the coordinator, and doesn't push the plan to the
+// agents: they pull it, as the answer to their next heartbeat, so agents
+// need no listener of their own and start within a HeartbeatInterval.
+type BenchmarkRPC struct {
+	c *Coordinator
+}
+
+// rpcServiceName is the name BenchmarkRPC is registered under.
+const rpcServiceName = "BenchmarkRPC"
+
+// NewRPCServer returns a server with c registered as BenchmarkRPC; serve it
+// with Accept or ServeConn.
+func NewRPCServer(c *Coordinator) *rpc.Server {
+	server := rpc.NewServer()
+	server.RegisterName(rpcServiceName, &BenchmarkRPC{c: c})
+	return server
+}
+
+func (b *BenchmarkRPC) Register(req RegisterRequest, resp *RegisterResponse) (err error) {
+	*resp, err = b.c.Register(context.Background(), req)
+	return err
+}
+
+func (b *BenchmarkRPC) Heartbeat(hb Heartbeat, resp *HeartbeatResponse) (err error) {
+	*resp, err = b.c.Heartbeat(context.Background(), hb)
+	return err
+}
+
+func (b *BenchmarkRPC) ReportMetrics(report MetricsReport, reply *struct{}) error {
+	return b.c.ReportMetrics(context.Background(), report)
+}
+
+// StartTest assigns plan to every live agent and replies with the shared start time.
+func (b *BenchmarkRPC) StartTest(plan TestPlan, startAt *time.Time) (err error) {
+	*startAt, err = b.c.Start(plan)
+	return err
+}
+
+// QueryResults replies with the merged results so far.
+func (b *BenchmarkRPC) QueryResults(_ struct{}, report *Report) error {
+	*report = b.c.Report()
+	return nil
+}
+
+// rpcCoordinator is the agent side of BenchmarkRPC. It also exposes the
+// control calls, so a dashboard or script can drive a test over the same connection.
+type rpcCoordinator struct {
+	client *rpc.Client
+}
+
+func newRPCCoordinator(client *rpc.Client) *rpcCoordinator {
+	return &rpcCoordinator{client: client}
+}
+
+func (c *rpcCoordinator) Register(ctx context.Context, req RegisterRequest) (RegisterResponse, error) {
+	return rpcCall[RegisterResponse](ctx, c.client, "Register", req)
+}
+
+func (c *rpcCoordinator) Heartbeat(ctx context.Context, hb Heartbeat) (HeartbeatResponse, error) {
+	return rpcCall[HeartbeatResponse](ctx, c.client, "Heartbeat", hb)
+}
+
+func (c *rpcCoordinator) ReportMetrics(ctx context.Context, report MetricsReport) error {
+	_, err := rpcCall[struct{}](ctx, c.client, "ReportMetrics", report)
+	return err
+}
+
+func (c *rpcCoordinator) StartTest(ctx context.Context, plan TestPlan) (time.Time, error) {
+	return rpcCall[time.Time](ctx, c.client, "StartTest", plan)
+}
+
+func (c *rpcCoordinator) QueryResults(ctx context.Context) (Report, error) {
+	return rpcCall[Report](ctx, c.client, "QueryResults", struct{}{})
+}
+
+// rpcCall makes an RPC that gives up when ctx is done. net/rpc cannot cancel
+// a call in flight, so its reply is decoded into a value of its own and
+// dropped if it arrives too late.
+func rpcCall[Out any](ctx context.Context, client *rpc.Client, method string, args any) (Out, error) {
+	reply := new(Out)
+	call := client.Go(rpcServiceName+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return *reply, call.Error
+	case <-ctx.Done():
+		var zero Out
+		return zero, ctx.Err()
+	}
+}
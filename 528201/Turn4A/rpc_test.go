@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startRPCCoordinator serves a coordinator over net/rpc on a local port.
+func startRPCCoordinator(t *testing.T) (*Coordinator, string) {
+	t.Helper()
+	c := NewCoordinator(testCoordinatorConfig)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go NewRPCServer(c).Accept(listener)
+	t.Cleanup(func() { listener.Close() })
+	return c, listener.Addr().String()
+}
+
+func dialRPCCoordinator(t *testing.T, addr string) *rpcCoordinator {
+	t.Helper()
+	conn, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return newRPCCoordinator(conn)
+}
+
+func TestRPCCoordinatorWithAgents(t *testing.T) {
+	var hits atomic.Int64
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		time.Sleep(time.Millisecond)
+	}))
+	defer target.Close()
+
+	c, addr := startRPCCoordinator(t)
+	const agents = 4
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := range agents {
+		agent := &Agent{Name: "rpc", API: dialRPCCoordinator(t, addr), Client: target.Client()}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := agent.Run(ctx); err != nil {
+				t.Errorf("agent %d: %v", i, err)
+			}
+		}()
+	}
+	if err := c.WaitForAgents(ctx, agents); err != nil {
+		t.Fatal(err)
+	}
+
+	control := dialRPCCoordinator(t, addr)
+	plan := TestPlan{Mode: "open", URL: target.URL, Stages: []Stage{{400 * time.Millisecond, 400}}, MaxInFlight: 50}
+	startAt, err := control.StartTest(ctx, plan)
+	if err != nil || startAt.IsZero() {
+		t.Fatalf("expected a start time, got %s (%v)", startAt, err)
+	}
+	if _, err := control.StartTest(ctx, plan); err == nil {
+		t.Error("expected a second StartTest to fail")
+	}
+
+	// Poll like a dashboard until every agent has sent its final report.
+	sawProgress := false
+	var report Report
+	for {
+		if report, err = control.QueryResults(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if report.Complete {
+			break
+		}
+		sawProgress = sawProgress || report.Total.TotalRequests > 0
+		time.Sleep(10 * time.Millisecond)
+	}
+	wg.Wait()
+
+	if !sawProgress {
+		t.Error("expected QueryResults to show progress before the test finished")
+	}
+	total := report.Total
+	if len(report.Agents) != agents || int64(total.TotalRequests) != hits.Load() {
+		t.Errorf("expected %d agents and %d requests, got %d and %d", agents, hits.Load(), len(report.Agents), total.TotalRequests)
+	}
+	// 400 requests/s ramping up from zero over 400ms is 80 requests, split between the agents.
+	if total.TotalRequests < 70 || total.TotalRequests > 90 {
+		t.Errorf("expected about 80 requests in total, got %d", total.TotalRequests)
+	}
+	if total.Latency == nil || total.Latency.Count() != uint64(total.TotalRequests) {
+		t.Errorf("expected the merged histogram to survive the round trip, got %+v", total.Latency)
+	}
+}
+
+func TestRPCCoordinatorErrors(t *testing.T) {
+	_, addr := startRPCCoordinator(t)
+	control := dialRPCCoordinator(t, addr)
+	ctx := context.Background()
+	if _, err := control.StartTest(ctx, TestPlan{Mode: "closed"}); err == nil || err.Error() != ErrNoAgents.Error() {
+		t.Errorf("expected %v, got %v", ErrNoAgents, err)
+	}
+	if _, err := control.Heartbeat(ctx, Heartbeat{AgentID: "agent-1"}); err == nil {
+		t.Error("expected an unknown agent to be rejected")
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := control.QueryResults(canceled); err != context.Canceled {
+		t.Errorf("expected a canceled call, got %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLO is what a step must stay within to count as sustainable. Zero disables a limit.
+type SLO struct {
+	P99       time.Duration
+	ErrorRate float64 // Percent
+}
+
+// breach describes how m violates the SLO, or returns "" if it does not.
+func (s SLO) breach(m Metrics) string {
+	switch {
+	case s.P99 > 0 && m.LatencyP99 > s.P99:
+		return fmt.Sprintf("p99 %s exceeds the SLO of %s", m.LatencyP99, s.P99)
+	case s.ErrorRate > 0 && m.ErrorRate > s.ErrorRate:
+		return fmt.Sprintf("error rate %.2f%% exceeds the SLO of %.2f%%", m.ErrorRate, s.ErrorRate)
+	}
+	return ""
+}
+
+// Thresholds for calling a step past the knee: throughput grew by less than
+// kneeThroughputGain over the best step so far, while p99 grew by more than
+// kneeLatencyGrowth or the error rate rose by more than kneeErrorRise points.
+const (
+	kneeThroughputGain = 0.05
+	kneeLatencyGrowth  = 0.25
+	kneeErrorRise      = 1.0
+)
+
+// SaturationSummary is the outcome of a stepped test.
+type SaturationSummary struct {
+	MaxSustainableConcurrency int           `json:"max_sustainable_concurrency"`
+	MaxSustainableRPS         float64       `json:"max_sustainable_rps"`
+	SustainableP99            time.Duration `json:"sustainable_p99"`
+	KneeConcurrency           int           `json:"knee_concurrency,omitempty"` // Last step before throughput plateaued
+	KneeRPS                   float64       `json:"knee_rps,omitempty"`
+	BreachConcurrency         int           `json:"breach_concurrency,omitempty"` // Step that broke the SLO and ended the test
+	Reason                    string        `json:"reason"`
+}
+
+// saturationDetector analyzes the steps of a stepped test as they complete.
+// The sustainable point is the step with the highest throughput that met the
+// SLO, not counting steps past the knee, where extra concurrency only queues.
+type saturationDetector struct {
+	slo       SLO
+	best      *Metrics // Step with the highest throughput so far
+	knee      *Metrics
+	sustained *Metrics
+	breach    *Metrics
+	reason    string
+}
+
+func newSaturationDetector(slo SLO) *saturationDetector {
+	return &saturationDetector{slo: slo}
+}
+
+// observe records a completed step and reports whether the test should stop.
+func (d *saturationDetector) observe(m Metrics) (stop bool) {
+	if reason := d.slo.breach(m); reason != "" {
+		d.breach = &m
+		d.reason = fmt.Sprintf("concurrency %d: %s", m.Concurrency, reason)
+		return true
+	}
+	if d.knee == nil && d.best != nil && m.AchievedRPS < d.best.AchievedRPS*(1+kneeThroughputGain) &&
+		(m.LatencyP99 > time.Duration(float64(d.best.LatencyP99)*(1+kneeLatencyGrowth)) ||
+			m.ErrorRate > d.best.ErrorRate+kneeErrorRise) {
+		d.knee = d.best
+	}
+	if d.best == nil || m.AchievedRPS > d.best.AchievedRPS {
+		d.best = &m
+	}
+	if d.knee == nil && (d.sustained == nil || m.AchievedRPS > d.sustained.AchievedRPS) {
+		d.sustained = &m
+	}
+	return false
+}
+
+func (d *saturationDetector) summary() SaturationSummary {
+	var s SaturationSummary
+	if d.sustained != nil {
+		s.MaxSustainableConcurrency = d.sustained.Concurrency
+		s.MaxSustainableRPS = d.sustained.AchievedRPS
+		s.SustainableP99 = d.sustained.LatencyP99
+	}
+	if d.knee != nil {
+		s.KneeConcurrency = d.knee.Concurrency
+		s.KneeRPS = d.knee.AchievedRPS
+	}
+	if d.breach != nil {
+		s.BreachConcurrency = d.breach.Concurrency
+	}
+	switch {
+	case d.reason != "" && d.sustained == nil:
+		s.Reason = "the first step already broke the SLO: " + d.reason
+	case d.reason != "":
+		s.Reason = "stopped early at " + d.reason
+	case d.knee != nil:
+		s.Reason = fmt.Sprintf("throughput plateaued after concurrency %d while latency or errors climbed", d.knee.Concurrency)
+	case d.sustained != nil:
+		s.Reason = fmt.Sprintf("no saturation up to concurrency %d", d.best.Concurrency)
+	default:
+		s.Reason = "no steps completed"
+	}
+	return s
+}
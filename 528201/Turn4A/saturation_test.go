@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func step(concurrency int, rps float64, p99 time.Duration, errorRate float64) Metrics {
+	return Metrics{Concurrency: concurrency, AchievedRPS: rps, LatencyP99: p99, ErrorRate: errorRate}
+}
+
+func TestSaturationDetector(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name             string
+		steps            []Metrics
+		stopAfter        int // Steps observed before the detector asks to stop; 0 for never
+		sustainable      int
+		knee, breach     int
+		reasonContaining string
+	}{
+		{
+			name:             "Scales linearly",
+			steps:            []Metrics{step(10, 100, 10*ms, 0), step(20, 200, 10*ms, 0), step(30, 300, 11*ms, 0)},
+			sustainable:      30,
+			reasonContaining: "no saturation",
+		},
+		{
+			name: "Plateaus as latency climbs",
+			steps: []Metrics{step(10, 100, 10*ms, 0), step(20, 190, 11*ms, 0), step(30, 195, 16*ms, 0),
+				step(40, 200, 21*ms, 0)},
+			sustainable:      20,
+			knee:             20,
+			reasonContaining: "plateaued after concurrency 20",
+		},
+		{
+			name:             "Plateaus as errors climb",
+			steps:            []Metrics{step(10, 100, 10*ms, 0), step(20, 101, 10*ms, 2.5)},
+			sustainable:      10,
+			knee:             10,
+			reasonContaining: "plateaued",
+		},
+		{
+			name:             "Breaks the p99 SLO",
+			steps:            []Metrics{step(10, 100, 10*ms, 0), step(20, 150, 60*ms, 0), step(30, 150, 90*ms, 0)},
+			stopAfter:        2,
+			sustainable:      10,
+			breach:           20,
+			reasonContaining: "p99 60ms exceeds",
+		},
+		{
+			name:             "First step breaks the error SLO",
+			steps:            []Metrics{step(10, 100, 10*ms, 10), step(20, 200, 10*ms, 0)},
+			stopAfter:        1,
+			breach:           10,
+			reasonContaining: "first step",
+		},
+	}
+	for _, tt := range tests {
+		d := newSaturationDetector(SLO{P99: 50 * ms, ErrorRate: 5})
+		stopped := 0
+		for i, m := range tt.steps {
+			if d.observe(m) {
+				stopped = i + 1
+				break
+			}
+		}
+		if stopped != tt.stopAfter {
+			t.Errorf("%s: expected to stop after %d steps, stopped after %d", tt.name, tt.stopAfter, stopped)
+		}
+		s := d.summary()
+		if s.MaxSustainableConcurrency != tt.sustainable || s.KneeConcurrency != tt.knee || s.BreachConcurrency != tt.breach {
+			t.Errorf("%s: expected sustainable %d, knee %d, breach %d, got %+v", tt.name, tt.sustainable, tt.knee, tt.breach, s)
+		}
+		if !strings.Contains(s.Reason, tt.reasonContaining) {
+			t.Errorf("%s: expected the reason to mention %q, got %q", tt.name, tt.reasonContaining, s.Reason)
+		}
+	}
+}
+
+func TestStressTestStopsAtSLOBreach(t *testing.T) {
+	// The server handles two requests at a time, so latency grows with
+	// concurrency while throughput stays flat.
+	slots := make(chan struct{}, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slots <- struct{}{}
+		time.Sleep(5 * time.Millisecond)
+		<-slots
+	}))
+	defer srv.Close()
+
+	slo := SLO{P99: 50 * time.Millisecond}
+	results, s := stressTest(context.Background(), srv.Client(), urlWorkload(srv.URL), 40, 2, 100*time.Millisecond, slo, nil)
+	if len(results) >= 20 || s.BreachConcurrency != results[len(results)-1].Concurrency {
+		t.Fatalf("expected the test to stop at the first breaching step, ran %d steps: %+v", len(results), s)
+	}
+	if s.KneeConcurrency == 0 || s.MaxSustainableConcurrency == 0 || s.MaxSustainableConcurrency >= s.BreachConcurrency {
+		t.Errorf("expected a knee and a sustainable point below the breach, got %+v", s)
+	}
+}
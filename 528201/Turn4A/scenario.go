@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Workload is what closed-loop workers run. Each worker gets its own User.
+type Workload interface {
+	NewUser(id int) User
+}
+
+// User is one virtual user. Iterate runs one pass of its requests, recording
+// each of them in rec, and is called repeatedly until the test ends.
+type User interface {
+	Iterate(ctx context.Context, client *http.Client, rec *recorder)
+}
+
+// urlWorkload is the original workload: GET the same URL over and over.
+type urlWorkload string
+
+func (w urlWorkload) NewUser(int) User { return w }
+
+func (w urlWorkload) Iterate(ctx context.Context, client *http.Client, rec *recorder) {
+	rec.start()
+	sent := time.Now()
+	status, n, err := sendRequest(withConnObserver(ctx, rec), client, string(w))
+	rec.done(time.Since(sent), status, n, err)
+}
+
+// Scenario is a multi-step load test read from a YAML file:
+//
+//	name: checkout
+//	variables:
+//	  base: http://localhost:8080
+//	data:
+//	  file: users.csv     # one row per virtual user, header row names the columns
+//	  mode: sequential    # or random
+//	steps:
+//	  - name: login
+//	    method: POST
+//	    url: "{{base}}/login"
+//	    headers: {Content-Type: application/json}
+//	    body: '{"user": "{{username}}", "password": "{{password}}"}'
+//	    extract: {token: data.token}
+//	    think: 500ms
+//	  - name: browse
+//	    mix:
+//	      - {weight: 3, url: "{{base}}/products"}
+//	      - {weight: 1, url: "{{base}}/search?q=shoes"}
+//	    think: 1s
+//	    think_max: 3s
+//
+// Each virtual user runs the steps in order, over and over. "{{name}}" in a
+// URL, header, or body is replaced by a variable, a column of the user's data
+// row, or a value extracted from an earlier response.
+type Scenario struct {
+	Name      string            `yaml:"name"`
+	Variables map[string]string `yaml:"variables"`
+	Data      *DataFeeder       `yaml:"data"`
+	Steps     []Step            `yaml:"steps"`
+
+	rows []map[string]string
+}
+
+// DataFeeder hands each virtual user a row of a CSV file, in order or at random.
+type DataFeeder struct {
+	File string `yaml:"file"` // Relative paths are resolved against the scenario file
+	Mode string `yaml:"mode"` // "sequential" (default) or "random"
+}
+
+// Request is one HTTP request template.
+type Request struct {
+	Method  string            `yaml:"method"` // Defaults to GET
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+	Extract map[string]string `yaml:"extract"` // Variable name to dotted path into a JSON response, e.g. items.0.id
+}
+
+// Step sends either its own request or one picked from Mix by weight, then
+// pauses for Think, or a random time between Think and ThinkMax.
+type Step struct {
+	Name     string `yaml:"name"`
+	Request  `yaml:",inline"`
+	Mix      []WeightedRequest `yaml:"mix"`
+	Think    time.Duration     `yaml:"think"`
+	ThinkMax time.Duration     `yaml:"think_max"`
+}
+
+type WeightedRequest struct {
+	Weight  int `yaml:"weight"`
+	Request `yaml:",inline"`
+}
+
+// errExtractFailed marks a response that arrived but lacked a value to extract.
+var errExtractFailed = errors.New("extract failed")
+
+// LoadScenario reads and validates a scenario file and its data feeder.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.Data != nil {
+		file := s.Data.File
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		if s.rows, err = readDataRows(file); err != nil {
+			return nil, fmt.Errorf("%s: data: %w", path, err)
+		}
+	}
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	if len(s.Steps) == 0 {
+		return errors.New("scenario has no steps")
+	}
+	if s.Data != nil {
+		if s.Data.File == "" {
+			return errors.New("data: missing file")
+		}
+		if m := s.Data.Mode; m != "" && m != "sequential" && m != "random" {
+			return fmt.Errorf("data: unknown mode %q", m)
+		}
+	}
+	for i, step := range s.Steps {
+		name := step.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+		}
+		switch {
+		case step.URL == "" && len(step.Mix) == 0:
+			return fmt.Errorf("step %s: needs a url or a mix", name)
+		case step.URL != "" && len(step.Mix) > 0:
+			return fmt.Errorf("step %s: has both a url and a mix", name)
+		case step.ThinkMax != 0 && step.ThinkMax < step.Think:
+			return fmt.Errorf("step %s: think_max is shorter than think", name)
+		}
+		for _, m := range step.Mix {
+			if m.Weight <= 0 || m.URL == "" {
+				return fmt.Errorf("step %s: every mix entry needs a url and a positive weight", name)
+			}
+		}
+	}
+	return nil
+}
+
+// readDataRows reads a CSV file whose header row names the variables.
+func readDataRows(path string) ([]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) < 2 {
+		return nil, errors.New("expected a header row and at least one data row")
+	}
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, name := range records[0] {
+			row[name] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (s *Scenario) NewUser(id int) User {
+	u := &scenarioUser{
+		scenario: s,
+		vars:     make(map[string]string),
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano() + int64(id))),
+	}
+	for k, v := range s.Variables {
+		u.vars[k] = v
+	}
+	if len(s.rows) > 0 {
+		row := s.rows[id%len(s.rows)]
+		if s.Data.Mode == "random" {
+			row = s.rows[u.rng.Intn(len(s.rows))]
+		}
+		for k, v := range row {
+			u.vars[k] = v
+		}
+	}
+	u.vars["vu"] = strconv.Itoa(id)
+	return u
+}
+
+// scenarioUser holds one virtual user's variables across iterations.
+type scenarioUser struct {
+	scenario *Scenario
+	vars     map[string]string
+	rng      *rand.Rand
+}
+
+func (u *scenarioUser) Iterate(ctx context.Context, client *http.Client, rec *recorder) {
+	for _, step := range u.scenario.Steps {
+		if ctx.Err() != nil {
+			return
+		}
+		u.send(ctx, client, u.pick(step), rec)
+
+		think := step.Think
+		if step.ThinkMax > step.Think {
+			think += time.Duration(u.rng.Int63n(int64(step.ThinkMax - step.Think)))
+		}
+		if think > 0 {
+			timer := time.NewTimer(think)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+	}
+}
+
+// pick returns the step's request, or a weighted random choice from its mix.
+func (u *scenarioUser) pick(step Step) Request {
+	if len(step.Mix) == 0 {
+		return step.Request
+	}
+	total := 0
+	for _, m := range step.Mix {
+		total += m.Weight
+	}
+	n := u.rng.Intn(total)
+	for _, m := range step.Mix {
+		if n -= m.Weight; n < 0 {
+			return m.Request
+		}
+	}
+	return step.Mix[len(step.Mix)-1].Request
+}
+
+func (u *scenarioUser) send(ctx context.Context, client *http.Client, r Request, rec *recorder) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader
+	if r.Body != "" {
+		body = strings.NewReader(u.expand(r.Body))
+	}
+	rec.start()
+	sent := time.Now()
+	req, err := http.NewRequestWithContext(withConnObserver(ctx, rec), method, u.expand(r.URL), body)
+	if err != nil {
+		rec.done(0, 0, 0, err)
+		return
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, u.expand(v))
+	}
+
+	status, n, respBody, err := send(client, req, len(r.Extract) > 0)
+	if err == nil && status/100 == 2 {
+		err = u.extract(r.Extract, respBody)
+	}
+	rec.done(time.Since(sent), status, n, err)
+}
+
+var placeholder = regexp.MustCompile(`\{\{\s*([\w.-]+)\s*\}\}`)
+
+// expand replaces {{name}} with the user's variable; unknown names are left as they are.
+func (u *scenarioUser) expand(s string) string {
+	return placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := u.vars[placeholder.FindStringSubmatch(m)[1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// extract stores the values at the given paths of a JSON body in the user's variables.
+func (u *scenarioUser) extract(paths map[string]string, body []byte) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return fmt.Errorf("%w: response is not JSON: %v", errExtractFailed, err)
+	}
+	for name, path := range paths {
+		value, err := lookupJSON(doc, path)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", errExtractFailed, name, err)
+		}
+		u.vars[name] = value
+	}
+	return nil
+}
+
+// lookupJSON follows a dotted path such as "data.items.0.id", with an optional
+// leading "$.", and returns the value found there as a string.
+func lookupJSON(doc any, path string) (string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	cur := doc
+	if path != "" {
+		for _, key := range strings.Split(path, ".") {
+			switch node := cur.(type) {
+			case map[string]any:
+				next, ok := node[key]
+				if !ok {
+					return "", fmt.Errorf("no %q in %s", key, path)
+				}
+				cur = next
+			case []any:
+				i, err := strconv.Atoi(key)
+				if err != nil || i < 0 || i >= len(node) {
+					return "", fmt.Errorf("no index %q in %s", key, path)
+				}
+				cur = node[i]
+			default:
+				return "", fmt.Errorf("cannot look up %q in %s", key, path)
+			}
+		}
+	}
+	switch v := cur.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case nil:
+		return "", fmt.Errorf("%s is null", path)
+	}
+	encoded, err := json.Marshal(cur)
+	return string(encoded), err
+}
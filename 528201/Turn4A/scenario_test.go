@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeScenario(t *testing.T, yaml string, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "scenario.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// shopServer issues a token per user on login and only serves items to
+// requests carrying that user's token.
+type shopServer struct {
+	mu     sync.Mutex
+	logins map[string]int // Logins per user
+	hits   map[string]int // Hits per path
+}
+
+func (s *shopServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.hits[r.URL.Path]++
+	s.mu.Unlock()
+	switch r.URL.Path {
+	case "/login":
+		var creds struct{ User, Password string }
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" ||
+			json.NewDecoder(r.Body).Decode(&creds) != nil || creds.Password != "pw-"+creds.User {
+			http.Error(w, "bad login", http.StatusUnauthorized)
+			return
+		}
+		s.mu.Lock()
+		s.logins[creds.User]++
+		s.mu.Unlock()
+		fmt.Fprintf(w, `{"data": {"token": "tok-%s", "items": [{"id": 7}, {"id": 8}]}}`, creds.User)
+	case "/items/8", "/popular":
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer tok-") {
+			http.Error(w, "no token", http.StatusForbidden)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestScenarioRunsStepsWithExtractionAndFeeder(t *testing.T) {
+	shop := &shopServer{logins: map[string]int{}, hits: map[string]int{}}
+	srv := httptest.NewServer(shop)
+	defer srv.Close()
+
+	path := writeScenario(t, `
+name: shop
+variables:
+  base: `+srv.URL+`
+data:
+  file: users.csv
+steps:
+  - name: login
+    method: POST
+    url: "{{base}}/login"
+    headers: {Content-Type: application/json}
+    body: '{"user": "{{user}}", "password": "{{password}}"}'
+    extract:
+      token: data.token
+      item: $.data.items.1.id
+  - name: browse
+    mix:
+      - {weight: 1, url: "{{base}}/items/{{item}}", headers: {Authorization: "Bearer {{token}}"}}
+      - {weight: 3, url: "{{base}}/popular", headers: {Authorization: "Bearer {{token}}"}}
+    think: 1ms
+`, map[string]string{"users.csv": "user,password\nann,pw-ann\nbob,pw-bob\n"})
+
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := runTest(context.Background(), srv.Client(), scenario, 4, 200*time.Millisecond)
+
+	if m.TotalRequests == 0 || m.FailedRequests != 0 {
+		t.Fatalf("expected only successful requests, got %+v", m)
+	}
+	if shop.logins["ann"] == 0 || shop.logins["bob"] == 0 || len(shop.logins) != 2 {
+		t.Errorf("expected the feeder to log in as ann and bob, got %v", shop.logins)
+	}
+	items, popular := shop.hits["/items/8"], shop.hits["/popular"]
+	if items == 0 || popular < items {
+		t.Errorf("expected a 1:3 mix of /items/8 and /popular, got %d and %d", items, popular)
+	}
+}
+
+func TestScenarioExtractFailureIsCounted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {}}`))
+	}))
+	defer srv.Close()
+
+	path := writeScenario(t, `
+steps:
+  - url: `+srv.URL+`
+    extract: {token: data.token}
+`, nil)
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := newRecorder()
+	scenario.NewUser(0).Iterate(context.Background(), srv.Client(), rec)
+
+	var m Metrics
+	rec.fill(&m, time.Second)
+	if m.FailedRequests != 1 || m.Errors[errExtract] != 1 || m.StatusCodes[200] != 1 {
+		t.Errorf("expected one failed extraction on a 200, got %+v", m)
+	}
+}
+
+func TestLoadScenarioValidates(t *testing.T) {
+	for name, yaml := range map[string]string{
+		"No steps":          "name: empty\n",
+		"No url":            "steps:\n  - name: a\n",
+		"Url and mix":       "steps:\n  - url: http://x\n    mix: [{weight: 1, url: http://y}]\n",
+		"Zero weight":       "steps:\n  - mix: [{weight: 0, url: http://y}]\n",
+		"Think range":       "steps:\n  - url: http://x\n    think: 2s\n    think_max: 1s\n",
+		"Unknown feed":      "data: {file: users.csv, mode: shuffled}\nsteps:\n  - url: http://x\n",
+		"Missing feed":      "data: {file: missing.csv}\nsteps:\n  - url: http://x\n",
+		"Not a duration":    "steps:\n  - url: http://x\n    think: soon\n",
+		"Malformed YAML":    "steps: [",
+		"Feed without file": "data: {mode: random}\nsteps:\n  - url: http://x\n",
+	} {
+		if _, err := LoadScenario(writeScenario(t, yaml, nil)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLookupJSON(t *testing.T) {
+	var doc any
+	json.Unmarshal([]byte(`{"a": {"list": [{"id": "x"}, {"id": 2}]}, "flag": true}`), &doc)
+	for path, want := range map[string]string{
+		"a.list.0.id":   "x",
+		"$.a.list.1.id": "2",
+		"flag":          "true",
+		"a.list.1":      `{"id":2}`,
+	} {
+		if got, err := lookupJSON(doc, path); err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q (%v)", path, want, got, err)
+		}
+	}
+	for _, path := range []string{"a.missing", "a.list.5", "flag.x"} {
+		if _, err := lookupJSON(doc, path); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
@@ -7,20 +7,54 @@ import (
 	"time"
 )
 
+// Clock tells the RateLimiter the time, so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// fakeClock only moves when a test advances it.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
 // RateLimiter is a simple token bucket rate limiter.
 type RateLimiter struct {
 	rate      int
 	bucket    float64
 	lastCheck time.Time
+	clock     Clock
 	mu        sync.Mutex
 }
 
-// NewRateLimiter creates a new RateLimiter with specified tokens per second.
-func NewRateLimiter(rate int) *RateLimiter {
+// NewRateLimiter creates a new RateLimiter with specified tokens per second,
+// reading the time from clock (realClock{} outside tests).
+func NewRateLimiter(rate int, clock Clock) *RateLimiter {
 	return &RateLimiter{
 		rate:      rate,
 		bucket:    float64(rate),
-		lastCheck: time.Now(),
+		lastCheck: clock.Now(),
+		clock:     clock,
 	}
 }
 
@@ -29,7 +63,7 @@ func (rl *RateLimiter) Allow() bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
+	now := rl.clock.Now()
 	elapsed := now.Sub(rl.lastCheck).Seconds()
 	rl.bucket += elapsed * float64(rl.rate)
 
@@ -64,7 +98,8 @@ func teardown() {
 
 // TestRateLimiter tests the RateLimiter's basic functionality.
 func TestRateLimiter(t *testing.T) {
-	rl := NewRateLimiter(2) // 2 tokens per second
+	clock := newFakeClock()
+	rl := NewRateLimiter(2, clock) // 2 tokens per second
 
 	tests := []struct {
 		name    string
@@ -81,9 +116,9 @@ func TestRateLimiter(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			time.Sleep(tt.wait)
-			if rl.Allow() != tt.allowed {
-				t.Errorf("expected allowed = %v, got = %v", tt.allowed, rl.Allow())
+			clock.Advance(tt.wait)
+			if got := rl.Allow(); got != tt.allowed {
+				t.Errorf("expected allowed = %v, got = %v", tt.allowed, got)
 			}
 		})
 	}
@@ -91,7 +126,8 @@ func TestRateLimiter(t *testing.T) {
 
 // TestBurstyTraffic simulates bursty traffic scenarios.
 func TestBurstyTraffic(t *testing.T) {
-	rl := NewRateLimiter(5) // 5 tokens per second
+	clock := newFakeClock()
+	rl := NewRateLimiter(5, clock) // 5 tokens per second
 
 	for i := 0; i < 5; i++ {
 		if !rl.Allow() {
@@ -102,7 +138,7 @@ func TestBurstyTraffic(t *testing.T) {
 		t.Error("expected request 6 to be rejected due to rate limit")
 	}
 
-	time.Sleep(1 * time.Second)
+	clock.Advance(1 * time.Second)
 
 	if !rl.Allow() {
 		t.Error("expected request to be allowed after 1 second")
@@ -111,7 +147,8 @@ func TestBurstyTraffic(t *testing.T) {
 
 // TestUnevenDistribution tests handling uneven traffic.
 func TestUnevenDistribution(t *testing.T) {
-	rl := NewRateLimiter(3) // 3 tokens per second
+	clock := newFakeClock()
+	rl := NewRateLimiter(3, clock) // 3 tokens per second
 
 	for i := 0; i < 3; i++ {
 		if !rl.Allow() {
@@ -119,13 +156,13 @@ func TestUnevenDistribution(t *testing.T) {
 		}
 	}
 
-	time.Sleep(300 * time.Millisecond)
+	clock.Advance(300 * time.Millisecond)
 
 	if rl.Allow() {
 		t.Error("expected request to be rejected due to partial refill")
 	}
 
-	time.Sleep(700 * time.Millisecond)
+	clock.Advance(700 * time.Millisecond)
 
 	if !rl.Allow() {
 		t.Error("expected request to be allowed after full second")
@@ -134,13 +171,24 @@ func TestUnevenDistribution(t *testing.T) {
 
 // TestRateLimitReset simulates handling of rate-limit resets.
 func TestRateLimitReset(t *testing.T) {
-	rl := NewRateLimiter(1) // 1 token per second
+	clock := newFakeClock()
+	rl := NewRateLimiter(1, clock) // 1 token per second
 
 	if !rl.Allow() {
 		t.Error("expected initial request to be allowed")
 	}
 
-	time.Sleep(1 * time.Second)
+	if rl.Allow() {
+		t.Error("expected a second immediate request to be rejected")
+	}
+
+	clock.Advance(999 * time.Millisecond)
+
+	if rl.Allow() {
+		t.Error("expected request to be rejected just before the reset period")
+	}
+
+	clock.Advance(1 * time.Millisecond)
 
 	if !rl.Allow() {
 		t.Error("expected request to be allowed after reset period")
@@ -0,0 +1,357 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority ranks requests for admission. As a key's limit runs low, lower
+// priorities are shed first, leaving what is left to the higher ones.
+type Priority int
+
+const (
+	Background  Priority = iota // Such as prefetching and cleanup; shed first
+	Batch                       // Such as reports and bulk jobs
+	Interactive                 // Someone is waiting on it; shed last
+)
+
+const priorities = 3
+
+func (p Priority) String() string {
+	switch p {
+	case Background:
+		return "background"
+	case Batch:
+		return "batch"
+	case Interactive:
+		return "interactive"
+	}
+	return fmt.Sprintf("Priority(%d)", int(p))
+}
+
+// ErrShed is returned by Admission.Wait for a request it turned away to
+// leave room for higher priorities.
+var ErrShed = errors.New("request shed under load")
+
+// AdmissionConfig configures an Admission.
+type AdmissionConfig struct {
+	// Reserve is the share of each key's limit, from 0 to 1, held back from
+	// each priority: a request is admitted only if that much would still be
+	// left after it. A LeakyBucket, which lets one request through at a
+	// time, holds it back as time the queue must have stood empty. Nil means
+	// none for Interactive, a quarter for Batch, and half for Background.
+	Reserve map[Priority]float64
+
+	// QueueTimeout is how long Wait holds a request that can't be admitted
+	// yet; zero means it doesn't, and sheds it at once.
+	QueueTimeout time.Duration
+	QueueSize    int // Most requests held per key; zero means 100
+
+	// Weights share out admissions from the queue between the priorities
+	// waiting in it. Nil means 6 for Interactive, 3 for Batch, and 1 for
+	// Background.
+	Weights map[Priority]int
+}
+
+// Admission admits requests under a UserRateLimiter's limits by priority,
+// optionally queuing those it can't admit yet and admitting them fairly by
+// weight. Like the UserRateLimiter's own Allow, it refuses keys without a
+// rule when there is no default. It is safe for concurrent use.
+type Admission struct {
+	limits  *UserRateLimiter
+	clock   Clock
+	reserve [priorities]float64
+	weights [priorities]int
+	timeout time.Duration
+	size    int
+
+	mu     sync.Mutex
+	queues map[string]*queue // Only for keys with requests waiting
+}
+
+// queue holds a key's waiting requests, and orders them by self-clocked
+// fair queuing: each priority's oldest request is tagged, as it reaches the
+// front, with when it would finish if every priority waiting were served in
+// proportion to its weight, and the earliest tag goes first.
+type queue struct {
+	waiting [priorities][]chan error // Oldest first
+	n       int                      // In all of waiting
+	tag     [priorities]float64      // Of each priority's oldest request
+	finish  [priorities]float64      // Tag of each priority's last admitted
+	vtime   float64                  // Tag of the request last admitted
+	wake    chan struct{}
+}
+
+// front tags p's oldest request.
+func (q *queue) front(p Priority, weight int) {
+	q.tag[p] = max(q.finish[p], q.vtime) + 1/float64(weight)
+}
+
+// signal wakes the queue's dispatcher, if it is waiting.
+func (q *queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NewAdmission returns an Admission for limits under cfg.
+func NewAdmission(limits *UserRateLimiter, cfg AdmissionConfig) (*Admission, error) {
+	a := &Admission{
+		limits:  limits,
+		clock:   limits.opts.clock,
+		reserve: [priorities]float64{Batch: 0.25, Background: 0.5},
+		weights: [priorities]int{Interactive: 6, Batch: 3, Background: 1},
+		timeout: cfg.QueueTimeout,
+		size:    cfg.QueueSize,
+		queues:  make(map[string]*queue),
+	}
+	if cfg.Reserve != nil {
+		a.reserve = [priorities]float64{}
+		for p, share := range cfg.Reserve {
+			if !p.valid() || share < 0 || share > 1 {
+				return nil, fmt.Errorf("invalid reserve %v for %v", share, p)
+			}
+			a.reserve[p] = share
+		}
+	}
+	if cfg.Weights != nil {
+		a.weights = [priorities]int{}
+		for p, w := range cfg.Weights {
+			if !p.valid() || w <= 0 {
+				return nil, fmt.Errorf("invalid weight %d for %v", w, p)
+			}
+			a.weights[p] = w
+		}
+		for p := range priorities {
+			if a.weights[p] == 0 {
+				return nil, fmt.Errorf("no weight for %v", Priority(p))
+			}
+		}
+	}
+	if a.timeout < 0 || a.size < 0 {
+		return nil, errors.New("queue timeout and size must not be negative")
+	}
+	if a.size == 0 {
+		a.size = 100
+	}
+	return a, nil
+}
+
+func (p Priority) valid() bool { return p >= 0 && p < priorities }
+
+// Allow admits a request for key at priority p if it fits now, with p's
+// reserve still left after it. It never waits.
+func (a *Admission) Allow(key string, p Priority) bool {
+	if !p.valid() {
+		return false
+	}
+	ok, _ := a.admit(key, p)
+	return ok
+}
+
+// Wait admits a request for key at priority p. One that doesn't fit now is
+// queued behind any already waiting for key, until the queue admits it,
+// QueueTimeout passes, or ctx is done. It returns ErrShed if the request
+// wasn't admitted in time, or was pushed out of a full queue by a higher
+// priority.
+func (a *Admission) Wait(ctx context.Context, key string, p Priority) error {
+	if !p.valid() {
+		return fmt.Errorf("invalid priority %d", int(p))
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	q := a.queues[key]
+	if q == nil {
+		// With nothing waiting, there is nobody to jump ahead of.
+		ok, retryAfter := a.admit(key, p)
+		switch {
+		case ok:
+			a.mu.Unlock()
+			return nil
+		case retryAfter == 0:
+			a.mu.Unlock()
+			return ErrCannotReserve
+		case a.timeout == 0:
+			a.mu.Unlock()
+			return ErrShed
+		}
+		q = &queue{wake: make(chan struct{}, 1)}
+		a.queues[key] = q
+		go a.dispatch(key, q)
+	}
+	done := make(chan error, 1)
+	if !a.push(q, p, done) {
+		a.mu.Unlock()
+		return ErrShed
+	}
+	a.mu.Unlock()
+
+	timeout := a.clock.After(a.timeout)
+	select {
+	case err := <-done:
+		return err
+	case <-timeout:
+		return a.abandon(q, p, done, ErrShed)
+	case <-ctx.Done():
+		return a.abandon(q, p, done, ctx.Err())
+	}
+}
+
+// Queued returns how many requests are waiting for key.
+func (a *Admission) Queued(key string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if q := a.queues[key]; q != nil {
+		return q.n
+	}
+	return 0
+}
+
+// admit takes a request from key's limit if it fits now with p's reserve
+// left over. If it doesn't, it returns how long until it would, or zero if
+// never.
+func (a *Admission) admit(key string, p Priority) (bool, time.Duration) {
+	lim, ok := a.limits.limiter(key)
+	if !ok {
+		return false, 0
+	}
+	lim.mu.Lock()
+	defer lim.mu.Unlock()
+	now := lim.now()
+	capacity := lim.alg.capacity()
+	// Never more than the whole limit, so that every priority gets in
+	// eventually while nothing else does.
+	need := min(capacity, 1+int(a.reserve[p]*float64(capacity)))
+	if b, ok := lim.alg.(*leakyBucket); ok {
+		// The leaky bucket lets one request through at a time however
+		// much room it has, so fits can't see a reserve there. Instead the
+		// queue must have stood empty for the reserve's worth of intervals,
+		// as long as a token bucket would take to refill it.
+		if at := b.free.Add(time.Duration(need-1) * b.interval); at.After(now) {
+			return false, at.Sub(now)
+		}
+	}
+	if ok, retryAfter := fits(lim.alg, now, need); !ok {
+		return false, retryAfter
+	}
+	return allow(lim.alg, now, 1)
+}
+
+// push adds a request to q, making room in a full queue by shedding the
+// newest request of the lowest priority below p, if there is one. Callers
+// hold a.mu.
+func (a *Admission) push(q *queue, p Priority, done chan error) bool {
+	if q.n >= a.size {
+		lower := Background
+		for lower < p && len(q.waiting[lower]) == 0 {
+			lower++
+		}
+		if lower == p {
+			return false
+		}
+		last := len(q.waiting[lower]) - 1
+		q.waiting[lower][last] <- ErrShed
+		q.waiting[lower] = q.waiting[lower][:last]
+		q.n--
+	}
+	if len(q.waiting[p]) == 0 {
+		q.front(p, a.weights[p])
+	}
+	q.waiting[p] = append(q.waiting[p], done)
+	q.n++
+	q.signal()
+	return true
+}
+
+// abandon takes a request out of q for err, unless it was admitted or shed
+// in the meantime, in which case that stands.
+func (a *Admission) abandon(q *queue, p Priority, done chan error, err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, c := range q.waiting[p] {
+		if c == done {
+			q.waiting[p] = append(q.waiting[p][:i], q.waiting[p][i+1:]...)
+			if q.n--; q.n == 0 {
+				q.signal()
+			}
+			return err
+		}
+	}
+	return <-done
+}
+
+// dispatch admits key's queued requests as its limit allows, until none
+// are left.
+func (a *Admission) dispatch(key string, q *queue) {
+	for {
+		a.mu.Lock()
+		if q.n == 0 {
+			delete(a.queues, key)
+			a.mu.Unlock()
+			return
+		}
+		delay := a.next(key, q)
+		a.mu.Unlock()
+		if delay == 0 {
+			continue
+		}
+		select {
+		case <-a.clock.After(delay):
+		case <-q.wake:
+		}
+	}
+}
+
+// next admits the first of q's requests by finish tag that fits now, and
+// returns zero; or if none do, how long until one might. Callers hold
+// a.mu.
+func (a *Admission) next(key string, q *queue) time.Duration {
+	var wait time.Duration
+	tried := [priorities]bool{}
+	for {
+		p, tag := Priority(-1), 0.0
+		// Highest priority first, so that it wins ties.
+		for i := Priority(priorities - 1); i >= 0; i-- {
+			if tried[i] || len(q.waiting[i]) == 0 {
+				continue
+			}
+			if p < 0 || q.tag[i] < tag {
+				p, tag = i, q.tag[i]
+			}
+		}
+		if p < 0 {
+			return wait
+		}
+		tried[p] = true
+		ok, retryAfter := a.admit(key, p)
+		if !ok && retryAfter == 0 {
+			// The rule changed to one these never fit.
+			for _, done := range q.waiting[p] {
+				done <- ErrCannotReserve
+			}
+			q.n -= len(q.waiting[p])
+			q.waiting[p] = nil
+			return 0
+		}
+		if !ok {
+			if wait == 0 || retryAfter < wait {
+				wait = retryAfter
+			}
+			continue
+		}
+		q.waiting[p][0] <- nil
+		q.waiting[p] = q.waiting[p][1:]
+		q.n--
+		q.finish[p], q.vtime = tag, tag
+		if len(q.waiting[p]) > 0 {
+			q.front(p, a.weights[p])
+		}
+		return 0
+	}
+}
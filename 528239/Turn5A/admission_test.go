@@ -0,0 +1,289 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestAdmission(t *testing.T, l Limit, cfg AdmissionConfig) (*Admission, *FakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	limits := NewUserRateLimiter(WithClock(clock))
+	if err := limits.SetRateLimit("api_user", TokenBucket, l); err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewAdmission(limits, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, clock
+}
+
+func TestAdmissionShedsLowPrioritiesFirst(t *testing.T) {
+	a, clock := newTestAdmission(t, Limit{Rate: 8}, AdmissionConfig{})
+	count := func(p Priority) int { return countFor(a, "api_user", p) }
+
+	// Background stops with half the bucket left, and batch with a quarter.
+	for _, tt := range []struct {
+		p    Priority
+		want int
+	}{{Background, 4}, {Batch, 2}, {Interactive, 2}} {
+		if got := count(tt.p); got != tt.want {
+			t.Errorf("%v: expected %d allowed, got %d", tt.p, tt.want, got)
+		}
+	}
+	clock.Advance(time.Second)
+	if got := count(Interactive); got != 8 {
+		t.Errorf("expected interactive requests to use the whole bucket, got %d", got)
+	}
+	if got := countFor(a, "guest", Interactive); got != 0 {
+		t.Errorf("expected a key without a rule refused, got %d allowed", got)
+	}
+	if err := a.Wait(context.Background(), "guest", Interactive); !errors.Is(err, ErrCannotReserve) {
+		t.Errorf("expected a key without a rule never to be admitted, got %v", err)
+	}
+}
+
+func TestAdmissionAlgorithms(t *testing.T) {
+	// Requests admitted by priority, over four seconds of twice the load the
+	// limit allows, offered lowest priority first.
+	tests := []struct {
+		algorithm Algorithm
+		want      [priorities]int
+	}{
+		{TokenBucket, [priorities]int{Background: 2, Batch: 3, Interactive: 34}},
+		{LeakyBucket, [priorities]int{Background: 0, Batch: 0, Interactive: 32}},
+		{FixedWindow, [priorities]int{Background: 8, Batch: 8, Interactive: 16}},
+		{SlidingLog, [priorities]int{Background: 2, Batch: 8, Interactive: 22}},
+		{SlidingWindow, [priorities]int{Background: 2, Batch: 2, Interactive: 25}},
+		{GCRA, [priorities]int{Background: 2, Batch: 3, Interactive: 34}},
+	}
+	for _, tt := range tests {
+		clock := newFakeClock()
+		limits := NewUserRateLimiter(WithClock(clock))
+		if err := limits.SetRateLimit("api_user", tt.algorithm, Limit{Rate: 8}); err != nil {
+			t.Fatal(err)
+		}
+		a, err := NewAdmission(limits, AdmissionConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got [priorities]int
+		for range 64 {
+			for p := range Priority(priorities) {
+				if a.Allow("api_user", p) {
+					got[p]++
+				}
+			}
+			clock.Advance(time.Second / 16)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %v admitted, got %v", tt.algorithm, tt.want, got)
+		}
+	}
+}
+
+func TestAdmissionFairQueue(t *testing.T) {
+	a, clock := newTestAdmission(t, Limit{Rate: 10}, AdmissionConfig{Reserve: map[Priority]float64{}})
+	countFor(a, "api_user", Interactive)
+
+	// Everyone waits from the start, so the queue shares admissions out by
+	// weight alone.
+	q := &queue{}
+	for p := range Priority(priorities) {
+		for range 20 {
+			a.push(q, p, make(chan error, 1))
+		}
+	}
+	for range 20 {
+		clock.Advance(100 * time.Millisecond)
+		if delay := a.next("api_user", q); delay != 0 {
+			t.Fatalf("expected a request admitted, got a delay of %v", delay)
+		}
+		if delay := a.next("api_user", q); delay != 100*time.Millisecond {
+			t.Fatalf("expected to wait for the next request, got %v", delay)
+		}
+	}
+	want := [priorities]int{Interactive: 12, Batch: 6, Background: 2}
+	for p := range Priority(priorities) {
+		if got := 20 - len(q.waiting[p]); got != want[p] {
+			t.Errorf("%v: expected %d admitted, got %d", p, want[p], got)
+		}
+	}
+}
+
+// countFor returns how many requests for key at priority p are allowed in
+// a row, up to 100.
+func countFor(a *Admission, key string, p Priority) int {
+	n := 0
+	for n < 100 && a.Allow(key, p) {
+		n++
+	}
+	return n
+}
+
+func TestAdmissionWait(t *testing.T) {
+	a, clock := newTestAdmission(t, Limit{Rate: 1, Per: time.Hour}, AdmissionConfig{
+		QueueTimeout: 10 * time.Second,
+		QueueSize:    2,
+	})
+	ctx := context.Background()
+	// wait queues a request, and waits until it is.
+	wait := func(ctx context.Context, key string, p Priority) <-chan error {
+		errs := make(chan error, 1)
+		n := a.Queued(key)
+		go func() { errs <- a.Wait(ctx, key, p) }()
+		for deadline := time.Now().Add(5 * time.Second); a.Queued(key) == n; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected a %v request queued", p)
+			}
+		}
+		return errs
+	}
+	// advanceUntil moves the clock on a second at a time until errs has a
+	// result.
+	advanceUntil := func(errs <-chan error) error {
+		t.Helper()
+		for range 10000 {
+			select {
+			case err := <-errs:
+				return err
+			default:
+				clock.Advance(time.Second)
+				time.Sleep(100 * time.Microsecond)
+			}
+		}
+		t.Fatal("expected a result")
+		return nil
+	}
+
+	if err := a.Wait(ctx, "api_user", Background); err != nil {
+		t.Fatalf("expected a request admitted at once, got %v", err)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	first := wait(cancelled, "api_user", Background)
+	second := wait(ctx, "api_user", Background)
+	// A full queue sheds its newest background request for an interactive
+	// one.
+	interactive := make(chan error, 1)
+	go func() { interactive <- a.Wait(ctx, "api_user", Interactive) }()
+	if err := <-second; !errors.Is(err, ErrShed) {
+		t.Errorf("expected the newest background request shed, got %v", err)
+	}
+	if err := a.Wait(ctx, "api_user", Background); !errors.Is(err, ErrShed) {
+		t.Errorf("expected a full queue to shed a background request, got %v", err)
+	}
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the caller's cancellation, got %v", err)
+	}
+	if err := advanceUntil(interactive); !errors.Is(err, ErrShed) {
+		t.Errorf("expected the interactive request shed after the queue timeout, got %v", err)
+	}
+
+	if err := a.limits.SetRateLimit("web_user", TokenBucket, Limit{Rate: 1, Per: 5 * time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	a.Allow("web_user", Interactive)
+	if err := advanceUntil(wait(ctx, "web_user", Background)); err != nil {
+		t.Errorf("expected a queued request admitted once the limit allows, got %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		a.mu.Lock()
+		n := len(a.queues)
+		a.mu.Unlock()
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected empty queues to be dropped")
+		}
+	}
+
+	if _, err := NewAdmission(NewUserRateLimiter(), AdmissionConfig{Reserve: map[Priority]float64{Batch: 2}}); err == nil {
+		t.Error("expected a reserve above the whole limit to be refused")
+	}
+	if _, err := NewAdmission(NewUserRateLimiter(), AdmissionConfig{Weights: map[Priority]int{Interactive: 1}}); err == nil {
+		t.Error("expected weights missing a priority to be refused")
+	}
+}
+
+// TestAdmissionMicroservices is TestMicroservicesRateLimiting from Turn3A
+// under load: three services share a user's limit, and the checkout
+// service's calls get through however the others' are timed.
+func TestAdmissionMicroservices(t *testing.T) {
+	a, clock := newTestAdmission(t, Limit{Rate: 20, Burst: 5}, AdmissionConfig{QueueTimeout: time.Second})
+
+	services := []struct {
+		name     string
+		priority Priority
+	}{{"checkout", Interactive}, {"reports", Batch}, {"prefetch", Background}}
+	var mu sync.Mutex
+	allowed := make(map[string]int)
+	finished := 0
+	var wg sync.WaitGroup
+	call := func(name string, p Priority) {
+		defer wg.Done()
+		err := a.Wait(context.Background(), "api_user", p)
+		mu.Lock()
+		defer mu.Unlock()
+		if err == nil {
+			allowed[name]++
+		}
+		finished++
+	}
+	// settle waits until n calls have been admitted, shed, or queued.
+	settle := func(n int) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+			mu.Lock()
+			done := finished
+			mu.Unlock()
+			if done+a.Queued("api_user") == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d calls settled, got %d", n, done+a.Queued("api_user"))
+			}
+		}
+	}
+	// The others get in first, and use up the burst.
+	for _, s := range services[1:] {
+		for range 10 {
+			wg.Add(1)
+			go call(s.name, s.priority)
+		}
+	}
+	settle(20)
+	for range 10 {
+		wg.Add(1)
+		go call("checkout", Interactive)
+	}
+	settle(30)
+	all := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(all)
+	}()
+	// Time moves on a token's worth at a time, until every call has been
+	// admitted or has timed out.
+	for done := false; !done; {
+		select {
+		case <-all:
+			done = true
+		default:
+			clock.Advance(50 * time.Millisecond)
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if allowed["checkout"] != 10 {
+		t.Errorf("expected every checkout call allowed, got %d", allowed["checkout"])
+	}
+	if allowed["prefetch"] == 10 {
+		t.Error("expected some prefetch calls shed")
+	}
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// seconds converts a number of seconds to a Duration, rounding up so that
+// waiting that long is always enough.
+func seconds(s float64) time.Duration { return time.Duration(math.Ceil(s * float64(time.Second))) }
+
+// tokenBucket holds up to burst tokens and refills at a steady rate; each
+// request takes a token. Reservations can take the bucket below empty.
+type tokenBucket struct {
+	rate   float64 // Tokens added per second
+	burst  float64 // Bucket capacity
+	tokens float64
+	last   time.Time // When tokens was last brought up to date
+}
+
+func newTokenBucket(l Limit, now time.Time) *tokenBucket {
+	burst := float64(l.burst())
+	return &tokenBucket{
+		rate:   float64(l.Rate) / l.period().Seconds(),
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func (b *tokenBucket) earliest(now time.Time, n int) (time.Time, bool) {
+	if float64(n) > b.burst {
+		return time.Time{}, false
+	}
+	b.refill(now)
+	if deficit := float64(n) - b.tokens; deficit > 0 {
+		return now.Add(seconds(deficit / b.rate)), true
+	}
+	return now, true
+}
+
+func (b *tokenBucket) take(_, _ time.Time, n int) { b.tokens -= float64(n) }
+
+func (b *tokenBucket) cancel(now, at time.Time, n int) {
+	if at.After(now) {
+		b.refill(now)
+		b.tokens = min(b.burst, b.tokens+float64(n))
+	}
+}
+
+func (b *tokenBucket) full(now time.Time) time.Time {
+	b.refill(now)
+	return now.Add(seconds((b.burst - b.tokens) / b.rate))
+}
+
+func (b *tokenBucket) capacity() int { return int(b.burst) }
+
+// retune keeps the tokens, up to the new burst.
+func (b *tokenBucket) retune(now time.Time, l Limit) bool {
+	b.refill(now)
+	b.rate = float64(l.Rate) / l.period().Seconds()
+	b.burst = float64(l.burst())
+	b.tokens = min(b.burst, b.tokens)
+	return true
+}
+
+// leakyBucket is a queue that drains one request every interval. A request
+// is only allowed if it would leave the queue straight away, so what gets
+// through is evenly spaced however bursty the arrivals; reservations join
+// the queue.
+type leakyBucket struct {
+	interval time.Duration
+	most     int       // Most requests a single take may queue
+	free     time.Time // When the queue is next empty
+}
+
+func newLeakyBucket(l Limit, now time.Time) *leakyBucket {
+	return &leakyBucket{interval: l.interval(), most: l.burst(), free: now}
+}
+
+func (b *leakyBucket) earliest(now time.Time, n int) (time.Time, bool) {
+	if n > b.most {
+		return time.Time{}, false
+	}
+	if b.free.After(now) {
+		return b.free, true
+	}
+	return now, true
+}
+
+func (b *leakyBucket) take(_, at time.Time, n int) {
+	b.free = at.Add(time.Duration(n) * b.interval)
+}
+
+func (b *leakyBucket) cancel(now, at time.Time, n int) {
+	if at.After(now) {
+		b.free = b.free.Add(-time.Duration(n) * b.interval)
+	}
+}
+
+func (b *leakyBucket) full(now time.Time) time.Time {
+	if b.free.After(now) {
+		return b.free
+	}
+	return now
+}
+
+func (b *leakyBucket) capacity() int { return b.most }
+
+// retune keeps the requests queued, to drain at the new interval.
+func (b *leakyBucket) retune(now time.Time, l Limit) bool {
+	interval := l.interval()
+	if b.free.After(now) {
+		b.free = now.Add(rescale(b.free.Sub(now), b.interval, interval))
+	}
+	b.interval, b.most = interval, l.burst()
+	return true
+}
+
+// rescale converts d from a number of intervals of from to as many of to.
+func rescale(d, from, to time.Duration) time.Duration {
+	return time.Duration(float64(d) * float64(to) / float64(from))
+}
+
+// gcra is the generic cell rate algorithm: every request moves a theoretical
+// arrival time one interval later, and requests are allowed as long as that
+// time stays within the burst's worth of intervals from now. It behaves like
+// a token bucket, but its whole state is one timestamp.
+type gcra struct {
+	interval  time.Duration
+	tolerance time.Duration // How far ahead of now tat may run: burst intervals
+	tat       time.Time     // Theoretical arrival time of the next request
+}
+
+func newGCRA(l Limit, now time.Time) *gcra {
+	interval := l.interval()
+	return &gcra{interval: interval, tolerance: time.Duration(l.burst()) * interval, tat: now}
+}
+
+func (g *gcra) earliest(now time.Time, n int) (time.Time, bool) {
+	cost := time.Duration(n) * g.interval
+	if cost > g.tolerance {
+		return time.Time{}, false
+	}
+	if at := g.full(now).Add(cost - g.tolerance); at.After(now) {
+		return at, true
+	}
+	return now, true
+}
+
+func (g *gcra) take(now, _ time.Time, n int) {
+	g.tat = g.full(now).Add(time.Duration(n) * g.interval)
+}
+
+func (g *gcra) cancel(now, at time.Time, n int) {
+	if at.After(now) {
+		g.tat = g.tat.Add(-time.Duration(n) * g.interval)
+	}
+}
+
+func (g *gcra) full(now time.Time) time.Time {
+	if g.tat.After(now) {
+		return g.tat
+	}
+	return now
+}
+
+func (g *gcra) capacity() int { return int(g.tolerance / g.interval) }
+
+// retune keeps the requests that would fit now, like the token bucket
+// keeps its tokens, up to the new burst.
+func (g *gcra) retune(now time.Time, l Limit) bool {
+	tokens := float64(g.tolerance-g.full(now).Sub(now)) / float64(g.interval)
+	g.interval = l.interval()
+	g.tolerance = time.Duration(l.burst()) * g.interval
+	g.tat = later(now, now.Add(g.tolerance-time.Duration(tokens*float64(g.interval))))
+	return true
+}
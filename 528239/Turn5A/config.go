@@ -0,0 +1,214 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a Hierarchy's rate limits as kept in a file, in YAML or JSON:
+//
+//	dry_run: false
+//	tiers:
+//	  user:
+//	    default: {rate: 10}
+//	    keys:
+//	      alice: {algorithm: gcra, rate: 5, burst: 20}
+//	  route:
+//	    keys:
+//	      "POST /orders": {algorithm: fixed_window, rate: 1, per: 1m}
+//
+// Tiers the file leaves out keep the Tier.Rule the Hierarchy was built
+// with, and nothing else.
+type Config struct {
+	DryRun bool                  `yaml:"dry_run"` // Log would-be refusals without enforcing them
+	Tiers  map[string]TierConfig `yaml:"tiers"`
+}
+
+// TierConfig is one tier's rate limits.
+type TierConfig struct {
+	Default *RuleConfig           `yaml:"default"` // For keys without a rule of their own
+	Keys    map[string]RuleConfig `yaml:"keys"`
+}
+
+// RuleConfig is a Rule as kept in a file.
+type RuleConfig struct {
+	Algorithm Algorithm     `yaml:"algorithm"` // Defaults to token_bucket
+	Rate      int           `yaml:"rate"`
+	Per       time.Duration `yaml:"per"`
+	Burst     int           `yaml:"burst"`
+}
+
+func (rc RuleConfig) rule() (Rule, error) {
+	r := Rule{Algorithm: rc.Algorithm, Limit: Limit{Rate: rc.Rate, Per: rc.Per, Burst: rc.Burst}}
+	if r.Algorithm == "" {
+		r.Algorithm = TokenBucket
+	}
+	_, err := newLimiter(r.Algorithm, r.Limit, newOptions(nil))
+	return r, err
+}
+
+// tierRules is a TierConfig checked and ready to apply.
+type tierRules struct {
+	rules    map[string]Rule
+	fallback *Rule
+}
+
+// LoadConfig reads and checks the Config at path. JSON is also YAML, so
+// either format reads the same way.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(data)
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.rules(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// rules checks every rule in cfg and returns them by tier.
+func (cfg *Config) rules() (map[string]tierRules, error) {
+	tiers := make(map[string]tierRules)
+	for name, tc := range cfg.Tiers {
+		tr := tierRules{rules: make(map[string]Rule)}
+		for key, rc := range tc.Keys {
+			rule, err := rc.rule()
+			if err != nil {
+				return nil, fmt.Errorf("tier %q, key %q: %w", name, key, err)
+			}
+			tr.rules[key] = rule
+		}
+		if tc.Default != nil {
+			rule, err := tc.Default.rule()
+			if err != nil {
+				return nil, fmt.Errorf("tier %q, default: %w", name, err)
+			}
+			tr.fallback = &rule
+		}
+		tiers[name] = tr
+	}
+	return tiers, nil
+}
+
+// Apply replaces every tier's rate limits with cfg's, all at once, or
+// returns an error and changes nothing. Keys keep their limiter's state
+// through a change of rate or burst; see UserRateLimiter.SetRules.
+func (h *Hierarchy) Apply(cfg *Config) error {
+	tiers, err := cfg.rules()
+	if err != nil {
+		return err
+	}
+	for name := range tiers {
+		if !h.hasTier(name) {
+			return fmt.Errorf("no tier %q", name)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range h.tiers {
+		tr, ok := tiers[t.name]
+		if !ok {
+			tr.fallback = t.rule
+		}
+		// Checked above, so this can't fail.
+		t.limits.SetRules(tr.rules, tr.fallback)
+	}
+	h.dryRun = cfg.DryRun
+	return nil
+}
+
+func (h *Hierarchy) hasTier(name string) bool {
+	for _, t := range h.tiers {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Reloader keeps a Hierarchy's rate limits in step with a Config file.
+type Reloader struct {
+	path   string
+	h      *Hierarchy
+	logger *log.Logger
+
+	mu   sync.Mutex
+	last [sha256.Size]byte // Of the file as last read, applied or not
+}
+
+// NewReloader applies the Config at path to h, and returns a Reloader to
+// keep applying it as it changes. Only WithLogger applies.
+//
+// The file's rules replace the Tier.Rule of every tier it lists, from the
+// start; the tiers it leaves out keep theirs.
+func NewReloader(path string, h *Hierarchy, opts ...Option) (*Reloader, error) {
+	r := &Reloader{path: path, h: h, logger: newOptions(opts).logger}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the file and, if it has changed since it was last read,
+// applies it. A file that fails to parse, check, or apply leaves the rules
+// as they were. It reports whether the rules changed.
+func (r *Reloader) Reload() (bool, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(data)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if sum == r.last {
+		return false, nil
+	}
+	r.last = sum
+	cfg, err := parseConfig(data)
+	if err == nil {
+		err = r.h.Apply(cfg)
+	}
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", r.path, err)
+	}
+	return true, nil
+}
+
+// Run reloads the file every interval until ctx is done, logging each
+// change and each file it rejects.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.Reload()
+		switch {
+		case err != nil:
+			r.logger.Printf("ratelimit: keeping the current rules: %v", err)
+		case changed:
+			r.logger.Printf("ratelimit: reloaded rules from %s", r.path)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+tiers:
+  user:
+    default: {rate: 2}
+    keys:
+      alice: {algorithm: gcra, rate: 4, burst: 4}
+  route:
+    keys:
+      "POST /orders": {algorithm: fixed_window, rate: 1, per: 1m}
+`
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig([]byte(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, _ := cfg.rules()
+	want := Rule{FixedWindow, Limit{Rate: 1, Per: time.Minute}}
+	if got := rules["route"].rules["POST /orders"]; got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if got := rules["user"].fallback; got == nil || got.Algorithm != TokenBucket {
+		t.Errorf("expected the algorithm to default to the token bucket, got %+v", got)
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		err  string
+	}{
+		{"JSON", `{"dry_run": true, "tiers": {"user": {"keys": {"bob": {"rate": 3, "per": "10s"}}}}}`, ""},
+		{"Unknown field", "tiers: {user: {default: {rat: 2}}}", "field rat not found"},
+		{"Unknown algorithm", "tiers: {user: {default: {algorithm: magic, rate: 2}}}", "unknown algorithm"},
+		{"Zero rate", "tiers: {user: {keys: {bob: {burst: 2}}}}", `key "bob"`},
+		{"Not a duration", "tiers: {user: {keys: {bob: {rate: 1, per: soon}}}}", "soon"},
+	}
+	for _, tt := range tests {
+		_, err := parseConfig([]byte(tt.in))
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestHierarchyApply(t *testing.T) {
+	clock := newFakeClock()
+	var logs bytes.Buffer
+	h, err := NewHierarchy([]Tier{{Name: "user"}, {Name: "route"}}, WithClock(clock), WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func(yaml string) {
+		t.Helper()
+		cfg, err := parseConfig([]byte(yaml))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Apply(cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(user, route string) int {
+		n := 0
+		for n < 100 {
+			if d, _ := h.AllowN(1, user, route); !d.Allowed {
+				break
+			}
+			n++
+		}
+		return n
+	}
+
+	if count("alice", "GET /") != 100 {
+		t.Error("expected no limits before any rules")
+	}
+	apply(testConfig)
+	if got := count("alice", "GET /"); got != 4 {
+		t.Errorf("expected alice's own rule, got %d", got)
+	}
+	if got := count("bob", "POST /orders"); got != 1 {
+		t.Errorf("expected the route's rule, got %d", got)
+	}
+
+	// Raising alice's rate keeps their bucket empty, but refills it faster.
+	apply(strings.Replace(testConfig, "rate: 4, burst: 4", "rate: 8, burst: 8", 1))
+	if got := count("alice", "GET /"); got != 0 {
+		t.Errorf("expected alice's used requests to carry over, got %d", got)
+	}
+	clock.Advance(time.Second / 4)
+	if got := count("alice", "GET /"); got != 2 {
+		t.Errorf("expected alice to refill at the new rate, got %d", got)
+	}
+
+	cfg, _ := parseConfig([]byte("tiers: {tenant: {default: {rate: 1}}}"))
+	if err := h.Apply(cfg); err == nil || !strings.Contains(err.Error(), `"tenant"`) {
+		t.Errorf("expected a tier the hierarchy lacks to be refused, got %v", err)
+	}
+	if got := count("carol", "GET /"); got != 2 {
+		t.Errorf("expected the rules unchanged by a refused config, got %d", got)
+	}
+
+	// Dry run lets everything through, but logs what it would refuse.
+	clock.Advance(time.Minute)
+	apply("dry_run: true\n" + testConfig)
+	if got := count("dave", "POST /orders"); got != 100 {
+		t.Errorf("expected nothing refused in a dry run, got %d", got)
+	}
+	if n := strings.Count(logs.String(), "would refuse"); n != 99 {
+		t.Errorf("expected 99 would-be refusals logged, got %d", n)
+	}
+	apply(testConfig)
+	if got := count("dave", "POST /orders"); got != 0 {
+		t.Errorf("expected the dry run to have counted what it let through, got %d", got)
+	}
+}
+
+func TestHierarchyApplyKeepsTierRules(t *testing.T) {
+	h, err := NewHierarchy([]Tier{
+		{Name: "user"},
+		{Name: "tenant", Rule: &Rule{TokenBucket, Limit{Rate: 3}}},
+	}, WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := parseConfig([]byte("tiers: {user: {default: {rate: 10}}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for n < 100 {
+		if d, _ := h.AllowN(1, "alice", "acme"); !d.Allowed {
+			break
+		}
+		n++
+	}
+	if n != 3 {
+		t.Errorf("expected a tier the file leaves out to keep its rule, got %d", n)
+	}
+
+	cfg, _ = parseConfig([]byte("tiers: {tenant: {keys: {acme: {rate: 5}}}}"))
+	if err := h.Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := h.AllowN(1, "bob", "initech"); !d.Allowed || d.Limit != 0 {
+		t.Errorf("expected a tier the file lists to lose its rule, got %+v", d)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe to log to from another goroutine.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.yaml")
+	write := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := NewHierarchy([]Tier{{Name: "user"}, {Name: "route"}}, WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(user string) int {
+		n := 0
+		for n < 100 {
+			if d, _ := h.AllowN(1, user, ""); !d.Allowed {
+				break
+			}
+			n++
+		}
+		return n
+	}
+
+	if _, err := NewReloader(path, h); err == nil {
+		t.Error("expected a missing file to be reported")
+	}
+	write(testConfig)
+	var logs syncBuffer
+	r, err := NewReloader(path, h, WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.Reload(); changed || err != nil {
+		t.Errorf("expected nothing to reload from an unchanged file, got %v, %v", changed, err)
+	}
+
+	write("tiers: {user: {default: {rate: -1}}}")
+	if _, err := r.Reload(); err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected an invalid file to be reported, got %v", err)
+	}
+	if got := count("bob"); got != 2 {
+		t.Errorf("expected the rules kept through an invalid file, got %d", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx, 5*time.Millisecond)
+		close(done)
+	}()
+	write(strings.Replace(testConfig, "default: {rate: 2}", "default: {rate: 3}", 1))
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(logs.String(), "reloaded") && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := count("carol"); got != 3 {
+		t.Errorf("expected the changed file applied while running, got %d", got)
+	}
+}
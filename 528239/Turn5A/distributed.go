@@ -0,0 +1,127 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DistributedLimiter enforces a limit per key across every instance of a
+// service, by keeping the limits' state in a shared Store. It is safe for
+// concurrent use.
+type DistributedLimiter struct {
+	store Store
+	limit Limit
+	opts  options
+
+	mu        sync.Mutex
+	leases    map[string]*lease
+	lastSweep time.Time
+}
+
+// lease is requests taken from the store ahead of time, to be handed out
+// locally.
+type lease struct {
+	left      int       // Requests not yet handed out
+	remaining int       // What the store had left when the lease was taken
+	expires   time.Time // When what's left is given up
+}
+
+// NewDistributedLimiter returns a DistributedLimiter that enforces l for
+// each key in store. WithClock and WithLease apply.
+func NewDistributedLimiter(store Store, l Limit, opts ...Option) (*DistributedLimiter, error) {
+	if store == nil {
+		return nil, errors.New("distributed limiter needs a store")
+	}
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	if o.leaseSize > l.burst() {
+		return nil, fmt.Errorf("lease of %d is more than the burst of %d", o.leaseSize, l.burst())
+	}
+	if o.leaseSize > 1 && o.leaseTTL <= 0 {
+		return nil, errors.New("lease needs a positive time to live")
+	}
+	return &DistributedLimiter{
+		store:     store,
+		limit:     l,
+		opts:      o,
+		leases:    make(map[string]*lease),
+		lastSweep: o.clock.Now(),
+	}, nil
+}
+
+// Allow reports whether one request for key may go ahead now, and counts it
+// if so.
+func (d *DistributedLimiter) Allow(ctx context.Context, key string) (bool, error) {
+	dec, err := d.AllowN(ctx, key, 1)
+	return dec.Allowed, err
+}
+
+// AllowN is Allow for n requests at once: either all of them go ahead or
+// none do. Requests served from a lease report what the store had
+// remaining when the lease was taken, less what the lease has handed out
+// since. With leasing, a refusal's RetryAfter may be for a whole lease
+// rather than for n requests.
+func (d *DistributedLimiter) AllowN(ctx context.Context, key string, n int) (Decision, error) {
+	if d.opts.leaseSize <= 1 || n <= 0 {
+		return d.store.Take(ctx, key, d.limit, n)
+	}
+	now := d.opts.clock.Now()
+	if dec, ok := d.fromLease(now, key, n); ok {
+		return dec, nil
+	}
+
+	// Lease enough for this request and then some, unless the store is too
+	// close to the limit for that, in which case take only what's asked, if
+	// that fits.
+	size := max(n, d.opts.leaseSize)
+	dec, err := d.store.Take(ctx, key, d.limit, size)
+	if err != nil || !dec.Allowed && (size == n || dec.Remaining < n) {
+		return dec, err
+	}
+	if !dec.Allowed {
+		return d.store.Take(ctx, key, d.limit, n)
+	}
+	if size > n {
+		d.addLease(now, key, size-n, dec.Remaining)
+	}
+	dec.Remaining += size - n
+	return dec, nil
+}
+
+// fromLease hands out n requests for key from its lease, if it has enough.
+func (d *DistributedLimiter) fromLease(now time.Time, key string, n int) (Decision, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.leases[key]
+	if !ok || !now.Before(l.expires) || l.left < n {
+		return Decision{}, false
+	}
+	l.left -= n
+	if l.left == 0 {
+		delete(d.leases, key)
+	}
+	return Decision{Allowed: true, Limit: d.limit.burst(), Remaining: l.left + l.remaining}, true
+}
+
+func (d *DistributedLimiter) addLease(now time.Time, key string, left, remaining int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// Leases of keys that have gone quiet are dropped as others are added,
+	// at most once per time to live.
+	if now.Sub(d.lastSweep) >= d.opts.leaseTTL {
+		d.lastSweep = now
+		for k, l := range d.leases {
+			if !now.Before(l.expires) {
+				delete(d.leases, k)
+			}
+		}
+	}
+	// Whatever is left of an older lease is given up, so that no request is
+	// handed out longer than ttl after it was taken.
+	d.leases[key] = &lease{left: left, remaining: remaining, expires: now.Add(d.opts.leaseTTL)}
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingStore counts the calls that reach a Store.
+type countingStore struct {
+	Store
+	calls atomic.Int64
+}
+
+func (s *countingStore) Take(ctx context.Context, key string, l Limit, n int) (Decision, error) {
+	s.calls.Add(1)
+	return s.Store.Take(ctx, key, l, n)
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(*testing.T, *FakeClock) Store{
+		"Memory": func(_ *testing.T, clock *FakeClock) Store { return NewMemoryStore(WithClock(clock)) },
+		"Redis": func(t *testing.T, clock *FakeClock) Store {
+			s := NewRedisStore(newFakeRedis(t, clock).addr(), "")
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			clock := newFakeClock()
+			s := newStore(t, clock)
+			ctx := context.Background()
+			l := Limit{Rate: 1, Burst: 5}
+			take := func(key string, n int) Decision {
+				t.Helper()
+				d, err := s.Take(ctx, key, l, n)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return d
+			}
+
+			for i := range 5 {
+				if d := take("a", 1); !d.Allowed || d.Remaining != 4-i || d.Limit != 5 {
+					t.Fatalf("request %d: expected allowed with %d remaining, got %+v", i+1, 4-i, d)
+				}
+			}
+			if d := take("a", 1); d.Allowed || d.RetryAfter != time.Second || d.Reset != 5*time.Second {
+				t.Errorf("expected a refusal for a second, reset in 5s, got %+v", d)
+			}
+			if d := take("b", 6); d.Allowed || d.RetryAfter != 0 {
+				t.Errorf("expected more than the burst never to fit, got %+v", d)
+			}
+			if d := take("b", 5); !d.Allowed {
+				t.Errorf("expected another key to have its own limit, got %+v", d)
+			}
+			clock.Advance(time.Second)
+			if d := take("a", 1); !d.Allowed || d.Remaining != 0 {
+				t.Errorf("expected one request a second later, got %+v", d)
+			}
+			if _, err := s.Take(ctx, "a", Limit{}, 1); err == nil {
+				t.Error("expected an invalid limit to be refused")
+			}
+
+			// Instances sharing the store never allow more than the limit
+			// between them.
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for i := range 4 {
+				d, err := NewDistributedLimiter(s, l)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for range 10 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if ok, err := d.Allow(ctx, "c"); err != nil {
+							t.Errorf("instance %d: %v", i, err)
+						} else if ok {
+							allowed.Add(1)
+						}
+					}()
+				}
+			}
+			wg.Wait()
+			if got := allowed.Load(); got != 5 {
+				t.Errorf("expected 5 requests allowed across instances, got %d", got)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreForgetsFullKeys(t *testing.T) {
+	clock := newFakeClock()
+	s := NewMemoryStore(WithClock(clock))
+	for i := range 100 {
+		s.Take(context.Background(), fmt.Sprint("user", i), Limit{Rate: 10}, 1)
+	}
+	if n := s.Len(); n != 100 {
+		t.Fatalf("expected 100 keys, got %d", n)
+	}
+	clock.Advance(time.Second)
+	s.Take(context.Background(), "user0", Limit{Rate: 10}, 0)
+	if n := s.Len(); n != 99 {
+		t.Errorf("expected a key back to full to be dropped, got %d", n)
+	}
+}
+
+func TestDistributedLimiterLeases(t *testing.T) {
+	clock := newFakeClock()
+	store := &countingStore{Store: NewMemoryStore(WithClock(clock))}
+	ctx := context.Background()
+	newInstance := func(l Limit) *DistributedLimiter {
+		d, err := NewDistributedLimiter(store, l, WithClock(clock), WithLease(10, time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	count := func(d *DistributedLimiter, key string, max int) int {
+		n := 0
+		for range max {
+			if ok, err := d.Allow(ctx, key); err != nil {
+				t.Fatal(err)
+			} else if ok {
+				n++
+			}
+		}
+		return n
+	}
+
+	l := Limit{Rate: 100, Burst: 100}
+	a, b := newInstance(l), newInstance(l)
+	if got := count(a, "user1", 100); got != 100 {
+		t.Errorf("expected the whole burst through one instance, got %d", got)
+	}
+	if got := store.calls.Load(); got != 10 {
+		t.Errorf("expected 10 round trips for 100 requests, got %d", got)
+	}
+	before := store.calls.Load()
+	if got := count(b, "user1", 10); got != 0 {
+		t.Errorf("expected the other instance to find the limit used up, got %d", got)
+	}
+	if got := store.calls.Load() - before; got != 10 {
+		t.Errorf("expected one round trip per refusal, got %d for 10", got)
+	}
+	clock.Advance(50 * time.Millisecond) // Five requests' worth
+	if got := count(b, "user1", 10); got != 5 {
+		t.Errorf("expected requests one at a time when a lease won't fit, got %d", got)
+	}
+	if d, _ := a.AllowN(ctx, "user2", 3); !d.Allowed || d.Remaining != 97 {
+		t.Errorf("expected a lease to count toward what remains, got %+v", d)
+	}
+
+	// Leases that go unused expire, undershooting the limit.
+	slow := Limit{Rate: 1, Burst: 20}
+	a, b = newInstance(slow), newInstance(slow)
+	if count(a, "user3", 1) != 1 || count(b, "user3", 1) != 1 {
+		t.Fatal("expected each instance to lease")
+	}
+	clock.Advance(2 * time.Second)
+	if got := count(a, "user3", 5) + count(b, "user3", 5); got != 2 {
+		t.Errorf("expected only what the store refilled once the leases expired, got %d", got)
+	}
+
+	if _, err := NewDistributedLimiter(store, slow, WithLease(30, time.Second)); err == nil {
+		t.Error("expected a lease larger than the burst to be refused")
+	}
+	if _, err := NewDistributedLimiter(nil, slow); err == nil {
+		t.Error("expected a store to be required")
+	}
+}
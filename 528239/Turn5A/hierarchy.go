@@ -0,0 +1,205 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Tier is one level of a Hierarchy, such as users, tenants, or the whole
+// service, with a rate limit and quotas for each key at that level.
+//
+// Rule is only the tier's starting rule: a Config that lists the tier, as
+// applied by Hierarchy.Apply or a Reloader, replaces it with the file's.
+// Tiers the file leaves out go back to it.
+type Tier struct {
+	Name   string
+	Rule   *Rule   // Nil for no rate limit
+	Quotas []Quota // None for no quotas
+}
+
+// Hierarchy enforces nested limits, such as a user's within their tenant's
+// within the service's: a request goes ahead only if every tier allows it,
+// and counts against none of them otherwise. It is safe for concurrent use.
+type Hierarchy struct {
+	tiers  []tier
+	logger *log.Logger
+
+	// Held for writing while rules change, so that every request sees
+	// either the old rules or the new in every tier.
+	mu     sync.RWMutex
+	dryRun bool // Log refusals, but let the requests go ahead
+}
+
+type tier struct {
+	name   string
+	rule   *Rule            // As built, for a Config that leaves the tier out
+	limits *UserRateLimiter // Keys without a rule have no rate limit
+	quotas *Quotas          // Nil for no quotas
+}
+
+// NewHierarchy returns a Hierarchy of tiers, outermost last. The options
+// apply to every tier's limiters and quotas.
+func NewHierarchy(tiers []Tier, opts ...Option) (*Hierarchy, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("hierarchy needs a tier")
+	}
+	h := &Hierarchy{logger: newOptions(opts).logger}
+	seen := make(map[string]bool)
+	for _, t := range tiers {
+		if seen[t.Name] {
+			return nil, fmt.Errorf("tier %q appears twice", t.Name)
+		}
+		seen[t.Name] = true
+		ht := tier{name: t.Name, limits: NewUserRateLimiter(opts...)}
+		if t.Rule != nil {
+			rule := *t.Rule
+			ht.rule = &rule
+			if err := ht.limits.SetDefaultLimit(t.Rule.Algorithm, t.Rule.Limit); err != nil {
+				return nil, fmt.Errorf("tier %q: %w", t.Name, err)
+			}
+		}
+		if len(t.Quotas) > 0 {
+			q, err := NewQuotas(t.Quotas, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("tier %q: %w", t.Name, err)
+			}
+			ht.quotas = q
+		}
+		h.tiers = append(h.tiers, ht)
+	}
+	return h, nil
+}
+
+// Quotas returns the named tier's quotas, for querying, saving, and
+// loading their usage, or nil if it has none.
+func (h *Hierarchy) Quotas(tier string) *Quotas {
+	for _, t := range h.tiers {
+		if t.name == tier {
+			return t.quotas
+		}
+	}
+	return nil
+}
+
+// SetRules replaces the named tier's rate limits: one for each key in
+// rules, and fallback for the rest, or none if nil. See
+// UserRateLimiter.SetRules.
+func (h *Hierarchy) SetRules(tier string, rules map[string]Rule, fallback *Rule) error {
+	for _, t := range h.tiers {
+		if t.name == tier {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			return t.limits.SetRules(rules, fallback)
+		}
+	}
+	return fmt.Errorf("no tier %q", tier)
+}
+
+// AllowN lets n requests go ahead if they fit every tier's limits, with
+// keys giving the request's key in each tier, in order. It returns the
+// Decision of the tier that refused them, and that tier's name; or if none
+// did, those of the tier with the fewest requests remaining. Requests with
+// the wrong number of keys are refused.
+//
+// In dry-run mode, requests that would be refused are logged and let
+// through, without counting against any tier.
+func (h *Hierarchy) AllowN(n int, keys ...string) (Decision, string) {
+	if len(keys) != len(h.tiers) {
+		return Decision{}, ""
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	d, tier := h.allowN(n, keys)
+	if !d.Allowed && h.dryRun {
+		h.logger.Printf("ratelimit: dry run: would refuse %d requests for %q in tier %q", n, keys, tier)
+		d.Allowed, d.RetryAfter = true, 0
+	}
+	return d, tier
+}
+
+// allowN is AllowN, enforcing. Callers hold h.mu.
+func (h *Hierarchy) allowN(n int, keys []string) (Decision, string) {
+	// Every limit involved is locked, in tier order so that concurrent
+	// calls can't deadlock, and held until the requests are counted in all
+	// of them or none.
+	lims := make([]*limiter, len(h.tiers))
+	nows := make([]time.Time, len(h.tiers))
+	quotaNows := make([]time.Time, len(h.tiers))
+	for i, t := range h.tiers {
+		// Keys without a rule have no limiter, and no rate limit.
+		lims[i], _ = t.limits.limiter(keys[i])
+	}
+	for i, t := range h.tiers {
+		if t.quotas != nil {
+			t.quotas.mu.Lock()
+			defer t.quotas.mu.Unlock()
+			quotaNows[i] = t.quotas.clock.Now()
+		}
+		if lims[i] != nil {
+			lims[i].mu.Lock()
+			defer lims[i].mu.Unlock()
+			nows[i] = lims[i].now()
+		}
+	}
+
+	// Refused by the tier that would keep the requests waiting longest, or
+	// by one they never fit.
+	refused := -1
+	var refusal Decision
+	for i, t := range h.tiers {
+		if t.quotas != nil {
+			if d := t.quotas.check(keys[i], quotaNows[i], n); !d.Allowed && (refused < 0 || worse(d, refusal)) {
+				refused, refusal = i, d
+			}
+		}
+		if lims[i] != nil {
+			if ok, retryAfter := fits(lims[i].alg, nows[i], n); !ok {
+				d := status(lims[i].alg, nows[i])
+				d.RetryAfter = retryAfter
+				if refused < 0 || worse(d, refusal) {
+					refused, refusal = i, d
+				}
+			}
+		}
+	}
+	if refused >= 0 {
+		return refusal, h.tiers[refused].name
+	}
+
+	tightest := -1
+	var best Decision
+	for i, t := range h.tiers {
+		var ds []Decision
+		if t.quotas != nil {
+			t.quotas.take(keys[i], quotaNows[i], n)
+			ds = append(ds, t.quotas.check(keys[i], quotaNows[i], 0))
+		}
+		if lims[i] != nil {
+			allow(lims[i].alg, nows[i], n)
+			ds = append(ds, status(lims[i].alg, nows[i]))
+		}
+		for _, d := range ds {
+			if tightest < 0 || d.Remaining < best.Remaining {
+				tightest, best = i, d
+			}
+		}
+	}
+	if tightest < 0 {
+		// No tier limits these keys.
+		return Decision{Allowed: true}, ""
+	}
+	best.Allowed, best.RetryAfter = true, 0
+	return best, h.tiers[tightest].name
+}
+
+// worse reports whether refusal a keeps a request out longer than b: for
+// good if it never fits.
+func worse(a, b Decision) bool {
+	if b.RetryAfter == 0 {
+		return false
+	}
+	return a.RetryAfter == 0 || a.RetryAfter > b.RetryAfter
+}
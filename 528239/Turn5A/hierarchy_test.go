@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHierarchy(t *testing.T) {
+	clock := newFakeClock()
+	h, err := NewHierarchy([]Tier{
+		{Name: "user", Rule: &Rule{TokenBucket, Limit{Rate: 2}}, Quotas: []Quota{{Limit: 5, Period: Day}}},
+		{Name: "tenant", Rule: &Rule{TokenBucket, Limit{Rate: 3}}},
+		{Name: "global", Rule: &Rule{GCRA, Limit{Rate: 100}}},
+	}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := func(user, tenant string) (Decision, string) { return h.AllowN(1, user, tenant, "") }
+
+	for range 2 {
+		if d, _ := allow("alice", "acme"); !d.Allowed {
+			t.Fatalf("expected alice allowed, got %+v", d)
+		}
+	}
+	if d, tier := allow("alice", "acme"); d.Allowed || tier != "user" {
+		t.Errorf("expected alice refused by their own limit, got %+v from %q", d, tier)
+	}
+	if d, tier := allow("bob", "acme"); !d.Allowed || tier != "tenant" || d.Remaining != 0 {
+		t.Errorf("expected bob allowed with their tenant's limit the tightest, got %+v from %q", d, tier)
+	}
+	if d, tier := allow("bob", "acme"); d.Allowed || tier != "tenant" || d.RetryAfter.Round(time.Millisecond) != 333*time.Millisecond {
+		t.Errorf("expected bob refused by their tenant, got %+v from %q", d, tier)
+	}
+	// Nothing was counted against bob directly.
+	if d := h.tiers[0].limits.AllowN("bob", 0); d.Remaining != 1 {
+		t.Errorf("expected bob's own limit untouched by the refusal, got %d remaining", d.Remaining)
+	}
+	if u := h.Quotas("user").Usage("bob"); u[0].Used != 1 {
+		t.Errorf("expected bob's quota untouched by the refusal, got %d used", u[0].Used)
+	}
+	if d, _ := allow("carol", "globex"); !d.Allowed {
+		t.Errorf("expected another tenant unaffected, got %+v", d)
+	}
+
+	// The quota outlasts the rate limit.
+	for range 5 {
+		clock.Advance(time.Second)
+		allow("dave", "initech")
+	}
+	if d, tier := allow("dave", "initech"); d.Allowed || tier != "user" || d.Limit != 5 {
+		t.Errorf("expected dave refused by their daily quota, got %+v from %q", d, tier)
+	}
+	if d, _ := h.AllowN(1, "alice"); d.Allowed {
+		t.Error("expected a request without a key for every tier to be refused")
+	}
+	if h.Quotas("tenant") != nil {
+		t.Error("expected no quotas for a tier without any")
+	}
+
+	if _, err := NewHierarchy([]Tier{{Name: "user"}, {Name: "user"}}); err == nil {
+		t.Error("expected repeated tiers to be refused")
+	}
+	if _, err := NewHierarchy([]Tier{{Name: "user", Rule: &Rule{TokenBucket, Limit{}}}}); err == nil {
+		t.Error("expected an invalid limit to be refused")
+	}
+}
+
+func TestHierarchyIsAtomic(t *testing.T) {
+	clock := newFakeClock()
+	h, err := NewHierarchy([]Tier{
+		{Name: "user", Rule: &Rule{SlidingLog, Limit{Rate: 3}}, Quotas: []Quota{{Limit: 2, Period: Hour}}},
+		{Name: "tenant", Rule: &Rule{FixedWindow, Limit{Rate: 10}}},
+		{Name: "global", Rule: &Rule{TokenBucket, Limit{Rate: 15}}},
+	}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := range 200 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if d, _ := h.AllowN(1, fmt.Sprint("user", i%20), fmt.Sprint("tenant", i%3), ""); d.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 15 {
+		t.Fatalf("expected exactly the global limit allowed, got %d", got)
+	}
+	used := int64(0)
+	for i := range 20 {
+		used += h.Quotas("user").Usage(fmt.Sprint("user", i))[0].Used
+	}
+	if used != 15 {
+		t.Errorf("expected quotas to count only the requests allowed, got %d", used)
+	}
+}
@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MiddlewareConfig says how a Middleware limits requests.
+type MiddlewareConfig struct {
+	Key KeyFunc // Required
+	// Routes are limits by path, optionally after a method, as in
+	// "POST /orders". A path ending in "/" covers everything under it, and
+	// the most specific route applies.
+	Routes map[string]Rule
+	// Default is the limit for requests no route covers; nil leaves them
+	// unlimited.
+	Default     *Rule
+	ExemptKeys  []string // Never limited, such as internal services
+	ExemptPaths []string // Never limited, such as health checks; matched like Routes
+}
+
+// Middleware limits HTTP requests per key, with a separate set of limits for
+// each route.
+type Middleware struct {
+	key         KeyFunc
+	routes      []route // Most specific first
+	exemptKeys  map[string]bool
+	exemptPaths []pattern
+}
+
+type route struct {
+	pattern
+	limits *UserRateLimiter
+}
+
+// pattern matches requests by path and, optionally, method.
+type pattern struct {
+	method, path string
+}
+
+func parsePattern(s string) pattern {
+	if method, path, ok := strings.Cut(s, " "); ok {
+		return pattern{method: method, path: strings.TrimSpace(path)}
+	}
+	return pattern{path: s}
+}
+
+func (p pattern) matches(r *http.Request) bool {
+	if p.method != "" && p.method != r.Method {
+		return false
+	}
+	return p.path == r.URL.Path || strings.HasSuffix(p.path, "/") && strings.HasPrefix(r.URL.Path, p.path)
+}
+
+// NewMiddleware returns a Middleware for cfg. The options apply to every
+// limiter it keeps.
+func NewMiddleware(cfg MiddlewareConfig, opts ...Option) (*Middleware, error) {
+	if cfg.Key == nil {
+		return nil, errors.New("middleware needs a key function")
+	}
+	m := &Middleware{key: cfg.Key, exemptKeys: make(map[string]bool)}
+	addRoute := func(p pattern, rule Rule) error {
+		limits := NewUserRateLimiter(opts...)
+		if err := limits.SetDefaultLimit(rule.Algorithm, rule.Limit); err != nil {
+			return err
+		}
+		m.routes = append(m.routes, route{pattern: p, limits: limits})
+		return nil
+	}
+	for s, rule := range cfg.Routes {
+		if err := addRoute(parsePattern(s), rule); err != nil {
+			return nil, fmt.Errorf("route %q: %w", s, err)
+		}
+	}
+	// Longer paths first, and a method before none.
+	slices.SortFunc(m.routes, func(a, b route) int {
+		if c := cmp.Compare(len(b.path), len(a.path)); c != 0 {
+			return c
+		}
+		return cmp.Compare(len(b.method), len(a.method))
+	})
+	if cfg.Default != nil {
+		// Added after sorting, so it comes after every route, even "/".
+		if err := addRoute(pattern{path: "/"}, *cfg.Default); err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+	}
+	for _, key := range cfg.ExemptKeys {
+		m.exemptKeys[key] = true
+	}
+	for _, s := range cfg.ExemptPaths {
+		m.exemptPaths = append(m.exemptPaths, parsePattern(s))
+	}
+	return m, nil
+}
+
+// Handler limits the requests to next. Refused requests get 429 Too Many
+// Requests, and requests without a key 400 Bad Request; limited requests,
+// allowed or not, get rate-limit headers.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.ContainsFunc(m.exemptPaths, func(p pattern) bool { return p.matches(r) }) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		i := slices.IndexFunc(m.routes, func(rt route) bool { return rt.matches(r) })
+		if i < 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, ok := m.key(r)
+		if !ok {
+			http.Error(w, "missing rate-limit key", http.StatusBadRequest)
+			return
+		}
+		if m.exemptKeys[key] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		d := m.routes[i].limits.AllowN(key, 1)
+		SetHeaders(w.Header(), d)
+		if !d.Allowed {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetHeaders describes d in the X-RateLimit-Limit, X-RateLimit-Remaining,
+// and X-RateLimit-Reset response headers, adding Retry-After if the request
+// was refused but may be retried.
+func SetHeaders(h http.Header, d Decision) {
+	h.Set("X-RateLimit-Limit", strconv.Itoa(d.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	h.Set("X-RateLimit-Reset", strconv.Itoa(wholeSeconds(d.Reset)))
+	if !d.Allowed && d.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(wholeSeconds(d.RetryAfter)))
+	}
+}
+
+// wholeSeconds rounds d up, since clients that wait less would be refused.
+func wholeSeconds(d time.Duration) int { return int((d + time.Second - 1) / time.Second) }
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetHeaders(t *testing.T) {
+	clock := newFakeClock()
+	ul := NewUserRateLimiter(WithClock(clock))
+	if err := ul.SetRateLimit("user1", TokenBucket, Limit{Rate: 1, Per: 10 * time.Second, Burst: 2}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		n       int
+		headers map[string]string
+	}{
+		{"Allowed", 1, map[string]string{"X-RateLimit-Limit": "2", "X-RateLimit-Remaining": "1", "X-RateLimit-Reset": "10", "Retry-After": ""}},
+		{"Refused", 2, map[string]string{"X-RateLimit-Remaining": "1", "X-RateLimit-Reset": "10", "Retry-After": "10"}},
+		{"Last one", 1, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "20", "Retry-After": ""}},
+		{"Never fits", 3, map[string]string{"X-RateLimit-Remaining": "0", "Retry-After": ""}},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		SetHeaders(h, ul.AllowN("user1", tt.n))
+		for name, want := range tt.headers {
+			if got := h.Get(name); got != want {
+				t.Errorf("%s: expected %s %q, got %q", tt.name, name, want, got)
+			}
+		}
+	}
+
+	if d := ul.AllowN("unknown", 1); d.Allowed || d.RetryAfter != 0 {
+		t.Errorf("expected an unknown user to be refused for good, got %+v", d)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := newFakeClock()
+	m, err := NewMiddleware(MiddlewareConfig{
+		Key: HeaderKey("X-User-ID"),
+		Routes: map[string]Rule{
+			"/api/":            {TokenBucket, Limit{Rate: 3}},
+			"POST /api/orders": {FixedWindow, Limit{Rate: 1, Per: time.Minute}},
+		},
+		Default:     &Rule{SlidingLog, Limit{Rate: 2}},
+		ExemptKeys:  []string{"internal"},
+		ExemptPaths: []string{"/healthz"},
+	}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	do := func(method, path, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		if user != "" {
+			r.Header.Set("X-User-ID", user)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+	// count makes requests until one is refused, and returns how many weren't.
+	count := func(method, path, user string) int {
+		n := 0
+		for n < 100 && do(method, path, user).Code == http.StatusOK {
+			n++
+		}
+		return n
+	}
+
+	if got := count("POST", "/api/orders", "alice"); got != 1 {
+		t.Errorf("expected 1 order a minute, got %d", got)
+	}
+	if got := count("GET", "/api/orders", "alice"); got != 3 {
+		t.Errorf("expected reading orders to fall under /api/, got %d", got)
+	}
+	if got := count("GET", "/api/search", "bob"); got != 3 {
+		t.Errorf("expected each user to have their own limit, got %d", got)
+	}
+	if got := count("GET", "/index.html", "alice"); got != 2 {
+		t.Errorf("expected the default limit elsewhere, got %d", got)
+	}
+	if count("GET", "/healthz", "alice") != 100 || count("GET", "/api/search", "internal") != 100 {
+		t.Error("expected exempt paths and keys to be unlimited")
+	}
+
+	w := do("POST", "/api/orders", "alice")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" || w.Header().Get("X-RateLimit-Limit") != "1" {
+		t.Errorf("expected a 429 with rate-limit headers, got %d %v", w.Code, w.Header())
+	}
+	if w := do("GET", "/api/search", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a request without a user to be rejected, got %d", w.Code)
+	}
+	clock.Advance(400 * time.Millisecond) // A token and a bit
+	if w := do("GET", "/api/search", "bob"); w.Code != http.StatusOK || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Errorf("expected a refilled request with headers, got %d %v", w.Code, w.Header())
+	}
+
+	if _, err := NewMiddleware(MiddlewareConfig{Key: APIKey(), Routes: map[string]Rule{"/": {TokenBucket, Limit{}}}}); err == nil {
+		t.Error("expected an invalid route limit to be refused")
+	}
+	if _, err := NewMiddleware(MiddlewareConfig{}); err == nil {
+		t.Error("expected a key function to be required")
+	}
+}
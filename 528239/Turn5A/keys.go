@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// KeyFunc picks the key a request is limited under, such as its user or
+// client address. It reports false if the request has no key.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// HeaderKey keys requests on the value of a header, such as X-User-ID.
+func HeaderKey(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		v := strings.TrimSpace(r.Header.Get(name))
+		return v, v != ""
+	}
+}
+
+// APIKey keys requests on their API key, from the X-API-Key header or else
+// a bearer token.
+func APIKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+			return key, true
+		}
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		token = strings.TrimSpace(token)
+		return token, ok && strings.EqualFold(scheme, "Bearer") && token != ""
+	}
+}
+
+// ClientIP keys requests on the address of the client that sent them. If it
+// came through one of the trusted proxies, the client is the nearest address
+// in X-Forwarded-For that isn't a trusted proxy; addresses beyond it were
+// supplied by the client and may be forged.
+func ClientIP(trustedProxies ...netip.Prefix) KeyFunc {
+	trusted := func(ip netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return func(r *http.Request) (string, bool) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil {
+			return "", false
+		}
+		ip = ip.Unmap()
+		// Each proxy appends the address it received the request from, so
+		// read from the right for as long as the addresses are trusted.
+		hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+		for i := len(hops) - 1; i >= 0 && trusted(ip); i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			ip = hop.Unmap()
+		}
+		return ip.String(), true
+	}
+}
+
+// RouteKey keys requests on their method and path.
+func RouteKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		return r.Method + " " + r.URL.Path, true
+	}
+}
+
+// CompositeKey keys requests on all of keys together, such as the route and
+// the user, so that each combination is limited separately. A request has
+// no key if any part is missing.
+func CompositeKey(keys ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		parts := make([]string, len(keys))
+		for i, key := range keys {
+			part, ok := key(r)
+			if !ok {
+				return "", false
+			}
+			parts[i] = part
+		}
+		return strings.Join(parts, "|"), true
+	}
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	key := ClientIP(netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8"))
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+		ok         bool
+	}{
+		{"Direct client", "203.0.113.5:4000", nil, "203.0.113.5", true},
+		{"Untrusted peer can't forge its address", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5", true},
+		{"Behind a trusted proxy", "10.0.0.1:4000", []string{"198.51.100.1"}, "198.51.100.1", true},
+		{"Through a chain of proxies", "10.0.0.1:4000", []string{"6.6.6.6, 198.51.100.1", "10.0.0.2"}, "198.51.100.1", true},
+		{"Trusted proxy without the header", "10.0.0.1:4000", nil, "10.0.0.1", true},
+		{"Garbage in the header", "10.0.0.1:4000", []string{"198.51.100.1, bogus"}, "10.0.0.1", true},
+		{"IPv6 proxy", "[fd00::1]:4000", []string{"2001:db8::7"}, "2001:db8::7", true},
+		{"IPv4 mapped in IPv6", "[::ffff:203.0.113.5]:4000", nil, "203.0.113.5", true},
+		{"Unparseable peer", "somewhere", nil, "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, v := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if got, ok := key(r); got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expected %q (%v), got %q (%v)", tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestKeyFuncs(t *testing.T) {
+	r := httptest.NewRequest("POST", "/orders?id=1", nil)
+	r.Header.Set("X-User-ID", " alice ")
+	r.Header.Set("Authorization", "Bearer s3cret")
+
+	if got, ok := HeaderKey("X-User-ID")(r); got != "alice" || !ok {
+		t.Errorf("expected the header's value, got %q", got)
+	}
+	if _, ok := HeaderKey("X-Tenant")(r); ok {
+		t.Error("expected no key without the header")
+	}
+	if got, _ := APIKey()(r); got != "s3cret" {
+		t.Errorf("expected the bearer token, got %q", got)
+	}
+	r.Header.Set("X-API-Key", "k1")
+	if got, _ := APIKey()(r); got != "k1" {
+		t.Errorf("expected X-API-Key to come first, got %q", got)
+	}
+	if got, _ := CompositeKey(RouteKey(), HeaderKey("X-User-ID"))(r); got != "POST /orders|alice" {
+		t.Errorf("expected route and user together, got %q", got)
+	}
+	if _, ok := CompositeKey(RouteKey(), HeaderKey("X-Tenant"))(r); ok {
+		t.Error("expected no key when a part is missing")
+	}
+}
@@ -0,0 +1,374 @@
+// Package ratelimit decides whether requests may go ahead under a rate limit,
+// with a choice of algorithms and a separate limit per user.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Algorithm names a rate-limiting algorithm.
+type Algorithm string
+
+const (
+	TokenBucket   Algorithm = "token_bucket"   // Refills steadily and allows bursts of up to Burst
+	LeakyBucket   Algorithm = "leaky_bucket"   // Lets requests through evenly spaced, never in a burst
+	FixedWindow   Algorithm = "fixed_window"   // Rate requests per window, with windows aligned to the clock
+	SlidingLog    Algorithm = "sliding_log"    // Rate requests in any window, exactly, by remembering each one
+	SlidingWindow Algorithm = "sliding_window" // Approximates the sliding log from the counts of two windows
+	GCRA          Algorithm = "gcra"           // Token bucket behavior kept in a single timestamp
+)
+
+var (
+	ErrCannotReserve = errors.New("more requests than the limit allows at once")
+	ErrDeadline      = errors.New("waiting would outlast the context's deadline")
+)
+
+// Limit is how many requests are allowed over what period, and how many may
+// arrive at once.
+type Limit struct {
+	Rate  int           // Requests per Per
+	Per   time.Duration // Zero means one second
+	Burst int           // Most requests at once for the bucket algorithms and GCRA; zero means Rate
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 {
+		return fmt.Errorf("rate must be positive, got %d", l.Rate)
+	}
+	if l.Per < 0 || l.Burst < 0 {
+		return errors.New("period and burst can't be negative")
+	}
+	if l.interval() == 0 {
+		return fmt.Errorf("rate %d is more than one a nanosecond over %v", l.Rate, l.period())
+	}
+	return nil
+}
+
+func (l Limit) period() time.Duration {
+	if l.Per == 0 {
+		return time.Second
+	}
+	return l.Per
+}
+
+func (l Limit) burst() int {
+	if l.Burst == 0 {
+		return l.Rate
+	}
+	return l.Burst
+}
+
+// interval is each request's share of the period.
+func (l Limit) interval() time.Duration { return l.period() / time.Duration(l.Rate) }
+
+// Limiter decides whether requests may go ahead. It is safe for concurrent use.
+type Limiter interface {
+	// Allow reports whether one request may go ahead now, and counts it if so.
+	Allow() bool
+	// AllowN is Allow for n requests at once: either all of them go ahead or
+	// none do. The Decision says how the limit stands either way.
+	AllowN(n int) Decision
+	// Reserve counts n requests as going ahead at the earliest time they fit,
+	// which may be later than now.
+	Reserve(n int) *Reservation
+	// Wait blocks until a request may go ahead or ctx is done. It returns
+	// ErrDeadline at once if ctx's deadline, read on the limiter's clock,
+	// would pass first.
+	Wait(ctx context.Context) error
+}
+
+// Decision is a limiter's answer to a request, with what a caller needs to
+// tell its own clients, such as in rate-limit response headers.
+type Decision struct {
+	Allowed    bool
+	Limit      int           // Most requests the limiter allows at once
+	Remaining  int           // Requests that could still go ahead now
+	RetryAfter time.Duration // If refused, how long until the same request would fit; zero if it never will
+	Reset      time.Duration // How long until the limiter is back to full, if no more requests come
+	Degraded   bool          // Decided by a failure policy, without the shared backend
+}
+
+// Reservation holds a place under the limit for requests that may go ahead
+// at a set time.
+type Reservation struct {
+	lim      *limiter
+	ok       bool
+	n        int
+	at       time.Time // When the requests may go ahead
+	canceled bool      // Guarded by lim.mu
+}
+
+// OK reports whether the limiter could reserve the requests at all. It
+// can't if there are more than the limit allows at once.
+func (r *Reservation) OK() bool { return r.ok }
+
+// Delay is how long from now until the reserved requests may go ahead, or
+// zero if they already may. It means nothing unless OK.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return 0
+	}
+	return max(0, r.at.Sub(r.lim.clock.Now()))
+}
+
+// Cancel gives the reserved place back, as long as its time hasn't come.
+func (r *Reservation) Cancel() {
+	if !r.ok {
+		return
+	}
+	r.lim.mu.Lock()
+	defer r.lim.mu.Unlock()
+	if !r.canceled {
+		r.canceled = true
+		r.lim.alg.cancel(r.lim.now(), r.at, r.n)
+	}
+}
+
+// Option configures the limiters and stores in this package.
+type Option func(*options)
+
+type options struct {
+	clock       Clock
+	idleTimeout time.Duration
+	maxKeys     int
+	leaseSize   int
+	leaseTTL    time.Duration
+	logger      *log.Logger
+}
+
+// WithClock has limiters read the time from c instead of the system clock.
+func WithClock(c Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// WithIdleTimeout has a UserRateLimiter forget the state of a key unused for
+// d, once its limit is back to full. The default is a minute.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) { o.idleTimeout = d }
+}
+
+// WithMaxKeys caps how many keys a UserRateLimiter keeps state for, exactly:
+// each key added past the cap forgets the least recently used key of all.
+// A forgotten key starts afresh if it comes back, even if its limit wasn't
+// full, so clients spreading requests over more keys than the cap can get
+// past their limits; set it well above the keys active within a period.
+// With a cap, requests for all keys share one lock. The default is no cap.
+func WithMaxKeys(n int) Option {
+	return func(o *options) { o.maxKeys = n }
+}
+
+// WithLease has a DistributedLimiter take size requests from its Store at a
+// time and hand them out locally for up to ttl, cutting round trips to the
+// store. The store counts leased requests when they are taken, not when
+// they go ahead, so each instance can let up to size requests through
+// beyond the burst; those it doesn't use within ttl are lost, undershooting
+// the limit instead. The default is no leasing.
+func WithLease(size int, ttl time.Duration) Option {
+	return func(o *options) { o.leaseSize, o.leaseTTL = size, ttl }
+}
+
+// WithLogger has a Hierarchy log to l instead of the standard logger.
+func WithLogger(l *log.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+func newOptions(opts []Option) options {
+	o := options{clock: systemClock{}, idleTimeout: time.Minute, logger: log.Default()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// algorithm is one limiter's state. Its methods are called with the
+// limiter's lock held, and never with a time earlier than the previous call.
+type algorithm interface {
+	// earliest returns the earliest time, from now on, that n more requests
+	// would fit under the limit, or false if they never would.
+	earliest(now time.Time, n int) (at time.Time, ok bool)
+	// take counts n requests as going ahead at at, as returned by earliest.
+	take(now, at time.Time, n int)
+	// cancel gives back n requests taken for at, if at is still to come.
+	cancel(now, at time.Time, n int)
+	// full returns when the limit will be back to full if no more requests come.
+	full(now time.Time) time.Time
+	// capacity is the most requests allowed at once.
+	capacity() int
+	// retune changes the limit to l, keeping as much of the state as still
+	// means something, or returns false if none does.
+	retune(now time.Time, l Limit) bool
+}
+
+// limiter serializes access to an algorithm and keeps its time from going
+// backwards.
+type limiter struct {
+	mu    sync.Mutex
+	clock Clock
+	last  time.Time
+	alg   algorithm
+}
+
+// New returns a Limiter that enforces l using algorithm a.
+func New(a Algorithm, l Limit, opts ...Option) (Limiter, error) {
+	lim, err := newLimiter(a, l, newOptions(opts))
+	if err != nil {
+		return nil, err
+	}
+	return lim, nil
+}
+
+func newLimiter(a Algorithm, l Limit, o options) (*limiter, error) {
+	if err := l.validate(); err != nil {
+		return nil, err
+	}
+	now := o.clock.Now()
+	var alg algorithm
+	switch a {
+	case TokenBucket:
+		alg = newTokenBucket(l, now)
+	case LeakyBucket:
+		alg = newLeakyBucket(l, now)
+	case FixedWindow:
+		alg = newFixedWindow(l, now)
+	case SlidingLog:
+		alg = newSlidingLog(l)
+	case SlidingWindow:
+		alg = newSlidingWindow(l, now)
+	case GCRA:
+		alg = newGCRA(l, now)
+	default:
+		return nil, fmt.Errorf("unknown algorithm %q", a)
+	}
+	return &limiter{clock: o.clock, last: now, alg: alg}, nil
+}
+
+func (l *limiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	allowed, _ := allow(l.alg, l.now(), 1)
+	return allowed
+}
+
+func (l *limiter) AllowN(n int) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return decide(l.alg, l.now(), n)
+}
+
+// decide takes n requests from alg if they fit now, and says how the limit
+// stands.
+func decide(alg algorithm, now time.Time, n int) Decision {
+	allowed, retryAfter := allow(alg, now, n)
+	d := status(alg, now)
+	d.Allowed, d.RetryAfter = allowed, retryAfter
+	return d
+}
+
+// status says how alg's limit stands now, without taking any requests.
+func status(alg algorithm, now time.Time) Decision {
+	d := Decision{Limit: alg.capacity()}
+	// The largest number of requests that would still fit now.
+	d.Remaining = sort.Search(d.Limit, func(i int) bool {
+		at, ok := alg.earliest(now, i+1)
+		return !ok || at.After(now)
+	})
+	d.Reset = alg.full(now).Sub(now)
+	return d
+}
+
+// allow takes n requests from alg if they fit now. If they don't, it returns
+// how long until they would, or zero if never.
+func allow(alg algorithm, now time.Time, n int) (bool, time.Duration) {
+	ok, retryAfter := fits(alg, now, n)
+	if ok && n > 0 {
+		alg.take(now, now, n)
+	}
+	return ok, retryAfter
+}
+
+// fits is allow without taking the requests.
+func fits(alg algorithm, now time.Time, n int) (bool, time.Duration) {
+	if n <= 0 {
+		return true, 0
+	}
+	at, ok := alg.earliest(now, n)
+	switch {
+	case !ok:
+		return false, 0
+	case at.After(now):
+		return false, at.Sub(now)
+	}
+	return true, 0
+}
+
+func (l *limiter) Reserve(n int) *Reservation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	r := &Reservation{lim: l, n: n, at: now, ok: n <= 0}
+	if n <= 0 {
+		return r
+	}
+	if at, ok := l.alg.earliest(now, n); ok {
+		l.alg.take(now, at, n)
+		r.ok, r.at = true, at
+	}
+	return r
+}
+
+func (l *limiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r := l.Reserve(1)
+	if !r.OK() {
+		return ErrCannotReserve
+	}
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Sub(l.clock.Now()) < delay {
+		r.Cancel()
+		return ErrDeadline
+	}
+	select {
+	case <-l.clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	}
+}
+
+// retune changes the limit to l in place, or returns false if the
+// algorithm can't keep its state under it.
+func (l *limiter) retune(lim Limit) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.alg.retune(l.now(), lim)
+}
+
+// idle reports whether the limiter is back to full, and so no different
+// from a fresh one.
+func (l *limiter) idle() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	return !l.alg.full(now).After(now)
+}
+
+// now reads the clock, holding time still if it has gone backwards.
+// Callers hold l.mu.
+func (l *limiter) now() time.Time {
+	if now := l.clock.Now(); now.After(l.last) {
+		l.last = now
+	}
+	return l.last
+}
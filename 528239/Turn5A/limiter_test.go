@@ -0,0 +1,348 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newFakeClock starts on a whole second, so the window algorithms' windows
+// start with the test, and ahead of the real clock, so context deadlines set
+// from it haven't passed.
+func newFakeClock() *FakeClock {
+	return NewFakeClock(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// waitForWaiters blocks until n goroutines are waiting on the clock.
+func waitForWaiters(t *testing.T, clock *FakeClock, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); clock.Waiters() < n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiters, have %d", n, clock.Waiters())
+		}
+	}
+}
+
+var algorithms = []Algorithm{TokenBucket, LeakyBucket, FixedWindow, SlidingLog, SlidingWindow, GCRA}
+
+func newTestLimiter(t *testing.T, a Algorithm, l Limit) (Limiter, *FakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	lim, err := New(a, l, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lim, clock
+}
+
+// drain calls Allow until it is refused, and returns how many were allowed.
+func drain(lim Limiter) int {
+	n := 0
+	for n < 1000 && lim.Allow() {
+		n++
+	}
+	return n
+}
+
+// TestLimiterConformance holds every algorithm to the behavior the Limiter
+// interface promises, whatever its approach.
+func TestLimiterConformance(t *testing.T) {
+	limit := Limit{Rate: 10, Per: time.Second}
+	for _, a := range algorithms {
+		t.Run(string(a), func(t *testing.T) {
+			t.Run("Exhausts at an instant", func(t *testing.T) {
+				lim, _ := newTestLimiter(t, a, limit)
+				if n := drain(lim); n < 1 || n > limit.Rate {
+					t.Errorf("expected between 1 and %d requests at once, got %d", limit.Rate, n)
+				}
+				if lim.Allow() || lim.AllowN(1).Allowed {
+					t.Error("expected requests to stay refused while time stands still")
+				}
+			})
+
+			t.Run("Recovers when idle", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				drain(lim)
+				clock.Advance(2 * limit.Per)
+				if !lim.Allow() {
+					t.Error("expected a request to be allowed after two idle periods")
+				}
+			})
+
+			t.Run("Ignores the clock going backwards", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				drain(lim)
+				clock.Advance(-time.Hour)
+				if lim.Allow() {
+					t.Error("expected no refill from time going backwards")
+				}
+				clock.Advance(time.Hour + 2*limit.Per)
+				if !lim.Allow() {
+					t.Error("expected a request to be allowed once the clock catches up")
+				}
+			})
+
+			t.Run("Refuses more than it could ever hold", func(t *testing.T) {
+				lim, _ := newTestLimiter(t, a, limit)
+				if lim.AllowN(limit.Rate + 1).Allowed {
+					t.Error("expected AllowN beyond the limit to be refused")
+				}
+				if !lim.Allow() {
+					t.Error("expected a refused AllowN to take nothing")
+				}
+			})
+
+			t.Run("Sustains the rate", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				var allowed []time.Time
+				for range 2000 { // A request every 5ms for 10 seconds
+					if lim.Allow() {
+						allowed = append(allowed, clock.Now())
+					}
+					clock.Advance(5 * time.Millisecond)
+				}
+				// Over 10 periods, the rate plus at most one period's burst.
+				if n := len(allowed); n < 9*limit.Rate || n > 11*limit.Rate {
+					t.Errorf("expected about %d requests over 10 seconds, got %d", 10*limit.Rate, n)
+				}
+				// No period, wherever it starts, sees more than twice the rate.
+				for i := range allowed {
+					j := i
+					for j < len(allowed) && allowed[j].Sub(allowed[i]) < limit.Per {
+						j++
+					}
+					if j-i > 2*limit.Rate {
+						t.Fatalf("%d requests allowed in the period from %s", j-i, allowed[i])
+					}
+				}
+			})
+
+			t.Run("Counts concurrent requests once", func(t *testing.T) {
+				lim, _ := newTestLimiter(t, a, limit)
+				reference, _ := newTestLimiter(t, a, limit)
+				want := drain(reference)
+				var wg sync.WaitGroup
+				var mu sync.Mutex
+				got := 0
+				for range 8 {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for range 50 {
+							if lim.Allow() {
+								mu.Lock()
+								got++
+								mu.Unlock()
+							}
+						}
+					}()
+				}
+				wg.Wait()
+				if got != want {
+					t.Errorf("expected %d of 400 concurrent requests allowed, as in sequence; got %d", want, got)
+				}
+			})
+
+			t.Run("Reports how the limit stands", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				d := lim.AllowN(1)
+				if !d.Allowed || d.Limit != limit.Rate || d.RetryAfter != 0 {
+					t.Fatalf("expected the first request allowed out of %d, got %+v", limit.Rate, d)
+				}
+				if n := drain(lim); n != d.Remaining {
+					t.Errorf("expected %d remaining requests, %d were allowed", d.Remaining, n)
+				}
+
+				d = lim.AllowN(1)
+				if d.Allowed || d.Remaining != 0 || d.RetryAfter <= 0 || d.Reset < d.RetryAfter {
+					t.Fatalf("expected a refusal with a time to retry, got %+v", d)
+				}
+				clock.Advance(d.RetryAfter - time.Nanosecond)
+				if lim.Allow() {
+					t.Error("expected a request to be refused just before RetryAfter")
+				}
+				clock.Advance(time.Nanosecond)
+				if !lim.Allow() {
+					t.Error("expected a request to be allowed at RetryAfter")
+				}
+
+				d = lim.AllowN(1)
+				clock.Advance(d.Reset)
+				if !lim.AllowN(d.Limit).Allowed {
+					t.Errorf("expected the limit to be back to full after Reset (%s)", d.Reset)
+				}
+			})
+
+			// Reservations and waits are measured against a twin limiter that
+			// sees the same requests, apart from the one under test.
+			t.Run("Reservations hold their place", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				twin, twinClock := newTestLimiter(t, a, limit)
+				drain(lim)
+				drain(twin)
+				if lim.Reserve(limit.Rate + 1).OK() {
+					t.Error("expected a reservation beyond the limit to fail")
+				}
+				r := lim.Reserve(1)
+				delay := r.Delay()
+				if !r.OK() || delay <= 0 {
+					t.Fatalf("expected a reservation for later, got a delay of %s", delay)
+				}
+				clock.Advance(delay)
+				twinClock.Advance(delay)
+				if r.Delay() != 0 {
+					t.Errorf("expected the reservation to be due, still %s to go", r.Delay())
+				}
+				r.Cancel() // Too late to give anything back
+				if got, want := drain(lim), drain(twin)-1; got != want {
+					t.Errorf("expected the reservation to take a place, leaving %d; got %d", want, got)
+				}
+
+				r = lim.Reserve(1)
+				delay = r.Delay()
+				r.Cancel()
+				r.Cancel()
+				clock.Advance(delay)
+				twinClock.Advance(delay)
+				if got, want := drain(lim), drain(twin); got != want {
+					t.Errorf("expected a canceled reservation to give its place back, leaving %d; got %d", want, got)
+				}
+			})
+
+			t.Run("Wait blocks until allowed", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				twin, twinClock := newTestLimiter(t, a, limit)
+				drain(lim)
+				drain(twin)
+				retry := lim.AllowN(1).RetryAfter
+				done := make(chan error, 1)
+				go func() { done <- lim.Wait(context.Background()) }()
+				waitForWaiters(t, clock, 1)
+				clock.Advance(retry - time.Nanosecond)
+				select {
+				case err := <-done:
+					t.Fatalf("expected Wait to block, returned %v", err)
+				case <-time.After(10 * time.Millisecond):
+				}
+				clock.Advance(time.Nanosecond)
+				if err := <-done; err != nil {
+					t.Fatal(err)
+				}
+				twinClock.Advance(retry)
+				if got, want := drain(lim), drain(twin)-1; got != want {
+					t.Errorf("expected Wait to take a place, leaving %d; got %d", want, got)
+				}
+			})
+
+			t.Run("Wait gives up with its context", func(t *testing.T) {
+				lim, clock := newTestLimiter(t, a, limit)
+				drain(lim)
+				retry := lim.AllowN(1).RetryAfter
+				ctx, cancel := context.WithCancel(context.Background())
+				done := make(chan error, 1)
+				go func() { done <- lim.Wait(ctx) }()
+				waitForWaiters(t, clock, 1)
+				cancel()
+				if err := <-done; !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected context.Canceled, got %v", err)
+				}
+
+				// The deadline is read on the limiter's clock.
+				short, cancel := context.WithDeadline(context.Background(), clock.Now().Add(retry-time.Nanosecond))
+				defer cancel()
+				if err := lim.Wait(short); !errors.Is(err, ErrDeadline) {
+					t.Fatalf("expected ErrDeadline, got %v", err)
+				}
+				clock.Advance(retry)
+				if !lim.Allow() {
+					t.Error("expected abandoned waits to give their place back")
+				}
+			})
+		})
+	}
+}
+
+// step is a request made after advancing the clock.
+type step struct {
+	advance time.Duration
+	n       int
+	allowed bool
+}
+
+func TestAlgorithms(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		limit     Limit
+		steps     []step
+	}{
+		{"Token bucket bursts beyond its rate", TokenBucket, Limit{Rate: 2, Burst: 5}, []step{
+			{0, 5, true}, {0, 1, false},
+			{500 * time.Millisecond, 1, true}, {0, 1, false},
+			{2 * time.Second, 4, true}, {0, 1, false},
+			{10 * time.Second, 6, false}, {0, 5, true},
+		}},
+		{"GCRA matches the token bucket", GCRA, Limit{Rate: 2, Burst: 5}, []step{
+			{0, 5, true}, {0, 1, false},
+			{500 * time.Millisecond, 1, true}, {0, 1, false},
+			{2 * time.Second, 4, true}, {0, 1, false},
+			{10 * time.Second, 6, false}, {0, 5, true},
+		}},
+		{"Leaky bucket spaces requests evenly", LeakyBucket, Limit{Rate: 2}, []step{
+			{0, 1, true}, {0, 1, false},
+			{250 * time.Millisecond, 1, false}, {250 * time.Millisecond, 1, true},
+			{time.Second, 3, false}, {0, 2, true},
+			{500 * time.Millisecond, 1, false}, {500 * time.Millisecond, 1, true},
+		}},
+		{"Fixed window allows a burst across its boundary", FixedWindow, Limit{Rate: 3}, []step{
+			{900 * time.Millisecond, 3, true}, {0, 1, false},
+			{100 * time.Millisecond, 3, true}, {0, 1, false},
+			{900 * time.Millisecond, 1, false}, {100 * time.Millisecond, 1, true},
+		}},
+		{"Sliding log frees each request a period after it", SlidingLog, Limit{Rate: 3}, []step{
+			{0, 1, true}, {500 * time.Millisecond, 2, true}, {0, 1, false},
+			{499 * time.Millisecond, 1, false}, {time.Millisecond, 1, true}, {0, 1, false},
+			{500 * time.Millisecond, 2, true}, {0, 1, false},
+		}},
+		{"Sliding window weights the previous window", SlidingWindow, Limit{Rate: 10}, []step{
+			{0, 10, true},
+			{time.Second, 1, false}, // The whole previous window still counts
+			{500 * time.Millisecond, 5, true}, {0, 1, false},
+			{time.Second, 7, true}, {0, 1, false}, // Half of the 5 from the last window
+			{2 * time.Second, 10, true}, // An idle window forgets everything
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lim, clock := newTestLimiter(t, tt.algorithm, tt.limit)
+			for i, s := range tt.steps {
+				clock.Advance(s.advance)
+				if got := lim.AllowN(s.n).Allowed; got != s.allowed {
+					t.Fatalf("step %d: expected AllowN(%d) = %v, got %v", i, s.n, s.allowed, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewValidates(t *testing.T) {
+	tests := []struct {
+		algorithm Algorithm
+		limit     Limit
+	}{
+		{"round_robin", Limit{Rate: 1}},
+		{TokenBucket, Limit{}},
+		{TokenBucket, Limit{Rate: -1}},
+		{GCRA, Limit{Rate: 1, Burst: -1}},
+		{FixedWindow, Limit{Rate: 1, Per: -time.Second}},
+		{GCRA, Limit{Rate: 2e9}},
+		{SlidingLog, Limit{Rate: 2, Per: time.Nanosecond}},
+	}
+	for _, tt := range tests {
+		if _, err := New(tt.algorithm, tt.limit); err == nil {
+			t.Errorf("expected an error for %s with %+v", tt.algorithm, tt.limit)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package ratelimit
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Period is the calendar window a quota counts over, in UTC.
+type Period string
+
+const (
+	Hour  Period = "hour"
+	Day   Period = "day"
+	Month Period = "month"
+)
+
+// window returns the start and end of p's window around t.
+func (p Period) window(t time.Time) (start, end time.Time) {
+	t = t.UTC()
+	switch p {
+	case Hour:
+		start = t.Truncate(time.Hour)
+		return start, start.Add(time.Hour)
+	case Day:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 0, 1)
+	default:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+// Quota is how many requests are allowed in each calendar window. Unlike a
+// Limit, it doesn't refill as time passes, but all at once as a new window
+// starts.
+type Quota struct {
+	Limit  int64
+	Period Period
+}
+
+// Usage is how a key stands against one of its quotas.
+type Usage struct {
+	Period    Period
+	Limit     int64
+	Used      int64
+	Remaining int64
+	Resets    time.Time // When the current window ends
+}
+
+// Quotas counts requests per key against a set of quotas. The counts live
+// in memory; Save and Load carry them across restarts. It is safe for
+// concurrent use.
+type Quotas struct {
+	quotas []Quota
+	clock  Clock
+
+	mu     sync.Mutex
+	counts map[string]*list.Element // Of *keyCounts in byUse
+	byUse  list.List                // Most recently counted first
+}
+
+// keyCounts is a key's counts, aligned with its Quotas' quotas.
+type keyCounts struct {
+	key    string
+	counts []quotaCount
+}
+
+// quotaCount is the requests counted in the window starting at Start.
+type quotaCount struct {
+	Start time.Time `json:"start"`
+	Used  int64     `json:"used"`
+}
+
+// NewQuotas returns Quotas enforcing every one of quotas, at most one per
+// period. Only WithClock applies.
+func NewQuotas(quotas []Quota, opts ...Option) (*Quotas, error) {
+	seen := make(map[Period]bool)
+	for _, quota := range quotas {
+		switch {
+		case quota.Period != Hour && quota.Period != Day && quota.Period != Month:
+			return nil, fmt.Errorf("unknown quota period %q", quota.Period)
+		case seen[quota.Period]:
+			return nil, fmt.Errorf("more than one quota per %s", quota.Period)
+		case quota.Limit <= 0:
+			return nil, fmt.Errorf("quota per %s must be positive, got %d", quota.Period, quota.Limit)
+		}
+		seen[quota.Period] = true
+	}
+	return &Quotas{
+		quotas: quotas,
+		clock:  newOptions(opts).clock,
+		counts: make(map[string]*list.Element),
+	}, nil
+}
+
+// AllowN counts n requests for key if they fit in every quota. The Decision
+// is for whichever quota refused them, or else the one with the fewest
+// requests remaining.
+func (q *Quotas) AllowN(key string, n int) Decision {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	if d := q.check(key, now, n); !d.Allowed {
+		return d
+	}
+	q.take(key, now, n)
+	return q.check(key, now, 0)
+}
+
+// Usage returns how key stands against each quota.
+func (q *Quotas) Usage(key string) []Usage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	counts := q.current(key, now)
+	usage := make([]Usage, len(q.quotas))
+	for i, quota := range q.quotas {
+		_, end := quota.Period.window(now)
+		usage[i] = Usage{
+			Period:    quota.Period,
+			Limit:     quota.Limit,
+			Used:      counts[i].Used,
+			Remaining: max(0, quota.Limit-counts[i].Used),
+			Resets:    end,
+		}
+	}
+	return usage
+}
+
+// current returns key's counts for the windows around now, without storing
+// them. Callers hold q.mu.
+func (q *Quotas) current(key string, now time.Time) []quotaCount {
+	counts := make([]quotaCount, len(q.quotas))
+	var stored []quotaCount
+	if el, ok := q.counts[key]; ok {
+		stored = el.Value.(*keyCounts).counts
+	}
+	for i, quota := range q.quotas {
+		counts[i].Start, _ = quota.Period.window(now)
+		if stored != nil && stored[i].Start.Equal(counts[i].Start) {
+			counts[i].Used = stored[i].Used
+		}
+	}
+	return counts
+}
+
+// store keeps counts as key's, most recently counted. Callers hold q.mu.
+func (q *Quotas) store(key string, counts []quotaCount) {
+	if el, ok := q.counts[key]; ok {
+		el.Value.(*keyCounts).counts = counts
+		q.byUse.MoveToFront(el)
+		return
+	}
+	q.counts[key] = q.byUse.PushFront(&keyCounts{key: key, counts: counts})
+}
+
+// check says whether n more requests for key fit at now, with the Decision
+// of the quota that refuses them or else the tightest. Callers hold q.mu.
+func (q *Quotas) check(key string, now time.Time, n int) Decision {
+	var tightest Decision
+	for i, c := range q.current(key, now) {
+		quota := q.quotas[i]
+		_, end := quota.Period.window(now)
+		d := Decision{
+			Allowed:   c.Used+int64(n) <= quota.Limit,
+			Limit:     int(quota.Limit),
+			Remaining: int(max(0, quota.Limit-c.Used)),
+		}
+		if c.Used > 0 {
+			d.Reset = end.Sub(now)
+		}
+		if !d.Allowed {
+			if int64(n) <= quota.Limit {
+				d.RetryAfter = end.Sub(now)
+			}
+			return d
+		}
+		if i == 0 || d.Remaining < tightest.Remaining {
+			tightest = d
+		}
+	}
+	tightest.Allowed = true
+	return tightest
+}
+
+// take counts n requests for key at now. Callers hold q.mu and have checked
+// that they fit.
+func (q *Quotas) take(key string, now time.Time, n int) {
+	if n <= 0 || len(q.quotas) == 0 {
+		return
+	}
+	counts := q.current(key, now)
+	for i := range counts {
+		counts[i].Used += int64(n)
+	}
+	q.store(key, counts)
+	// Forgetting a couple of stale keys each time keeps memory in check,
+	// whether or not the counts are saved, without ever sweeping them all
+	// at once on a request's time.
+	q.sweep(now, 2)
+}
+
+// sweep forgets up to most of the keys whose windows have all passed.
+// Windows pass in the order the keys were last counted, so those are the
+// least recently counted. Callers hold q.mu.
+func (q *Quotas) sweep(now time.Time, most int) {
+	for ; most > 0; most-- {
+		el := q.byUse.Back()
+		if el == nil {
+			return
+		}
+		kc := el.Value.(*keyCounts)
+		for _, c := range q.current(kc.key, now) {
+			if c.Used > 0 {
+				return
+			}
+		}
+		q.byUse.Remove(el)
+		delete(q.counts, kc.key)
+	}
+}
+
+// Save writes the counts for the current windows to the file at path,
+// replacing it whole so that a crash never leaves it half written. Callers
+// save as often as they can afford to lose counts from.
+func (q *Quotas) Save(path string) error {
+	q.mu.Lock()
+	saved := make(map[string]map[Period]quotaCount)
+	now := q.clock.Now()
+	q.sweep(now, len(q.counts))
+	for key := range q.counts {
+		byPeriod := make(map[Period]quotaCount)
+		for i, c := range q.current(key, now) {
+			if c.Used > 0 {
+				byPeriod[q.quotas[i].Period] = c
+			}
+		}
+		saved[key] = byPeriod
+	}
+	q.mu.Unlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	// On disk before it takes the old file's place.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Load adds the counts saved at path for windows that are still current,
+// replacing any counted for the same keys. A missing file counts as empty.
+func (q *Quotas) Load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string]map[Period]quotaCount
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("quotas %s: %w", path, err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := q.clock.Now()
+	for key, byPeriod := range saved {
+		counts := q.current(key, now)
+		used := false
+		for i, quota := range q.quotas {
+			if c, ok := byPeriod[quota.Period]; ok && c.Start.Equal(counts[i].Start) {
+				counts[i].Used = c.Used
+				used = used || c.Used > 0
+			}
+		}
+		if used {
+			q.store(key, counts)
+		}
+	}
+	return nil
+}
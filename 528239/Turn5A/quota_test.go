@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPeriodWindows(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	tests := []struct {
+		period     Period
+		t          string
+		start, end string
+	}{
+		{Hour, "2024-03-10T14:59:59Z", "2024-03-10T14:00:00Z", "2024-03-10T15:00:00Z"},
+		{Day, "2024-12-31T23:00:00Z", "2024-12-31T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{Day, "2024-03-10T01:00:00+05:00", "2024-03-09T00:00:00Z", "2024-03-10T00:00:00Z"},
+		{Month, "2024-01-31T12:00:00Z", "2024-01-01T00:00:00Z", "2024-02-01T00:00:00Z"},
+		{Month, "2024-02-29T23:59:59Z", "2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		start, end := tt.period.window(at(tt.t))
+		if !start.Equal(at(tt.start)) || !end.Equal(at(tt.end)) {
+			t.Errorf("%s around %s: expected %s to %s, got %s to %s", tt.period, tt.t, tt.start, tt.end, start, end)
+		}
+	}
+}
+
+func TestQuotas(t *testing.T) {
+	clock := newFakeClock()
+	q, err := NewQuotas([]Quota{{Limit: 10, Period: Hour}, {Limit: 15, Period: Day}}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(key string) int {
+		n := 0
+		for n < 100 && q.AllowN(key, 1).Allowed {
+			n++
+		}
+		return n
+	}
+
+	if got := count("alice"); got != 10 {
+		t.Errorf("expected 10 requests in the first hour, got %d", got)
+	}
+	clock.Advance(30 * time.Minute)
+	if d := q.AllowN("alice", 1); d.Allowed || d.RetryAfter != 30*time.Minute || d.Limit != 10 {
+		t.Errorf("expected a refusal until the next hour, got %+v", d)
+	}
+	clock.Advance(30 * time.Minute)
+	if got := count("alice"); got != 5 {
+		t.Errorf("expected the day's quota to cut the second hour short, got %d", got)
+	}
+	if d := q.AllowN("alice", 1); d.Allowed || d.Limit != 15 || d.RetryAfter != 23*time.Hour {
+		t.Errorf("expected a refusal until tomorrow, got %+v", d)
+	}
+	if d := q.AllowN("bob", 11); d.Allowed || d.RetryAfter != 0 {
+		t.Errorf("expected more than a quota never to fit, got %+v", d)
+	}
+
+	usage := q.Usage("alice")
+	want := []Usage{
+		{Period: Hour, Limit: 10, Used: 5, Remaining: 5, Resets: clock.Now().Add(time.Hour)},
+		{Period: Day, Limit: 15, Used: 15, Remaining: 0, Resets: clock.Now().Add(23 * time.Hour)},
+	}
+	for i := range want {
+		if usage[i] != want[i] {
+			t.Errorf("expected usage %+v, got %+v", want[i], usage[i])
+		}
+	}
+
+	for _, quotas := range [][]Quota{
+		{{Limit: 1, Period: "week"}},
+		{{Limit: 0, Period: Day}},
+		{{Limit: 1, Period: Day}, {Limit: 2, Period: Day}},
+	} {
+		if _, err := NewQuotas(quotas); err == nil {
+			t.Errorf("expected %v to be refused", quotas)
+		}
+	}
+}
+
+func TestQuotasForgetStaleKeys(t *testing.T) {
+	clock := newFakeClock()
+	q, err := NewQuotas([]Quota{{Limit: 10, Period: Hour}, {Limit: 50, Period: Day}}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 1000 {
+		q.AllowN(fmt.Sprint("user", i), 1)
+	}
+	clock.Advance(time.Hour)
+	q.AllowN("bob", 1)
+	if n := len(q.counts); n != 1001 {
+		t.Errorf("expected keys kept while their day's counts stand, got %d", n)
+	}
+
+	// Without a Save, each count forgets a couple of the keys whose windows
+	// have passed, oldest first.
+	clock.Advance(24 * time.Hour)
+	q.AllowN("alice", 1)
+	if n := len(q.counts); n != 1000 {
+		t.Errorf("expected two stale keys forgotten, got %d kept", n)
+	}
+	for i := range 500 {
+		q.AllowN(fmt.Sprint("new", i), 1)
+	}
+	if n := len(q.counts); n != 501 {
+		t.Errorf("expected only the new keys kept, got %d", n)
+	}
+}
+
+func TestQuotasPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	clock := newFakeClock()
+	quotas := []Quota{{Limit: 100, Period: Day}, {Limit: 1000, Period: Month}}
+	q, _ := NewQuotas(quotas, WithClock(clock))
+	if err := q.Load(path); err != nil {
+		t.Errorf("expected a missing file to load as empty, got %v", err)
+	}
+	q.AllowN("alice", 30)
+	q.AllowN("bob", 5)
+	if err := q.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart later the same day picks up where it left off.
+	clock.Advance(time.Hour)
+	restarted, _ := NewQuotas(quotas, WithClock(clock))
+	if err := restarted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if u := restarted.Usage("alice"); u[0].Used != 30 || u[1].Used != 30 {
+		t.Errorf("expected alice's usage restored, got %+v", u)
+	}
+
+	// A restart the next day keeps only the month's count.
+	clock.Advance(24 * time.Hour)
+	restarted, _ = NewQuotas(quotas, WithClock(clock))
+	if err := restarted.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if u := restarted.Usage("bob"); u[0].Used != 0 || u[1].Used != 5 {
+		t.Errorf("expected only the current windows restored, got %+v", u)
+	}
+
+	// Keys whose windows have all passed are dropped as the counts are saved.
+	clock.Advance(31 * 24 * time.Hour)
+	if err := q.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{}" {
+		t.Errorf("expected nothing left to save, got %s", data)
+	}
+	if err := os.WriteFile(path, []byte("not json"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Load(path); err == nil {
+		t.Error("expected a corrupt file to be reported")
+	}
+}
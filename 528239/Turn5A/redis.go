@@ -0,0 +1,272 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// takeScript is GCRA for RedisStore.Take, run on the server so that the
+// check and the decrement are one atomic step. Times are in microseconds
+// from the server's own clock, so that instances' clocks needn't agree.
+//
+// KEYS[1] holds the key's theoretical arrival time; ARGV is the interval,
+// the tolerance, and n. It returns whether the requests were allowed, how
+// many more would fit, the retry-after, and the time until reset.
+const takeScript = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval, tolerance, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local tat = math.max(tonumber(redis.call('GET', KEYS[1]) or 0), now)
+local cost = n * interval
+local allowed, retry = 0, 0
+if cost > tolerance then
+  -- Never fits.
+elseif tat + cost - tolerance > now then
+  retry = tat + cost - tolerance - now
+else
+  allowed = 1
+  if cost > 0 then
+    tat = tat + cost
+    redis.call('SET', KEYS[1], string.format('%.0f', tat), 'PX', math.ceil((tat - now) / 1000))
+  end
+end
+return {allowed, math.floor((tolerance - (tat - now)) / interval), retry, tat - now}
+`
+
+var takeScriptSHA = func() string {
+	sum := sha1.Sum([]byte(takeScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// maxIdleConns is how many connections a RedisStore keeps open between
+// calls.
+const maxIdleConns = 16
+
+// RedisStore is a Store on a Redis server, or anything else that speaks its
+// protocol and runs its Lua scripts. It is safe for concurrent use.
+type RedisStore struct {
+	addr   string
+	prefix string
+	dialer net.Dialer
+
+	mu     sync.Mutex
+	idle   []*redisConn
+	closed bool
+}
+
+// NewRedisStore returns a RedisStore for the server at addr, keeping each
+// key's state under prefix+key. It connects as it needs to.
+func NewRedisStore(addr, prefix string) *RedisStore {
+	return &RedisStore{addr: addr, prefix: prefix}
+}
+
+// Take takes n requests from key's limit on the server, bounded by ctx.
+// Limits must allow no more than a million requests a second, since the
+// server keeps time in microseconds.
+func (s *RedisStore) Take(ctx context.Context, key string, l Limit, n int) (Decision, error) {
+	if err := l.validate(); err != nil {
+		return Decision{}, err
+	}
+	interval := l.interval().Microseconds()
+	if interval == 0 {
+		return Decision{}, errors.New("redis store can't keep time finer than a microsecond")
+	}
+	args := []string{"1", s.prefix + key,
+		strconv.FormatInt(interval, 10),
+		strconv.FormatInt(int64(l.burst())*interval, 10),
+		strconv.Itoa(n),
+	}
+	reply, err := s.do(ctx, append([]string{"EVALSHA", takeScriptSHA}, args...)...)
+	var rerr redisError
+	if errors.As(err, &rerr) && strings.HasPrefix(string(rerr), "NOSCRIPT") {
+		// The server hasn't seen the script yet; EVAL sends it in full,
+		// and the server caches it for next time.
+		reply, err = s.do(ctx, append([]string{"EVAL", takeScript}, args...)...)
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+	v, ok := reply.([]any)
+	if !ok || len(v) != 4 {
+		return Decision{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	var us [4]int64
+	for i := range v {
+		if us[i], ok = v[i].(int64); !ok {
+			return Decision{}, fmt.Errorf("redis: unexpected reply %v", reply)
+		}
+	}
+	return Decision{
+		Allowed:    us[0] == 1,
+		Limit:      l.burst(),
+		Remaining:  int(us[1]),
+		RetryAfter: time.Duration(us[2]) * time.Microsecond,
+		Reset:      time.Duration(us[3]) * time.Microsecond,
+	}, nil
+}
+
+// Close closes the store's connections. Calls still under way finish, but
+// no more may be made.
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for _, c := range s.idle {
+		err = errors.Join(err, c.Close())
+	}
+	s.idle = nil
+	return err
+}
+
+// do sends a command and returns its reply, with the server's error replies
+// as a redisError.
+func (s *RedisStore) do(ctx context.Context, args ...string) (any, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+	c.SetDeadline(deadline)
+	// Canceling ctx cuts the call short by moving the deadline up.
+	stop := context.AfterFunc(ctx, func() { c.SetDeadline(time.Unix(1, 0)) })
+	reply, err := c.do(args)
+	stopped := stop()
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// The connection can reach ctx's deadline a moment before ctx does.
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() && !deadline.IsZero() {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	if stopped {
+		s.release(c)
+	} else {
+		// The deadline may have moved; the connection's state is unknown.
+		c.Close()
+	}
+	return reply, err
+}
+
+// conn returns an idle connection, or a new one.
+func (s *RedisStore) conn(ctx context.Context) (*redisConn, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("redis store is closed")
+	}
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+	nc, err := s.dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &redisConn{Conn: nc, r: bufio.NewReader(nc)}, nil
+}
+
+func (s *RedisStore) release(c *redisConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(s.idle) >= maxIdleConns {
+		c.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+// redisError is an error reply from the server.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// redisConn is a connection speaking the Redis protocol, RESP.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *redisConn) do(args []string) (any, error) {
+	if _, err := c.Write(appendCommand(nil, args)); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+// appendCommand appends args as a RESP array of bulk strings.
+func appendCommand(b []byte, args []string) []byte {
+	b = fmt.Appendf(b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		b = fmt.Appendf(b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return b
+}
+
+// readReply reads one RESP value: a string, an int64, a []any, or nil.
+// Error replies are returned as a redisError; within an array, they are
+// its elements.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i], err = readReply(r)
+			var rerr redisError
+			if errors.As(err, &rerr) {
+				items[i], err = rerr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
@@ -0,0 +1,233 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a stand-in Redis server on a local port. It runs takeScript
+// as Go, timed by a fake clock, for EVAL of the script's text and, once it
+// has seen that, EVALSHA of its hash.
+type fakeRedis struct {
+	ln    net.Listener
+	clock *FakeClock
+
+	mu     sync.Mutex
+	tats   map[string]int64 // Microseconds
+	loaded bool
+	calls  map[string]int
+	stall  bool // Read commands but never answer them
+	conns  []net.Conn
+}
+
+func newFakeRedis(t *testing.T, clock *FakeClock) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{ln: ln, clock: clock, tats: make(map[string]int64), calls: make(map[string]int)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns = append(f.conns, conn)
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(f.close)
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) close() {
+	f.ln.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeRedis) count(cmd string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[cmd]
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		v, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := v.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		reply := f.exec(args)
+		if reply == "" {
+			continue
+		}
+		if _, err := conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs a command and returns its reply, or nothing if stalled.
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stall {
+		return ""
+	}
+	if len(args) == 0 {
+		return "-ERR empty command\r\n"
+	}
+	cmd := strings.ToUpper(args[0])
+	f.calls[cmd]++
+	switch {
+	case cmd == "PING":
+		return "+PONG\r\n"
+	case cmd == "EVAL" && args[1] == takeScript:
+		f.loaded = true
+	case cmd == "EVALSHA" && args[1] == takeScriptSHA:
+		if !f.loaded {
+			return "-NOSCRIPT No matching script. Please use EVAL.\r\n"
+		}
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+	}
+	if len(args) != 7 || args[2] != "1" {
+		return "-ERR wrong number of arguments\r\n"
+	}
+	var nums [3]int64
+	for i, s := range args[4:] {
+		var err error
+		if nums[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+			return "-ERR value is not an integer\r\n"
+		}
+	}
+	return f.take(args[3], nums[0], nums[1], nums[2])
+}
+
+// take is takeScript.
+func (f *fakeRedis) take(key string, interval, tolerance, n int64) string {
+	now := f.clock.Now().UnixMicro()
+	tat := max(f.tats[key], now)
+	cost := n * interval
+	var allowed, retry int64
+	switch {
+	case cost > tolerance:
+	case tat+cost-tolerance > now:
+		retry = tat + cost - tolerance - now
+	default:
+		allowed = 1
+		tat += cost
+		f.tats[key] = tat
+	}
+	return fmt.Sprintf("*4\r\n:%d\r\n:%d\r\n:%d\r\n:%d\r\n", allowed, (tolerance-(tat-now))/interval, retry, tat-now)
+}
+
+func TestRedisStoreLoadsScript(t *testing.T) {
+	f := newFakeRedis(t, newFakeClock())
+	s := NewRedisStore(f.addr(), "rl:")
+	defer s.Close()
+	for range 3 {
+		if _, err := s.Take(context.Background(), "user1", Limit{Rate: 10}, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if evals, shas := f.count("EVAL"), f.count("EVALSHA"); evals != 1 || shas != 3 {
+		t.Errorf("expected the script sent once and then run by its hash, got %d EVAL and %d EVALSHA", evals, shas)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.tats["rl:user1"]; !ok {
+		t.Error("expected the key under the store's prefix")
+	}
+}
+
+func TestRedisStoreFailures(t *testing.T) {
+	f := newFakeRedis(t, newFakeClock())
+	s := NewRedisStore(f.addr(), "")
+	defer s.Close()
+	l := Limit{Rate: 10}
+
+	f.mu.Lock()
+	f.stall = true
+	f.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := s.Take(ctx, "user1", l, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a stalled server to run out the context, got %v", err)
+	}
+	f.mu.Lock()
+	f.stall = false
+	f.mu.Unlock()
+	if d, err := s.Take(context.Background(), "user1", l, 1); err != nil || !d.Allowed {
+		t.Errorf("expected the store to recover on a new connection, got %+v, %v", d, err)
+	}
+
+	if _, err := s.Take(context.Background(), "user1", Limit{Rate: 2_000_000}, 1); err == nil {
+		t.Error("expected a limit finer than a microsecond to be refused")
+	}
+	f.close()
+	if _, err := s.Take(context.Background(), "user1", l, 1); err == nil {
+		t.Error("expected an error with the server gone")
+	}
+	s.Close()
+	if _, err := s.Take(context.Background(), "user1", l, 1); err == nil {
+		t.Error("expected an error from a closed store")
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		in   string
+		want any
+		err  string
+	}{
+		{"+OK\r\n", "OK", ""},
+		{":-42\r\n", int64(-42), ""},
+		{"$5\r\nhe\r\no\r\n", "he\r\no", ""},
+		{"$-1\r\n", nil, ""},
+		{"*3\r\n:1\r\n$1\r\nx\r\n-ERR inner\r\n", []any{int64(1), "x", redisError("ERR inner")}, ""},
+		{"-NOSCRIPT missing\r\n", nil, "redis: NOSCRIPT missing"},
+		{"?what\r\n", nil, "unknown reply type"},
+		{"+no carriage return\n", nil, "malformed"},
+	}
+	for _, tt := range tests {
+		got, err := readReply(bufio.NewReader(strings.NewReader(tt.in)))
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: expected an error containing %q, got %v", tt.in, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %#v, got %#v (%v)", tt.in, tt.want, got, err)
+		}
+	}
+
+	cmd := string(appendCommand(nil, []string{"GET", "a b"}))
+	if cmd != "*2\r\n$3\r\nGET\r\n$3\r\na b\r\n" {
+		t.Errorf("expected a RESP array of bulk strings, got %q", cmd)
+	}
+}
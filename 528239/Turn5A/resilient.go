@@ -0,0 +1,189 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// FailurePolicy says what a ResilientStore decides when its backend can't.
+type FailurePolicy int
+
+const (
+	FailOpen   FailurePolicy = iota // Allow every request, unlimited
+	FailClosed                      // Refuse every request
+	FailLocal                       // Enforce each instance's share of the limit locally
+)
+
+// ResilienceConfig says how a ResilientStore guards its backend.
+type ResilienceConfig struct {
+	Policy  FailurePolicy
+	Timeout time.Duration // For each backend call; zero means 100ms
+	// BreakerFailures is how many failed calls in a row open the circuit
+	// breaker, which then decides by policy without calling the backend for
+	// BreakerCooldown before letting a single trial call through. Zero means
+	// 5 failures and 5 seconds.
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	// Instances is how many instances share the backend, for FailLocal: each
+	// enforces 1/Instances of the limit by itself, so that together they
+	// stay within it. Zero means 1.
+	Instances int
+}
+
+// BreakerState is where a circuit breaker stands.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Calling the backend
+	BreakerOpen                         // Not calling the backend
+	BreakerHalfOpen                     // Cooled down, waiting to try the backend again
+)
+
+// ResilientStore is a Store that keeps deciding when its backend is down,
+// slow, or failing, by its FailurePolicy. It is safe for concurrent use.
+type ResilientStore struct {
+	backend Store
+	cfg     ResilienceConfig
+	clock   Clock
+	local   *MemoryStore // For FailLocal
+
+	mu        sync.Mutex
+	failures  int       // Failed calls in a row
+	openUntil time.Time // When the open breaker lets a trial call through
+	trial     bool      // Whether a trial call is under way
+}
+
+// NewResilientStore returns a ResilientStore in front of backend. Only
+// WithClock applies.
+func NewResilientStore(backend Store, cfg ResilienceConfig, opts ...Option) (*ResilientStore, error) {
+	if backend == nil {
+		return nil, errors.New("resilient store needs a backend")
+	}
+	if cfg.Policy < FailOpen || cfg.Policy > FailLocal {
+		return nil, errors.New("unknown failure policy")
+	}
+	if cfg.Timeout < 0 || cfg.BreakerFailures < 0 || cfg.BreakerCooldown < 0 || cfg.Instances < 0 {
+		return nil, errors.New("resilience settings can't be negative")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 100 * time.Millisecond
+	}
+	if cfg.BreakerFailures == 0 {
+		cfg.BreakerFailures = 5
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = 5 * time.Second
+	}
+	cfg.Instances = max(1, cfg.Instances)
+	o := newOptions(opts)
+	return &ResilientStore{backend: backend, cfg: cfg, clock: o.clock, local: NewMemoryStore(opts...)}, nil
+}
+
+// Take asks the backend, within the timeout, unless the breaker is open. If
+// the backend fails, or isn't asked, the policy decides and the Decision is
+// marked Degraded. The only errors are for invalid limits and for ctx being
+// done.
+func (s *ResilientStore) Take(ctx context.Context, key string, l Limit, n int) (Decision, error) {
+	if err := l.validate(); err != nil {
+		return Decision{}, err
+	}
+	if !s.admit() {
+		return s.degraded(ctx, key, l, n)
+	}
+	callCtx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	d, err := s.backend.Take(callCtx, key, l, n)
+	cancel()
+	if err != nil && ctx.Err() != nil {
+		// The caller gave up; that says nothing about the backend.
+		s.abandon()
+		return Decision{}, ctx.Err()
+	}
+	s.record(err == nil)
+	if err != nil {
+		return s.degraded(ctx, key, l, n)
+	}
+	return d, nil
+}
+
+// State returns where the circuit breaker stands.
+func (s *ResilientStore) State() BreakerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.failures < s.cfg.BreakerFailures:
+		return BreakerClosed
+	case s.trial || s.clock.Now().Before(s.openUntil):
+		return BreakerOpen
+	}
+	return BreakerHalfOpen
+}
+
+// admit reports whether to call the backend: always while the breaker is
+// closed, and once it has cooled down, for one trial call at a time.
+func (s *ResilientStore) admit() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures < s.cfg.BreakerFailures {
+		return true
+	}
+	if s.trial || s.clock.Now().Before(s.openUntil) {
+		return false
+	}
+	s.trial = true
+	return true
+}
+
+// record counts a backend call's outcome, opening the breaker after too
+// many failures in a row, or again after a failed trial.
+func (s *ResilientStore) record(ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trial = false
+	if ok {
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if s.failures >= s.cfg.BreakerFailures {
+		s.openUntil = s.clock.Now().Add(s.cfg.BreakerCooldown)
+	}
+}
+
+// abandon ends a call that says nothing about the backend.
+func (s *ResilientStore) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trial = false
+}
+
+// degraded decides by policy, without the backend.
+func (s *ResilientStore) degraded(ctx context.Context, key string, l Limit, n int) (Decision, error) {
+	var d Decision
+	switch s.cfg.Policy {
+	case FailOpen:
+		d = Decision{Allowed: true, Limit: l.burst(), Remaining: l.burst()}
+	case FailClosed:
+		d = Decision{Limit: l.burst(), RetryAfter: s.retryAfter()}
+	case FailLocal:
+		d, _ = s.local.Take(ctx, key, share(l, s.cfg.Instances), n)
+	}
+	d.Degraded = true
+	return d, nil
+}
+
+// retryAfter is how long until the backend will next be tried.
+func (s *ResilientStore) retryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if wait := s.openUntil.Sub(s.clock.Now()); wait > 0 {
+		return wait
+	}
+	return s.cfg.Timeout
+}
+
+// share is one of instances' share of l, at least one request per period.
+func share(l Limit, instances int) Limit {
+	return Limit{Rate: max(1, l.Rate/instances), Per: l.Per, Burst: max(1, l.burst()/instances)}
+}
@@ -0,0 +1,226 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fault is a way for a backend to fail, after
+// FaultInjectedHTTPRateLimiterSimulator in Turn4B_test.go.
+type fault int
+
+const (
+	healthy   fault = iota
+	partition       // Every call fails at once
+	temporary       // The next few calls fail
+	slow            // Calls take longer than anyone waits
+)
+
+// faultStore is a Store with faults injected in front of it.
+type faultStore struct {
+	Store
+	mu       sync.Mutex
+	fault    fault
+	failNext int // For temporary
+	calls    int
+}
+
+func (s *faultStore) set(f fault, failNext int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fault, s.failNext = f, failNext
+}
+
+func (s *faultStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func (s *faultStore) Take(ctx context.Context, key string, l Limit, n int) (Decision, error) {
+	s.mu.Lock()
+	s.calls++
+	f := s.fault
+	if f == temporary {
+		if s.failNext == 0 {
+			f = healthy
+		} else {
+			s.failNext--
+		}
+	}
+	s.mu.Unlock()
+	switch f {
+	case partition:
+		return Decision{}, errors.New("connection refused")
+	case temporary:
+		return Decision{}, errors.New("try again")
+	case slow:
+		<-ctx.Done()
+		return Decision{}, ctx.Err()
+	}
+	return s.Store.Take(ctx, key, l, n)
+}
+
+func newResilientStore(t *testing.T, cfg ResilienceConfig) (*ResilientStore, *faultStore, *FakeClock) {
+	t.Helper()
+	clock := newFakeClock()
+	backend := &faultStore{Store: NewMemoryStore(WithClock(clock))}
+	s, err := NewResilientStore(backend, cfg, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, backend, clock
+}
+
+func TestResilientStorePolicies(t *testing.T) {
+	tests := []struct {
+		policy  FailurePolicy
+		fault   fault
+		allowed int
+	}{
+		{FailOpen, partition, 10},
+		{FailOpen, slow, 10},
+		{FailClosed, partition, 0},
+		{FailClosed, slow, 0},
+		{FailLocal, partition, 5}, // Half the limit, for one of two instances
+		{FailLocal, slow, 5},
+	}
+	for _, tt := range tests {
+		s, backend, _ := newResilientStore(t, ResilienceConfig{
+			Policy:          tt.policy,
+			Timeout:         10 * time.Millisecond,
+			BreakerFailures: 3,
+			Instances:       2,
+		})
+		backend.set(tt.fault, 0)
+		allowed := 0
+		for range 10 {
+			d, err := s.Take(context.Background(), "user1", Limit{Rate: 10}, 1)
+			if err != nil {
+				t.Fatalf("policy %d, fault %d: %v", tt.policy, tt.fault, err)
+			}
+			if !d.Degraded {
+				t.Errorf("policy %d, fault %d: expected a degraded decision, got %+v", tt.policy, tt.fault, d)
+			}
+			if d.Allowed {
+				allowed++
+			} else if tt.policy == FailClosed && d.RetryAfter <= 0 {
+				t.Errorf("policy %d: expected a time to retry after, got %+v", tt.policy, d)
+			}
+		}
+		if allowed != tt.allowed {
+			t.Errorf("policy %d, fault %d: expected %d allowed, got %d", tt.policy, tt.fault, tt.allowed, allowed)
+		}
+		if calls := backend.count(); calls != 3 {
+			t.Errorf("policy %d, fault %d: expected the breaker to stop calls after 3 failures, got %d calls", tt.policy, tt.fault, calls)
+		}
+	}
+}
+
+func TestResilientStoreBreaker(t *testing.T) {
+	s, backend, clock := newResilientStore(t, ResilienceConfig{Policy: FailClosed, BreakerFailures: 3, BreakerCooldown: 5 * time.Second})
+	take := func() Decision {
+		t.Helper()
+		d, err := s.Take(context.Background(), "user1", Limit{Rate: 100}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	// A failure or two only degrades the calls that fail.
+	backend.set(temporary, 2)
+	if take().Allowed || take().Allowed || !take().Allowed || s.State() != BreakerClosed {
+		t.Fatal("expected a brief failure to pass without opening the breaker")
+	}
+
+	backend.set(temporary, 4)
+	for range 3 {
+		take()
+	}
+	if s.State() != BreakerOpen {
+		t.Fatal("expected the breaker open after 3 failures in a row")
+	}
+	if d := take(); d.Allowed || d.RetryAfter != 5*time.Second {
+		t.Errorf("expected a refusal until the breaker cools down, got %+v", d)
+	}
+	if calls := backend.count(); calls != 6 {
+		t.Errorf("expected no calls while the breaker is open, got %d", calls)
+	}
+
+	// The trial after the cooldown fails, so the breaker opens again.
+	clock.Advance(5 * time.Second)
+	if s.State() != BreakerHalfOpen {
+		t.Fatal("expected the breaker half open after the cooldown")
+	}
+	if take().Allowed || s.State() != BreakerOpen || backend.count() != 7 {
+		t.Fatal("expected a failed trial to open the breaker again")
+	}
+	clock.Advance(5 * time.Second)
+	if d := take(); !d.Allowed || d.Degraded || s.State() != BreakerClosed {
+		t.Errorf("expected a successful trial to close the breaker, got %+v", d)
+	}
+}
+
+func TestResilientStoreCallerGivesUp(t *testing.T) {
+	s, backend, _ := newResilientStore(t, ResilienceConfig{Policy: FailOpen, Timeout: time.Minute, BreakerFailures: 1})
+	backend.set(slow, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Take(ctx, "user1", Limit{Rate: 1}, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the caller's own deadline, got %v", err)
+	}
+	if s.State() != BreakerClosed {
+		t.Error("expected the caller giving up not to count against the backend")
+	}
+
+	if _, err := s.Take(context.Background(), "user1", Limit{}, 1); err == nil {
+		t.Error("expected an invalid limit to be refused")
+	}
+	if _, err := NewResilientStore(backend, ResilienceConfig{Policy: FailurePolicy(9)}); err == nil {
+		t.Error("expected an unknown policy to be refused")
+	}
+}
+
+func TestResilientStoreOverRedis(t *testing.T) {
+	clock := newFakeClock()
+	f := newFakeRedis(t, clock)
+	backend := NewRedisStore(f.addr(), "")
+	defer backend.Close()
+	s, err := NewResilientStore(backend, ResilienceConfig{Policy: FailLocal, Timeout: 20 * time.Millisecond, Instances: 4}, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := NewDistributedLimiter(s, Limit{Rate: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		n := 0
+		for range 10 {
+			if ok, _ := d.Allow(context.Background(), "user1"); ok {
+				n++
+			}
+		}
+		return n
+	}
+
+	if got := count(); got != 8 {
+		t.Errorf("expected the shared limit with the server up, got %d", got)
+	}
+	f.mu.Lock()
+	f.stall = true
+	f.mu.Unlock()
+	clock.Advance(time.Second)
+	if got := count(); got != 2 {
+		t.Errorf("expected a quarter of the limit locally with the server stalled, got %d", got)
+	}
+	f.close()
+	clock.Advance(time.Second)
+	if got := count(); got != 2 {
+		t.Errorf("expected a quarter of the limit locally with the server gone, got %d", got)
+	}
+}
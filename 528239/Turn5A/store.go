@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps limits' state where every instance of a service can share
+// it, so that together they enforce one limit per key.
+//
+// Limits are enforced with GCRA, whose state is a single timestamp per key.
+type Store interface {
+	// Take takes n requests from key's limit if they all fit now, as one
+	// atomic check-and-decrement: requests taken concurrently, from any
+	// instance, never add up to more than the limit allows. The Decision
+	// says how the limit stands either way.
+	Take(ctx context.Context, key string, l Limit, n int) (Decision, error)
+}
+
+// MemoryStore is a Store in this process's memory, for tests and for
+// services that run as a single instance.
+type MemoryStore struct {
+	clock Clock
+	mu    sync.Mutex
+	tats  map[string]time.Time // Only for keys whose limit isn't back to full
+}
+
+// NewMemoryStore returns an empty MemoryStore. Only WithClock applies.
+func NewMemoryStore(opts ...Option) *MemoryStore {
+	return &MemoryStore{clock: newOptions(opts).clock, tats: make(map[string]time.Time)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, l Limit, n int) (Decision, error) {
+	if err := l.validate(); err != nil {
+		return Decision{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	g := newGCRA(l, now)
+	g.tat = s.tats[key]
+	d := decide(g, now, n)
+	// A key back to full is no different from one never seen.
+	if g.tat.After(now) {
+		s.tats[key] = g.tat
+	} else {
+		delete(s.tats, key)
+	}
+	return d, nil
+}
+
+// Len returns how many keys the store holds state for.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tats)
+}
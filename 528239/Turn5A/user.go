@@ -0,0 +1,325 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"hash/maphash"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Rule is a limit together with the algorithm that enforces it.
+type Rule struct {
+	Algorithm Algorithm
+	Limit     Limit
+}
+
+// shardCount is how many independently locked parts a UserRateLimiter's
+// keys are spread over, so that requests for different keys rarely contend.
+const shardCount = 64
+
+// UserRateLimiter keeps a separate limit for each user, each with its own
+// algorithm.
+//
+// Each user's rule is kept apart from their limiter's state. State is only
+// kept while it matters: once a user's limit is back to full and they have
+// been idle for a while, their limiter is dropped, to be started afresh from
+// their rule if they come back.
+type UserRateLimiter struct {
+	opts   options
+	seed   maphash.Seed
+	shards [shardCount]shard
+	keys   atomic.Int64 // Kept in all the shards
+
+	// With a cap on keys, every key is also kept in one list, most recently
+	// used first, so the least recently used of all can go. capMu guards it,
+	// and is taken before any shard's lock.
+	capMu sync.Mutex
+	lru   list.List // Of *entry
+
+	mu       sync.RWMutex
+	rules    map[string]Rule
+	fallback *Rule // For users without a rule of their own; nil to refuse them
+}
+
+// shard holds the limiters for some of the keys, most recently used first.
+type shard struct {
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       list.List // Of *entry
+	lastSweep time.Time
+	keys      *atomic.Int64 // The UserRateLimiter's
+	all       *list.List    // The UserRateLimiter's list of every key
+}
+
+type entry struct {
+	key  string
+	rule Rule // That lim enforces
+	lim  *limiter
+	used time.Time
+	all  *list.Element // In the list of every key, with a cap
+}
+
+// NewUserRateLimiter returns a UserRateLimiter with no users. The options
+// apply to every user's limiter.
+func NewUserRateLimiter(opts ...Option) *UserRateLimiter {
+	o := newOptions(opts)
+	u := &UserRateLimiter{
+		opts:  o,
+		seed:  maphash.MakeSeed(),
+		rules: make(map[string]Rule),
+	}
+	now := o.clock.Now()
+	for i := range u.shards {
+		u.shards[i].entries = make(map[string]*list.Element)
+		u.shards[i].lastSweep = now
+		u.shards[i].keys = &u.keys
+		u.shards[i].all = &u.lru
+	}
+	return u
+}
+
+// SetRateLimit gives userID a fresh limit of l, enforced with algorithm a.
+func (u *UserRateLimiter) SetRateLimit(userID string, a Algorithm, l Limit) error {
+	if _, err := newLimiter(a, l, u.opts); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.rules[userID] = Rule{Algorithm: a, Limit: l}
+	u.mu.Unlock()
+	u.forget(userID)
+	return nil
+}
+
+// SetDefaultLimit gives each user without a limit of their own a fresh
+// limit of l, enforced with algorithm a, on their first request.
+func (u *UserRateLimiter) SetDefaultLimit(a Algorithm, l Limit) error {
+	if _, err := newLimiter(a, l, u.opts); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fallback = &Rule{Algorithm: a, Limit: l}
+	return nil
+}
+
+// SetRules replaces every user's rule, and the default, at once. Users
+// whose algorithm and period stay the same keep their limiter's state under
+// the new rate and burst; the others start afresh.
+func (u *UserRateLimiter) SetRules(rules map[string]Rule, fallback *Rule) error {
+	for userID, rule := range rules {
+		if _, err := newLimiter(rule.Algorithm, rule.Limit, u.opts); err != nil {
+			return fmt.Errorf("user %q: %w", userID, err)
+		}
+	}
+	if fallback != nil {
+		if _, err := newLimiter(fallback.Algorithm, fallback.Limit, u.opts); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+		fallback = &Rule{Algorithm: fallback.Algorithm, Limit: fallback.Limit}
+	}
+	rules = maps.Clone(rules)
+	if rules == nil {
+		rules = make(map[string]Rule)
+	}
+	u.mu.Lock()
+	u.rules, u.fallback = rules, fallback
+	u.mu.Unlock()
+
+	// Limiters started since the swap already follow the new rules.
+	for i := range u.shards {
+		s := &u.shards[i]
+		u.lock(s)
+		for el := s.lru.Front(); el != nil; {
+			next := el.Next()
+			e := el.Value.(*entry)
+			rule, ok := rules[e.key]
+			if !ok && fallback != nil {
+				rule, ok = *fallback, true
+			}
+			switch {
+			case !ok:
+				s.remove(el)
+			case rule == e.rule:
+			case rule.Algorithm == e.rule.Algorithm && e.lim.retune(rule.Limit):
+				e.rule = rule
+			default:
+				e.rule = rule
+				e.lim, _ = newLimiter(rule.Algorithm, rule.Limit, u.opts)
+			}
+			el = next
+		}
+		u.unlock(s)
+	}
+	return nil
+}
+
+// RemoveUser forgets userID's limit.
+func (u *UserRateLimiter) RemoveUser(userID string) {
+	u.mu.Lock()
+	delete(u.rules, userID)
+	u.mu.Unlock()
+	u.forget(userID)
+}
+
+func (u *UserRateLimiter) rule(userID string) (Rule, bool) {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if rule, ok := u.rules[userID]; ok {
+		return rule, true
+	}
+	if u.fallback != nil {
+		return *u.fallback, true
+	}
+	return Rule{}, false
+}
+
+func (u *UserRateLimiter) shard(key string) *shard {
+	return &u.shards[maphash.String(u.seed, key)%shardCount]
+}
+
+// lock locks s, and with a cap on keys, the list of every key first.
+func (u *UserRateLimiter) lock(s *shard) {
+	if u.opts.maxKeys > 0 {
+		u.capMu.Lock()
+	}
+	s.mu.Lock()
+}
+
+func (u *UserRateLimiter) unlock(s *shard) {
+	s.mu.Unlock()
+	if u.opts.maxKeys > 0 {
+		u.capMu.Unlock()
+	}
+}
+
+// limiter returns userID's limiter, starting one from their rule if there
+// is none.
+func (u *UserRateLimiter) limiter(userID string) (*limiter, bool) {
+	if u.opts.maxKeys == 0 {
+		lim, _, ok := u.lookup(userID)
+		return lim, ok
+	}
+	// Holding the list of every key throughout, each key added past the cap
+	// makes exactly one go.
+	u.capMu.Lock()
+	defer u.capMu.Unlock()
+	lim, added, ok := u.lookup(userID)
+	if added && u.lru.Len() > u.opts.maxKeys {
+		e := u.lru.Back().Value.(*entry)
+		s := u.shard(e.key)
+		s.mu.Lock()
+		s.remove(s.entries[e.key])
+		s.mu.Unlock()
+	}
+	return lim, ok
+}
+
+// lookup is limiter, also reporting whether it added userID. With a cap on
+// keys, callers hold u.capMu.
+func (u *UserRateLimiter) lookup(userID string) (lim *limiter, added, ok bool) {
+	now := u.opts.clock.Now()
+	s := u.shard(userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[userID]; ok {
+		e := el.Value.(*entry)
+		e.used = now
+		s.lru.MoveToFront(el)
+		if e.all != nil {
+			u.lru.MoveToFront(e.all)
+		}
+		return e.lim, false, true
+	}
+
+	rule, ok := u.rule(userID)
+	if !ok {
+		return nil, false, false
+	}
+	// Checked when the rule was set, so this can't fail.
+	lim, _ = newLimiter(rule.Algorithm, rule.Limit, u.opts)
+	// Sweeping as keys are added, at most once per idle timeout, keeps
+	// memory in check without a goroutine of its own.
+	if now.Sub(s.lastSweep) >= u.opts.idleTimeout {
+		s.sweep(now, u.opts.idleTimeout)
+	}
+	e := &entry{key: userID, rule: rule, lim: lim, used: now}
+	if u.opts.maxKeys > 0 {
+		e.all = u.lru.PushFront(e)
+	}
+	s.entries[userID] = s.lru.PushFront(e)
+	u.keys.Add(1)
+	return lim, true, true
+}
+
+// forget drops userID's limiter, if there is one.
+func (u *UserRateLimiter) forget(userID string) {
+	s := u.shard(userID)
+	u.lock(s)
+	defer u.unlock(s)
+	if el, ok := s.entries[userID]; ok {
+		s.remove(el)
+	}
+}
+
+// Sweep drops the limiters of users idle for the idle timeout whose limits
+// are back to full. It also happens in passing as new users arrive.
+func (u *UserRateLimiter) Sweep() {
+	now := u.opts.clock.Now()
+	for i := range u.shards {
+		s := &u.shards[i]
+		u.lock(s)
+		s.sweep(now, u.opts.idleTimeout)
+		u.unlock(s)
+	}
+}
+
+// Len returns how many users' limiters are being kept.
+func (u *UserRateLimiter) Len() int { return int(u.keys.Load()) }
+
+// sweep drops the limiters unused since now-timeout that are back to full.
+// Callers hold s.mu, and with a cap on keys, the UserRateLimiter's capMu.
+func (s *shard) sweep(now time.Time, timeout time.Duration) {
+	s.lastSweep = now
+	for el := s.lru.Back(); el != nil; {
+		e := el.Value.(*entry)
+		if now.Sub(e.used) < timeout {
+			break
+		}
+		prev := el.Prev()
+		if e.lim.idle() {
+			s.remove(el)
+		}
+		el = prev
+	}
+}
+
+// remove drops an entry. Callers hold s.mu, and with a cap on keys, the
+// UserRateLimiter's capMu.
+func (s *shard) remove(el *list.Element) {
+	e := s.lru.Remove(el).(*entry)
+	delete(s.entries, e.key)
+	if e.all != nil {
+		s.all.Remove(e.all)
+	}
+	s.keys.Add(-1)
+}
+
+// Allow reports whether userID may make a request now. Users without a
+// limit may not, unless there is a default limit.
+func (u *UserRateLimiter) Allow(userID string) bool {
+	lim, ok := u.limiter(userID)
+	return ok && lim.Allow()
+}
+
+// AllowN is Allow for n requests at once, with the Decision of userID's
+// limiter. Users refused for want of a limit get no time to retry after.
+func (u *UserRateLimiter) AllowN(userID string, n int) Decision {
+	lim, ok := u.limiter(userID)
+	if !ok {
+		return Decision{}
+	}
+	return lim.AllowN(n)
+}
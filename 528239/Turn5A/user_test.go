@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUserRateLimiterAlgorithmPerUser(t *testing.T) {
+	clock := newFakeClock()
+	ul := NewUserRateLimiter(WithClock(clock))
+	if err := ul.SetRateLimit("bursty", TokenBucket, Limit{Rate: 1, Burst: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ul.SetRateLimit("smooth", LeakyBucket, Limit{Rate: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ul.SetRateLimit("broken", SlidingLog, Limit{}); err == nil {
+		t.Error("expected an invalid limit to be refused")
+	}
+
+	count := func(user string) int {
+		n := 0
+		for range 10 {
+			if ul.Allow(user) {
+				n++
+			}
+		}
+		return n
+	}
+	if got := count("bursty"); got != 3 {
+		t.Errorf("bursty: expected a burst of 3, got %d", got)
+	}
+	if got := count("smooth"); got != 1 {
+		t.Errorf("smooth: expected 1 request at once, got %d", got)
+	}
+	clock.Advance(time.Second)
+	if got := count("bursty"); got != 1 {
+		t.Errorf("bursty: expected 1 request a second later, got %d", got)
+	}
+
+	if count("unknown") != 0 || count("broken") != 0 {
+		t.Error("expected users without a limit to be refused")
+	}
+	ul.RemoveUser("smooth")
+	clock.Advance(time.Second)
+	if ul.Allow("smooth") {
+		t.Error("expected a removed user to be refused")
+	}
+}
+
+func TestUserRateLimiterForgetsIdleUsers(t *testing.T) {
+	clock := newFakeClock()
+	ul := NewUserRateLimiter(WithClock(clock), WithIdleTimeout(time.Minute))
+	if err := ul.SetDefaultLimit(TokenBucket, Limit{Rate: 1, Per: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ul.SetRateLimit("slow", TokenBucket, Limit{Rate: 1, Per: time.Hour, Burst: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 1000 {
+		ul.Allow(fmt.Sprint("user", i))
+	}
+	ul.Allow("slow")
+	if n := ul.Len(); n != 1001 {
+		t.Fatalf("expected 1001 users tracked, got %d", n)
+	}
+
+	clock.Advance(30 * time.Second)
+	ul.Sweep()
+	if n := ul.Len(); n != 1001 {
+		t.Errorf("expected no one forgotten before the idle timeout, got %d left", n)
+	}
+	clock.Advance(30 * time.Second)
+	ul.Sweep()
+	if n := ul.Len(); n != 1 {
+		t.Errorf("expected only the user still refilling to be kept, got %d", n)
+	}
+	// Its state is intact: one of its burst of two is used.
+	if !ul.Allow("slow") || ul.Allow("slow") {
+		t.Error("expected the kept user's state to carry on")
+	}
+
+	// New users sweep as they arrive, and forgotten users come back fresh.
+	clock.Advance(2 * time.Minute)
+	for i := range 1000 {
+		if !ul.Allow(fmt.Sprint("other", i)) {
+			t.Fatal("expected a new user to be allowed")
+		}
+	}
+	if n := ul.Len(); n > 1010 {
+		t.Errorf("expected idle users swept as new ones arrive, got %d tracked", n)
+	}
+	if !ul.Allow("user1") {
+		t.Error("expected a forgotten user to start afresh")
+	}
+}
+
+func TestUserRateLimiterCapsKeys(t *testing.T) {
+	for _, maxKeys := range []int{10, 100, 640, 1000} {
+		clock := newFakeClock()
+		ul := NewUserRateLimiter(WithClock(clock), WithMaxKeys(maxKeys))
+		if err := ul.SetDefaultLimit(GCRA, Limit{Rate: 1, Per: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ul.SetRateLimit("vip", GCRA, Limit{Rate: 5, Per: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+		ul.Allow("vip")
+		for i := range 5000 {
+			clock.Advance(time.Millisecond)
+			ul.Allow(fmt.Sprint("user", i))
+			if i%5 == 0 {
+				ul.AllowN("vip", 0)
+			}
+		}
+		if n := ul.Len(); n != maxKeys {
+			t.Errorf("cap %d: expected exactly that many users tracked, got %d", maxKeys, n)
+		}
+		// The least recently used go first, wherever they are kept, but
+		// their rules stay.
+		vip, _ := ul.limiter("vip")
+		if got := drain(vip); got != 4 {
+			t.Errorf("cap %d: expected a recent user kept, got %d left", maxKeys, got)
+		}
+		first, _ := ul.limiter("user0")
+		if got := drain(first); got != 1 {
+			t.Errorf("cap %d: expected a forgotten user to start afresh, got %d left", maxKeys, got)
+		}
+
+		// Keys added at once never take it past the cap, nor below.
+		var wg sync.WaitGroup
+		for g := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range 500 {
+					ul.Allow(fmt.Sprint("racer", g, "-", i))
+					if n := ul.Len(); n > maxKeys {
+						t.Errorf("cap %d: expected at most that many users tracked, got %d", maxKeys, n)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if n := ul.Len(); n != maxKeys {
+			t.Errorf("cap %d: expected exactly that many users tracked after concurrent adds, got %d", maxKeys, n)
+		}
+	}
+}
+
+func TestUserRateLimiterSetRules(t *testing.T) {
+	// How many more requests fit at once after the rate doubles: the buckets
+	// keep what they had left, and the windows what they had counted.
+	tests := []struct {
+		algorithm Algorithm
+		after     int
+	}{
+		{TokenBucket, 0},
+		{LeakyBucket, 0},
+		{FixedWindow, 4},
+		{SlidingLog, 4},
+		{SlidingWindow, 4},
+		{GCRA, 0},
+	}
+	for _, tt := range tests {
+		clock := newFakeClock()
+		ul := NewUserRateLimiter(WithClock(clock))
+		slow := Rule{tt.algorithm, Limit{Rate: 4}}
+		if err := ul.SetRules(map[string]Rule{"alice": slow, "bob": slow}, nil); err != nil {
+			t.Fatal(err)
+		}
+		alice, _ := ul.limiter("alice")
+		drain(alice)
+		ul.limiter("bob")
+
+		fast := Rule{tt.algorithm, Limit{Rate: 8}}
+		if err := ul.SetRules(map[string]Rule{"alice": fast}, nil); err != nil {
+			t.Fatal(err)
+		}
+		alice, _ = ul.limiter("alice")
+		if got := drain(alice); got != tt.after {
+			t.Errorf("%s: expected %d more requests under the new rate, got %d", tt.algorithm, tt.after, got)
+		}
+		if _, ok := ul.limiter("bob"); ok {
+			t.Errorf("%s: expected a user without a rule any more to be dropped", tt.algorithm)
+		}
+		if tt.after == 0 {
+			continue
+		}
+		// The windows can't carry their counts over to a new period.
+		clock.Advance(time.Second)
+		drain(alice)
+		if err := ul.SetRules(map[string]Rule{"alice": {tt.algorithm, Limit{Rate: 8, Per: 2 * time.Second}}}, &fast); err != nil {
+			t.Fatal(err)
+		}
+		alice, _ = ul.limiter("alice")
+		if got := drain(alice); got != 8 {
+			t.Errorf("%s: expected a new period to start afresh, got %d", tt.algorithm, got)
+		}
+	}
+
+	ul := NewUserRateLimiter()
+	if err := ul.SetRules(map[string]Rule{"alice": {TokenBucket, Limit{}}}, nil); err == nil {
+		t.Error("expected an invalid rule to be refused")
+	}
+}
+
+func BenchmarkUserRateLimiterAllow(b *testing.B) {
+	for _, bb := range []struct{ keys, maxKeys int }{
+		{1, 0},
+		{1_000, 0},
+		{1_000_000, 0},
+		{1_000_000, 100_000},
+	} {
+		keys := bb.keys
+		name := fmt.Sprintf("keys=%d", keys)
+		if bb.maxKeys > 0 {
+			name += fmt.Sprintf("/max=%d", bb.maxKeys)
+		}
+		b.Run(name, func(b *testing.B) {
+			ul := NewUserRateLimiter(WithMaxKeys(bb.maxKeys))
+			if err := ul.SetDefaultLimit(TokenBucket, Limit{Rate: 1_000_000}); err != nil {
+				b.Fatal(err)
+			}
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = fmt.Sprint("user", i)
+			}
+			var seed atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				rng := rand.New(rand.NewSource(seed.Add(1)))
+				for pb.Next() {
+					ul.Allow(names[rng.Intn(keys)])
+				}
+			})
+		})
+	}
+}
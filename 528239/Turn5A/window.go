@@ -0,0 +1,273 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+// windowCounts counts requests in consecutive windows of one period, aligned
+// to the clock: the current window, the one before it, and any later ones
+// that hold reservations.
+type windowCounts struct {
+	period time.Duration
+	start  time.Time // Of the current window
+	prev   int       // Requests in the window before the current one
+	counts []int     // counts[i] is for the window i periods after the current one
+}
+
+func newWindowCounts(period time.Duration, now time.Time) windowCounts {
+	return windowCounts{period: period, start: now.Truncate(period)}
+}
+
+// advance makes the window holding now the current one.
+func (w *windowCounts) advance(now time.Time) {
+	k := w.index(now)
+	if k <= 0 {
+		return
+	}
+	w.prev = w.count(k - 1)
+	w.counts = w.counts[min(k, len(w.counts)):]
+	w.start = w.start.Add(time.Duration(k) * w.period)
+}
+
+// index returns which window, counting from the current one, holds t.
+func (w *windowCounts) index(t time.Time) int {
+	return int(t.Truncate(w.period).Sub(w.start) / w.period)
+}
+
+func (w *windowCounts) count(i int) int {
+	if i < len(w.counts) {
+		return w.counts[i]
+	}
+	return 0
+}
+
+func (w *windowCounts) add(i, n int) {
+	for len(w.counts) <= i {
+		w.counts = append(w.counts, 0)
+	}
+	w.counts[i] += n
+}
+
+// remove takes back n requests counted for the window holding at.
+func (w *windowCounts) remove(at time.Time, n int) {
+	if i := w.index(at); i >= 0 && i < len(w.counts) {
+		w.counts[i] = max(0, w.counts[i]-n)
+	}
+	for len(w.counts) > 0 && w.counts[len(w.counts)-1] == 0 {
+		w.counts = w.counts[:len(w.counts)-1]
+	}
+}
+
+// queued is the first window new requests may go in: the last one with
+// reservations, so that nothing jumps ahead of them.
+func (w *windowCounts) queued() int { return max(0, len(w.counts)-1) }
+
+// fixedWindow counts requests in windows aligned to the clock, such as each
+// whole second. It is cheap, but allows up to twice the rate across the
+// boundary between two windows.
+type fixedWindow struct {
+	limit int
+	windowCounts
+}
+
+func newFixedWindow(l Limit, now time.Time) *fixedWindow {
+	return &fixedWindow{limit: l.Rate, windowCounts: newWindowCounts(l.period(), now)}
+}
+
+func (w *fixedWindow) earliest(now time.Time, n int) (time.Time, bool) {
+	if n > w.limit {
+		return time.Time{}, false
+	}
+	w.advance(now)
+	i := w.queued()
+	for w.count(i)+n > w.limit {
+		i++
+	}
+	if i == 0 {
+		return now, true
+	}
+	return w.start.Add(time.Duration(i) * w.period), true
+}
+
+func (w *fixedWindow) take(_, at time.Time, n int) { w.add(w.index(at), n) }
+
+func (w *fixedWindow) cancel(now, at time.Time, n int) {
+	if at.After(now) {
+		w.advance(now)
+		w.remove(at, n)
+	}
+}
+
+func (w *fixedWindow) full(now time.Time) time.Time {
+	w.advance(now)
+	if len(w.counts) == 0 {
+		return now
+	}
+	return w.start.Add(time.Duration(len(w.counts)) * w.period)
+}
+
+func (w *fixedWindow) capacity() int { return w.limit }
+
+// retune keeps the counts as long as the windows stay the same.
+func (w *fixedWindow) retune(_ time.Time, l Limit) bool {
+	if l.period() != w.period {
+		return false
+	}
+	w.limit = l.Rate
+	return true
+}
+
+// slidingLog remembers when each allowed request was made, so it can hold
+// every window of the period, wherever it starts, to the limit. It costs a
+// timestamp per request.
+type slidingLog struct {
+	limit  int
+	period time.Duration
+	log    []time.Time // Oldest first, reservations last
+}
+
+func newSlidingLog(l Limit) *slidingLog {
+	return &slidingLog{limit: l.Rate, period: l.period(), log: make([]time.Time, 0, l.Rate)}
+}
+
+// expire forgets the requests whose period has passed by now.
+func (w *slidingLog) expire(now time.Time) {
+	expired := 0
+	for expired < len(w.log) && !w.log[expired].Add(w.period).After(now) {
+		expired++
+	}
+	w.log = append(w.log[:0], w.log[expired:]...)
+}
+
+func (w *slidingLog) earliest(now time.Time, n int) (time.Time, bool) {
+	if n > w.limit {
+		return time.Time{}, false
+	}
+	w.expire(now)
+	at := now
+	if m := len(w.log); m > 0 {
+		// After any reservations, and once enough requests have expired.
+		at = later(at, w.log[m-1])
+		if over := m + n - w.limit; over > 0 {
+			at = later(at, w.log[over-1].Add(w.period))
+		}
+	}
+	return at, true
+}
+
+func (w *slidingLog) take(_, at time.Time, n int) {
+	for range n {
+		w.log = append(w.log, at)
+	}
+}
+
+func (w *slidingLog) cancel(now, at time.Time, n int) {
+	if !at.After(now) {
+		return
+	}
+	for i := len(w.log) - 1; i >= 0 && n > 0; i-- {
+		if w.log[i].Equal(at) {
+			w.log = append(w.log[:i], w.log[i+1:]...)
+			n--
+		}
+	}
+}
+
+func (w *slidingLog) full(now time.Time) time.Time {
+	w.expire(now)
+	if len(w.log) == 0 {
+		return now
+	}
+	return w.log[len(w.log)-1].Add(w.period)
+}
+
+func (w *slidingLog) capacity() int { return w.limit }
+
+// retune keeps the log as long as the period stays the same.
+func (w *slidingLog) retune(_ time.Time, l Limit) bool {
+	if l.period() != w.period {
+		return false
+	}
+	w.limit = l.Rate
+	return true
+}
+
+// slidingWindow estimates how many requests the last period saw from the
+// counts of the current and previous fixed windows, weighting the previous
+// one by how much of it the period still covers. It smooths out the fixed
+// window's boundary bursts with two counters instead of a log.
+type slidingWindow struct {
+	limit int
+	windowCounts
+}
+
+func newSlidingWindow(l Limit, now time.Time) *slidingWindow {
+	return &slidingWindow{limit: l.Rate, windowCounts: newWindowCounts(l.period(), now)}
+}
+
+func (w *slidingWindow) earliest(now time.Time, n int) (time.Time, bool) {
+	if n > w.limit {
+		return time.Time{}, false
+	}
+	w.advance(now)
+	for i := w.queued(); ; i++ {
+		prev := w.prev
+		if i > 0 {
+			prev = w.count(i - 1)
+		}
+		room := w.limit - w.count(i) - n
+		if room < 0 {
+			continue
+		}
+		// The previous window's weight falls as the window goes on; find
+		// how far in it has fallen enough to leave room.
+		var offset time.Duration
+		if prev > room {
+			offset = time.Duration(math.Ceil(float64(w.period) * float64(prev-room) / float64(prev)))
+			if offset >= w.period {
+				continue
+			}
+		}
+		return later(now, w.start.Add(time.Duration(i)*w.period+offset)), true
+	}
+}
+
+func (w *slidingWindow) take(_, at time.Time, n int) { w.add(w.index(at), n) }
+
+func (w *slidingWindow) cancel(now, at time.Time, n int) {
+	if at.After(now) {
+		w.advance(now)
+		w.remove(at, n)
+	}
+}
+
+func (w *slidingWindow) full(now time.Time) time.Time {
+	w.advance(now)
+	switch {
+	case len(w.counts) > 0:
+		// The last counted window, and the one it weighs on.
+		return w.start.Add(time.Duration(len(w.counts)+1) * w.period)
+	case w.prev > 0:
+		return w.start.Add(w.period)
+	}
+	return now
+}
+
+func (w *slidingWindow) capacity() int { return w.limit }
+
+// retune keeps the counts as long as the windows stay the same.
+func (w *slidingWindow) retune(_ time.Time, l Limit) bool {
+	if l.period() != w.period {
+		return false
+	}
+	w.limit = l.Rate
+	return true
+}
+
+func later(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}